@@ -0,0 +1,73 @@
+// mockrotom runs the fake Rotom server from internal/rotomtest on a fixed
+// address, for poking at a worker by hand:
+//
+//	go run ./cmd/mockrotom -addr :9001 -secret s3cret -status retry -drop-every 10
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"rotomworker/internal/rotomtest"
+	rotompb "rotomworker/proto_gen"
+)
+
+func main() {
+	addr := flag.String("addr", ":9001", "listen address")
+	secret := flag.String("secret", "", "expected bearer secret (empty accepts anything)")
+	status := flag.String("status", "success", "reply status: success|error|retry|stopped|reconnect|none")
+	delay := flag.Duration("delay", 0, "delay before each reply")
+	dropEvery := flag.Int("drop-every", 0, "drop the /data connection on every Nth request (0 = never)")
+	ack := flag.Bool("ack", false, "send {\"ack\": id} text frames (rotom.ack_mode = frame)")
+	flag.Parse()
+
+	st, ok := parseStatus(*status)
+	if !ok {
+		log.Fatalf("unknown -status %q", *status)
+	}
+
+	srv := rotomtest.New(*secret)
+	srv.Logf = log.Printf
+	var n atomic.Int64
+	srv.Respond(func(req *rotompb.MitmRequest) rotomtest.Action {
+		i := n.Add(1)
+		log.Printf("[mockrotom] request #%d id=%d method=%s", i, req.GetId(), req.GetMethod())
+		if *dropEvery > 0 && i%int64(*dropEvery) == 0 {
+			return rotomtest.Action{Drop: true}
+		}
+		return rotomtest.Action{Status: st, Delay: *delay, Ack: *ack}
+	})
+
+	// print a short summary now and then
+	go func() {
+		for range time.Tick(30 * time.Second) {
+			log.Printf("[mockrotom] welcomes=%d requests=%d control=%d rejected=%d",
+				len(srv.Welcomes()), len(srv.Requests()), len(srv.ControlMessages()), srv.Rejected())
+		}
+	}()
+
+	log.Printf("[mockrotom] listening on %s (/control, /data)", *addr)
+	log.Fatal(http.ListenAndServe(*addr, srv))
+}
+
+func parseStatus(s string) (rotompb.MitmResponse_Status, bool) {
+	switch strings.ToLower(s) {
+	case "success":
+		return rotompb.MitmResponse_SUCCESS, true
+	case "error":
+		return rotompb.MitmResponse_ERROR_UNKNOWN, true
+	case "retry":
+		return rotompb.MitmResponse_ERROR_RETRY_LATER, true
+	case "stopped":
+		return rotompb.MitmResponse_ERROR_WORKER_STOPPED, true
+	case "reconnect":
+		return rotompb.MitmResponse_ERROR_RECONNECT, true
+	case "none":
+		return rotompb.MitmResponse_UNSET, true
+	}
+	return 0, false
+}
@@ -4,6 +4,7 @@ go 1.22
 
 require (
 	github.com/gorilla/websocket v1.5.3
+	github.com/klauspost/compress v1.17.9
 	github.com/sirupsen/logrus v1.9.3
 	google.golang.org/protobuf v1.34.2
 )
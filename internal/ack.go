@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+// AckMode define quando um item enviado é considerado entregue.
+type AckMode string
+
+const (
+	// AckNone: entregue assim que WriteMessage retorna (comportamento antigo).
+	AckNone AckMode = "none"
+	// AckResponse: entregue quando chega a MitmResponse de mesmo id.
+	AckResponse AckMode = "response"
+	// AckFrame: entregue quando chega um frame de texto {"<ack_field>": id}
+	// ou, para itens sem id, {"<ack_seq_field>": n} (o n-ésimo frame binário).
+	AckFrame AckMode = "frame"
+)
+
+// inflightFiles marca arquivos já enviados que aguardam confirmação; o scanner
+// não deve enfileirá-los de novo.
+var inflightFiles sync.Map
+
+func markInFlight(path string) {
+	if path != "" {
+		inflightFiles.Store(path, time.Now())
+	}
+}
+
+func clearInFlight(path string) {
+	if path != "" {
+		inflightFiles.Delete(path)
+	}
+}
+
+// IsInFlight informa se path foi enviado e ainda aguarda ack.
+func IsInFlight(path string) bool {
+	_, ok := inflightFiles.Load(path)
+	return ok
+}
+
+// awaitsAck informa se um item enviado por w deve esperar confirmação. No
+// modo "frame", itens sem MitmRequest.id são confirmados pela sequência do
+// frame (rotom.ack_seq_field). No modo "response" eles não têm como ser
+// confirmados: seguem para o cleanup, com um aviso.
+func (w *DataWorker) awaitsAck(job *SendJob) bool {
+	if job.Item.OnResult != nil {
+		return false
+	}
+	switch {
+	case w.ackMode == AckFrame:
+		return true
+	case w.ackMode == AckResponse && job.RequestID != 0:
+		return true
+	case w.ackMode == AckResponse:
+		metrics.Inc("ack.unackable")
+		w.logger.Warnf("[%s] %s has no MitmRequest id; ack_mode %q cannot confirm it, cleaning up after write", w.ID, describeItem(job.Item), w.ackMode)
+	}
+	return false
+}
+
+// trackSeqAck registra a espera pelo ack do frame seq, que leva os itens sem
+// id de jobs. Roda com writeMu preso, antes da escrita.
+func (w *DataWorker) trackSeqAck(seq uint32, jobs []*SendJob) {
+	for _, job := range jobs {
+		job.AckSeq = seq
+	}
+	w.seqAcks.Track(seq, w.ackTimeout(), jobs[0].Item, func(r PendingResult) {
+		for _, job := range jobs {
+			r.Item = job.Item
+			w.onAckResult(r)
+		}
+	})
+}
+
+// handleAckFrame trata um frame de texto de ack: {"<ack_field>": id} ou
+// {"<ack_seq_field>": seq}. Retorna false se msg não for um ack.
+func (w *DataWorker) handleAckFrame(msg []byte) bool {
+	if id, ok := parseAckFrame(msg, w.cfg.Rotom.AckField); ok {
+		w.acks.ResolveID(id)
+		return true
+	}
+	if seq, ok := parseAckFrame(msg, w.cfg.Rotom.AckSeqField); ok {
+		w.seqAcks.ResolveID(seq)
+		return true
+	}
+	return false
+}
+
+// ackTimeout é o prazo para a confirmação no modo ack.
+func (w *DataWorker) ackTimeout() time.Duration {
+	return time.Duration(w.cfg.Rotom.AckTimeoutMs) * time.Millisecond
+}
+
+// onAckResult conclui um item que aguardava confirmação: com ack ele é
+// removido; sem ack (timeout/queda) ou com ERROR_UNKNOWN a tentativa conta
+// como falha e o item volta para pendente (o arquivo fica).
+func (w *DataWorker) onAckResult(r PendingResult) {
+	switch status := r.Resp.GetStatus(); {
+	case r.Err != nil:
+		metrics.Inc("ack.released")
+		w.logger.Warnf("[%s] no ack for %s (id=%d): %v; back to pending", w.ID, describeItem(r.Item), r.ID, r.Err)
+		failItem(r.Item, r.Err)
+	case status == rotompb.MitmResponse_ERROR_RETRY_LATER:
+		clearInFlight(r.Item.Path)
+		it := r.Item
+		it.WorkerID = w.ID
+		retryLater(it)
+	case status == rotompb.MitmResponse_ERROR_UNKNOWN:
+		metrics.Inc("ack.rejected")
+		failItem(r.Item, fmt.Errorf("rejected by rotom: %s", r.Resp.GetMitmError()))
+	case status == rotompb.MitmResponse_ERROR_RECONNECT || status == rotompb.MitmResponse_ERROR_WORKER_STOPPED:
+		// problema da conexão, não do item
+		metrics.Inc("ack.released")
+		releaseItem(r.Item, 0)
+	default:
+		metrics.Inc("ack.confirmed")
+		finishItem(w, r.Item, r.Item.Payload, "acked")
+	}
+}
+
+// finishItem confirma o item no spool, remove o arquivo de origem e encerra
+// o claim do scanner. Num arquivo com vários registros, só a última parte
+// entregue remove o arquivo.
+func finishItem(w *DataWorker, item SendItem, sent []byte, how string) {
+	ackItem(item)
+	clearInFlight(item.Path)
+	if item.Path == "" {
+		w.logger.Infof("[%s] %s payload (%d bytes) (no file path)", w.ID, how, len(sent))
+		return
+	}
+	if item.Parts > 1 {
+		if left := captureGroups.Done(item.Path, item.Part); left > 0 {
+			w.logger.Infof("[%s] %s part %d/%d of %s (%d bytes); %d part(s) left", w.ID, how, item.Part, item.Parts, basename(item.Path), len(sent), left)
+			return
+		}
+	}
+	err := os.Remove(item.Path)
+	scanClaims.Done(item.Path)
+	forgetAttempts(item.Path)
+	if err != nil {
+		w.logger.Warnf("[%s] %s but failed to remove file %s: %v", w.ID, how, item.Path, err)
+		return
+	}
+	w.logger.Infof("[%s] %s and removed %s (%d bytes)", w.ID, how, basename(item.Path), len(sent))
+}
+
+// parseAckFrame extrai o id de um frame de ack {"<field>": id}.
+func parseAckFrame(msg []byte, field string) (uint32, bool) {
+	var m map[string]any
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return 0, false
+	}
+	v, ok := m[field].(float64)
+	if !ok || v <= 0 || v > float64(^uint32(0)) {
+		return 0, false
+	}
+	return uint32(v), true
+}
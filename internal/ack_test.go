@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIdlessItemSurvivesUntilSeqAck(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.General.StableMs = 0
+	cfg.Rotom.AckMode = string(AckFrame)
+	s := openTestSpool(t, cfg)
+	pipeline := NewSendPipeline(cfg)
+	w := NewDataWorker(cfg, 1, pipeline, nil)
+	sc := newDirScanner(cfg, ScanSource{Dir: cfg.General.ScanDir, MinSize: 16})
+
+	// captura opaca: não é um MitmRequest, então não tem id
+	path := filepath.Join(cfg.General.ScanDir, "opaque.bin")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("opaque capture "), 8), 0644); err != nil {
+		t.Fatal(err)
+	}
+	offer(t, sc, path)
+	item := nextItem(t, s)
+
+	conn, frames := wsPair(t)
+	w.setConn(conn)
+	if err := pipeline.Send(context.Background(), w, conn, item); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	select {
+	case <-frames:
+	case <-time.After(2 * time.Second):
+		t.Fatal("frame not received")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("capture removed before any ack: %v", err)
+	}
+	if !IsInFlight(path) {
+		t.Fatal("capture not awaiting ack")
+	}
+	if st := s.Stats(); st.InFlight != 1 {
+		t.Fatalf("spool %+v, want the item in flight", st)
+	}
+
+	// o ack de outro frame não conclui o item
+	w.handleAckFrame([]byte(`{"seq":2}`))
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("capture removed by the ack of another frame: %v", err)
+	}
+
+	if !w.handleAckFrame([]byte(`{"seq":1}`)) {
+		t.Fatal("seq ack not recognized")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("capture not removed after its ack: %v", err)
+	}
+	if st := s.Stats(); st.Ready+st.InFlight != 0 || scanClaims.Len() != 0 || IsInFlight(path) {
+		t.Fatalf("leftovers after the ack: spool %+v, %d claim(s)", st, scanClaims.Len())
+	}
+}
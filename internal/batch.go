@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+// batchOptions configura o modo batching (seção "batch" da config): itens
+// que chegam em até maxDelay, somando até maxBytes/maxItems, saem juntos num
+// único frame MitmBatch.
+type batchOptions struct {
+	enabled  bool
+	maxDelay time.Duration
+	maxBytes int
+	maxItems int
+}
+
+func newBatchOptions(cfg Config) batchOptions {
+	return batchOptions{
+		enabled:  cfg.Batch.Enabled,
+		maxDelay: time.Duration(cfg.Batch.MaxDelayMs) * time.Millisecond,
+		maxBytes: cfg.Batch.MaxBytes,
+		maxItems: cfg.Batch.MaxItems,
+	}
+}
+
+// Batching informa se o pipeline agrupa itens.
+func (p *SendPipeline) Batching() bool {
+	return p.batch.enabled
+}
+
+// collectBatch junta a first os itens que chegarem em queue até o prazo ou os
+// limites do batch.
+func (p *SendPipeline) collectBatch(queue <-chan SendItem, first SendItem) []SendItem {
+	items := []SendItem{first}
+	size := len(first.Payload)
+	timer := time.NewTimer(p.batch.maxDelay)
+	defer timer.Stop()
+	for size < p.batch.maxBytes && (p.batch.maxItems <= 0 || len(items) < p.batch.maxItems) {
+		select {
+		case it := <-queue:
+			items = append(items, it)
+			size += len(it.Payload)
+		case <-timer.C:
+			return items
+		}
+	}
+	return items
+}
+
+// SendBatch leva items até conn num único frame MitmBatch. Cada item passa
+// pelos estágios anteriores a "frame" e posteriores a "write" individualmente,
+// então pendentes, acks e cleanup continuam por item. Estágios inseridos entre
+// "frame" e "write" não são usados no modo batching.
+func (p *SendPipeline) SendBatch(ctx context.Context, w *DataWorker, conn *websocket.Conn, items []SendItem) error {
+	pre, post := p.splitAround("frame", "write")
+
+	jobs := make([]*SendJob, 0, len(items))
+	for _, it := range items {
+		job := &SendJob{Item: it, Payload: it.Payload, ctx: ctx, worker: w, conn: conn}
+		if err := runStages(pre, job); err != nil {
+			if err != errSkipItem {
+				w.logger.Errorf("[%s] batch: dropping %s: %v", w.ID, describeItem(it), err)
+				failItem(it, err)
+			}
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	frame, err := EncodeMitmBatch(jobs)
+	if err != nil {
+		for _, job := range jobs {
+			failItem(job.Item, err)
+		}
+		return nil
+	}
+	// o batch é um frame só para o limitador
+	if err := sendLimiter.Wait(ctx, len(frame)); err != nil {
+		for _, job := range jobs {
+			releaseItem(job.Item, 0)
+		}
+		return err
+	}
+	var bySeq []*SendJob // sem id: confirmados pela sequência do frame do batch
+	for _, job := range jobs {
+		job.MessageType = websocket.BinaryMessage
+		job.Frame = job.Payload
+		beginWrite(job)
+		if job.AwaitAck && job.RequestID == 0 {
+			bySeq = append(bySeq, job)
+		}
+	}
+	var before func(uint32)
+	if len(bySeq) > 0 {
+		before = func(seq uint32) { w.trackSeqAck(seq, bySeq) }
+	}
+	if err := w.writeFrame(conn, websocket.BinaryMessage, frame, 15*time.Second, before); err != nil {
+		for _, job := range jobs {
+			abortWrite(job, err)
+		}
+		return err
+	}
+	metrics.Inc("batch.frames")
+	metrics.Add("batch.items", uint64(len(jobs)))
+	w.logger.Debugf("[%s] sent batch of %d item(s), %d bytes", w.ID, len(jobs), len(frame))
+
+	for _, job := range jobs {
+		if err := runStages(post, job); err != nil {
+			w.logger.Warnf("[%s] batch: %s after write: %v", w.ID, describeItem(job.Item), err)
+		}
+	}
+	return nil
+}
+
+// splitAround devolve os estágios antes de first e depois de last.
+func (p *SendPipeline) splitAround(first, last string) (pre, post []SendStage) {
+	i, j := len(p.stages), len(p.stages)
+	for k, s := range p.stages {
+		switch s.Name() {
+		case first:
+			i = k
+		case last:
+			j = k + 1
+		}
+	}
+	if j > len(p.stages) {
+		j = len(p.stages)
+	}
+	return p.stages[:i], p.stages[j:]
+}
+
+func runStages(stages []SendStage, job *SendJob) error {
+	for _, s := range stages {
+		if err := s.Process(job); err != nil {
+			if err == errSkipItem {
+				return err
+			}
+			return fmt.Errorf("%s: %w", s.Name(), err)
+		}
+	}
+	return nil
+}
+
+// EncodeMitmBatch monta o envelope MitmBatch dos jobs, na ordem.
+func EncodeMitmBatch(jobs []*SendJob) ([]byte, error) {
+	batch := &rotompb.MitmBatch{Entries: make([]*rotompb.MitmBatch_Entry, 0, len(jobs))}
+	for _, job := range jobs {
+		batch.Entries = append(batch.Entries, &rotompb.MitmBatch_Entry{
+			Id:           job.RequestID,
+			Payload:      job.Payload,
+			IsCompressed: job.Compressed,
+		})
+	}
+	return proto.Marshal(batch)
+}
+
+// DecodeMitmBatch abre um frame MitmBatch. Uma entrada comprimida volta já
+// descomprimida por codec (nil = gzip), com IsCompressed=false.
+func DecodeMitmBatch(frame []byte, codec Codec) ([]*rotompb.MitmBatch_Entry, error) {
+	if codec == nil {
+		codec = gzipCodec{}
+	}
+	var batch rotompb.MitmBatch
+	if err := proto.Unmarshal(frame, &batch); err != nil {
+		return nil, err
+	}
+	if len(batch.GetEntries()) == 0 {
+		return nil, errors.New("empty batch")
+	}
+	for i, e := range batch.GetEntries() {
+		if !e.GetIsCompressed() {
+			continue
+		}
+		raw, err := codec.Decode(e.GetPayload())
+		if err != nil {
+			return nil, fmt.Errorf("entry %d (id=%d): %w", i, e.GetId(), err)
+		}
+		e.Payload, e.IsCompressed = raw, false
+	}
+	return batch.GetEntries(), nil
+}
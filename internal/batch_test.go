@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+func TestSendBatchRoundTrip(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.General.StableMs = 0
+	cfg.Rotom.AckMode = string(AckFrame)
+	cfg.Rotom.CompressionCodec = CodecGzip
+	cfg.Rotom.CompressionNegotiate = false
+	cfg.Batch.Enabled = true
+	s := openTestSpool(t, cfg)
+	pipeline := NewSendPipeline(cfg)
+	w := NewDataWorker(cfg, 1, pipeline, nil)
+	sc := newDirScanner(cfg, ScanSource{Dir: cfg.General.ScanDir, MinSize: 16})
+
+	// três MitmRequests (confirmados por ack) e um payload opaco grande, que
+	// vai comprimido e sem id
+	files := map[string][]byte{}
+	for id := uint32(11); id <= 13; id++ {
+		files[fmt.Sprintf("req-%d.bin", id)] = rpcRequestPayload(t, id, []byte(fmt.Sprintf("small %d", id)))
+	}
+	opaque := bytes.Repeat([]byte("opaque capture "), 64)
+	files["opaque.bin"] = opaque
+	for name, b := range files {
+		path := filepath.Join(cfg.General.ScanDir, name)
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		offer(t, sc, path)
+	}
+	var items []SendItem
+	for range files {
+		items = append(items, nextItem(t, s))
+	}
+
+	conn, frames := wsPair(t)
+	if err := pipeline.SendBatch(context.Background(), w, conn, items); err != nil {
+		t.Fatalf("SendBatch: %v", err)
+	}
+	var frame []byte
+	select {
+	case frame = <-frames:
+	case <-time.After(2 * time.Second):
+		t.Fatal("batch frame not received")
+	}
+
+	var wire rotompb.MitmBatch
+	if err := proto.Unmarshal(frame, &wire); err != nil {
+		t.Fatal(err)
+	}
+	compressed := 0
+	for _, e := range wire.GetEntries() {
+		if e.GetIsCompressed() {
+			compressed++
+		}
+	}
+	if compressed != 1 {
+		t.Fatalf("%d compressed entries on the wire, want only the opaque one", compressed)
+	}
+
+	entries, err := DecodeMitmBatch(frame, pipeline.Compression().Codec)
+	if err != nil {
+		t.Fatalf("DecodeMitmBatch: %v", err)
+	}
+	if len(entries) != len(items) {
+		t.Fatalf("batch has %d entries, want %d", len(entries), len(items))
+	}
+	ids := map[uint32]bool{}
+	for i, e := range entries {
+		if !bytes.Equal(e.GetPayload(), items[i].Payload) {
+			t.Fatalf("entry %d (id=%d): payload differs after the round trip", i, e.GetId())
+		}
+		if e.GetId() != 0 {
+			ids[e.GetId()] = true
+		}
+	}
+	if len(ids) != 3 {
+		t.Fatalf("batch carries ids %v, want 11..13", ids)
+	}
+
+	// o payload sem id espera o ack da sequência do frame do batch
+	opaquePath := filepath.Join(cfg.General.ScanDir, "opaque.bin")
+	if !IsInFlight(opaquePath) {
+		t.Fatalf("opaque capture not awaiting ack")
+	}
+	if !w.handleAckFrame([]byte(`{"seq":1}`)) {
+		t.Fatal("seq ack not recognized")
+	}
+	if _, err := os.Stat(opaquePath); !os.IsNotExist(err) {
+		t.Fatalf("opaque capture not removed after its ack: %v", err)
+	}
+
+	// cada ack conclui só o próprio item
+	for id := uint32(11); id <= 13; id++ {
+		path := filepath.Join(cfg.General.ScanDir, fmt.Sprintf("req-%d.bin", id))
+		if !IsInFlight(path) {
+			t.Fatalf("%s not awaiting ack", path)
+		}
+		if !w.acks.ResolveID(id) {
+			t.Fatalf("no ack pending for id=%d", id)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s not removed after its ack: %v", path, err)
+		}
+		for next := id + 1; next <= 13; next++ {
+			other := filepath.Join(cfg.General.ScanDir, fmt.Sprintf("req-%d.bin", next))
+			if _, err := os.Stat(other); err != nil {
+				t.Fatalf("ack id=%d removed %s: %v", id, other, err)
+			}
+		}
+	}
+	if st := s.Stats(); st.Ready+st.InFlight != 0 || scanClaims.Len() != 0 {
+		t.Fatalf("leftovers after the acks: spool %+v, %d claim(s)", st, scanClaims.Len())
+	}
+}
@@ -0,0 +1,237 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+// CaptureFormat é o formato detectado de um arquivo do scan_dir.
+type CaptureFormat string
+
+const (
+	// FormatRaw: payload opaco, enviado como está (comportamento antigo).
+	FormatRaw CaptureFormat = "raw"
+	// FormatMitm: um MitmRequest serializado.
+	FormatMitm CaptureFormat = "mitm"
+	// FormatFramed: registros [4 bytes big-endian len][payload], como no receptor TCP.
+	FormatFramed CaptureFormat = "framed"
+	// FormatBase64Lines: um payload em base64 por linha.
+	FormatBase64Lines CaptureFormat = "base64-lines"
+)
+
+// maxCaptureRecord é o mesmo limite de frame do receptor TCP.
+const maxCaptureRecord = 50_000_000
+
+// Capture é o resultado da detecção: o formato, se o arquivo veio em gzip, e
+// os registros a enviar (um SendItem por registro).
+type Capture struct {
+	Format  CaptureFormat
+	Gzipped bool
+	Records [][]byte
+}
+
+// sniffCapture detecta o formato de b e separa os registros. Um gzip é aberto
+// e o conteúdo é detectado de novo. Retorna errCorruptCapture quando o
+// arquivo é reconhecido mas está truncado.
+func sniffCapture(b []byte) (Capture, error) {
+	c := Capture{}
+	if len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b {
+		gr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return c, fmt.Errorf("%w: gzip header: %v", errCorruptCapture, err)
+		}
+		inner, err := io.ReadAll(gr)
+		if err != nil {
+			return c, fmt.Errorf("%w: gzip body: %v", errCorruptCapture, err)
+		}
+		c.Gzipped = true
+		b = inner
+	}
+
+	if recs, err := splitFramed(b); err != nil {
+		return c, err
+	} else if recs != nil {
+		c.Format, c.Records = FormatFramed, recs
+		return c, nil
+	}
+	if recs := splitBase64Lines(b); recs != nil {
+		c.Format, c.Records = FormatBase64Lines, recs
+		return c, nil
+	}
+	if _, ok := decodeMitmRequest(b); ok {
+		c.Format, c.Records = FormatMitm, [][]byte{b}
+		return c, nil
+	}
+	if truncatedMitm(b) {
+		return c, fmt.Errorf("%w: MitmRequest cut at %d bytes", errCorruptCapture, len(b))
+	}
+	c.Format, c.Records = FormatRaw, [][]byte{b}
+	return c, nil
+}
+
+// splitFramed lê b como registros com prefixo de tamanho. Retorna nil se b não
+// tem esse formato; um arquivo com pelo menos dois registros válidos e a cauda
+// cortada é considerado truncado.
+func splitFramed(b []byte) ([][]byte, error) {
+	var recs [][]byte
+	off := 0
+	for off < len(b) {
+		if len(b)-off < 4 {
+			break
+		}
+		n := int(binary.BigEndian.Uint32(b[off : off+4]))
+		if n <= 0 || n > maxCaptureRecord {
+			break
+		}
+		if off+4+n > len(b) {
+			break
+		}
+		recs = append(recs, b[off+4:off+4+n])
+		off += 4 + n
+	}
+	switch {
+	case off == len(b) && len(recs) > 0:
+		return recs, nil
+	case len(recs) >= 2:
+		return nil, fmt.Errorf("%w: framed record cut at offset %d of %d", errCorruptCapture, off, len(b))
+	}
+	return nil, nil
+}
+
+// truncatedMitm reconhece o começo de um MitmRequest cortado no meio de um
+// campo: todos os campos lidos existem no MitmRequest, o method já lido é
+// LOGIN ou RPC_REQUEST e a leitura acaba antes do fim do último campo. Um
+// payload opaco qualquer não passa dessas checagens e continua indo como raw.
+func truncatedMitm(b []byte) bool {
+	fields := (&rotompb.MitmRequest{}).ProtoReflect().Descriptor().Fields()
+	method := false
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return method && protowire.ParseError(n) == io.ErrUnexpectedEOF
+		}
+		fd := fields.ByNumber(num)
+		if fd == nil {
+			return false
+		}
+		// MitmRequest só tem varints (id, method) e mensagens (payload)
+		want := protowire.VarintType
+		if fd.Kind() == protoreflect.MessageKind {
+			want = protowire.BytesType
+		}
+		if typ != want {
+			return false
+		}
+		b = b[n:]
+		m := protowire.ConsumeFieldValue(num, typ, b)
+		if m < 0 {
+			return method && protowire.ParseError(m) == io.ErrUnexpectedEOF
+		}
+		if fd.Name() == "method" {
+			v, _ := protowire.ConsumeVarint(b)
+			switch rotompb.MitmRequest_Method(v) {
+			case rotompb.MitmRequest_LOGIN, rotompb.MitmRequest_RPC_REQUEST:
+				method = true
+			default:
+				return false
+			}
+		}
+		b = b[m:]
+	}
+	return false
+}
+
+// splitBase64Lines decodifica um payload por linha. Retorna nil se alguma
+// linha não for base64 válido.
+func splitBase64Lines(b []byte) [][]byte {
+	var recs [][]byte
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(make([]byte, 64*1024), maxCaptureRecord)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		rec, err := base64.StdEncoding.DecodeString(string(line))
+		if err != nil {
+			if rec, err = base64.RawStdEncoding.DecodeString(string(line)); err != nil {
+				return nil
+			}
+		}
+		if len(rec) == 0 {
+			return nil
+		}
+		recs = append(recs, rec)
+	}
+	if sc.Err() != nil {
+		return nil
+	}
+	return recs
+}
+
+// captureGroups conta as partes ainda não entregues de cada arquivo com vários
+// registros; o arquivo só é removido quando a última parte é entregue.
+var captureGroups = &fileGroups{m: map[string]map[int]bool{}}
+
+type fileGroups struct {
+	mu sync.Mutex
+	m  map[string]map[int]bool // path -> partes pendentes
+}
+
+// Start registra path com as partes pendentes informadas.
+func (g *fileGroups) Start(path string, parts []int) {
+	pending := make(map[int]bool, len(parts))
+	for _, p := range parts {
+		pending[p] = true
+	}
+	g.mu.Lock()
+	g.m[path] = pending
+	g.mu.Unlock()
+}
+
+// Drop esquece path (ex: o enfileiramento falhou).
+func (g *fileGroups) Drop(path string) {
+	g.mu.Lock()
+	delete(g.m, path)
+	g.mu.Unlock()
+}
+
+// Done marca a parte como entregue e informa quantas ainda faltam. Um path
+// desconhecido conta como concluído.
+func (g *fileGroups) Done(path string, part int) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	pending, ok := g.m[path]
+	if !ok {
+		return 0
+	}
+	delete(pending, part)
+	if len(pending) == 0 {
+		delete(g.m, path)
+	}
+	return len(pending)
+}
+
+// captureItems monta os SendItems de um arquivo detectado.
+func captureItems(path, tag string, c Capture) []SendItem {
+	items := make([]SendItem, 0, len(c.Records))
+	for i, rec := range c.Records {
+		it := SendItem{Path: path, Payload: rec, Tag: tag}
+		if len(c.Records) > 1 {
+			it.Part, it.Parts = i+1, len(c.Records)
+		}
+		items = append(items, it)
+	}
+	return items
+}
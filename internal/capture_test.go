@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSniffCaptureTruncatedMitm(t *testing.T) {
+	full := rpcRequestPayload(t, 9, bytes.Repeat([]byte("rpc body "), 32))
+
+	c, err := sniffCapture(full)
+	if err != nil || c.Format != FormatMitm {
+		t.Fatalf("full MitmRequest: format %q, err %v", c.Format, err)
+	}
+
+	for _, cut := range []int{len(full) - 1, len(full) / 2, 6} {
+		if _, err := sniffCapture(full[:cut]); !errors.Is(err, errCorruptCapture) {
+			t.Fatalf("MitmRequest cut at %d/%d: err %v, want errCorruptCapture", cut, len(full), err)
+		}
+	}
+
+	// payload opaco que não começa como MitmRequest continua raw
+	opaque := []byte("\xff\xfe opaque payload that is not a proto")
+	if c, err := sniffCapture(opaque); err != nil || c.Format != FormatRaw {
+		t.Fatalf("opaque payload: format %q, err %v", c.Format, err)
+	}
+}
+
+// framed monta registros [4 bytes big-endian len][payload].
+func framed(recs ...string) []byte {
+	var b []byte
+	for _, r := range recs {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(r)))
+		b = append(b, r...)
+	}
+	return b
+}
+
+// gzipped comprime b com gzip.
+func gzipped(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// base64Lines codifica um registro por linha com enc.
+func base64Lines(enc *base64.Encoding, recs ...string) []byte {
+	var b []byte
+	for _, r := range recs {
+		b = append(b, enc.EncodeToString([]byte(r))...)
+		b = append(b, '\n')
+	}
+	return b
+}
+
+func TestSniffCaptureFormats(t *testing.T) {
+	mitm := rpcRequestPayload(t, 9, []byte("rpc body"))
+	// cauda com um cabeçalho que promete 100 bytes e só traz 3
+	cutTail := append(framed("first record", "second record"), 0, 0, 0, 100, 'a', 'b', 'c')
+
+	cases := []struct {
+		name    string
+		in      []byte
+		format  CaptureFormat
+		gzipped bool
+		records []string
+		corrupt bool
+	}{
+		{"framed", framed("one", "two", "three"), FormatFramed, false, []string{"one", "two", "three"}, false},
+		{"framed single record", framed("only record"), FormatFramed, false, []string{"only record"}, false},
+		{"framed tail cut after two records", cutTail, "", false, nil, true},
+		{"gzipped framed", gzipped(t, framed("one", "two")), FormatFramed, true, []string{"one", "two"}, false},
+		{"gzipped mitm", gzipped(t, mitm), FormatMitm, true, []string{string(mitm)}, false},
+		{"gzipped base64 lines", gzipped(t, base64Lines(base64.StdEncoding, "x", "yz")), FormatBase64Lines, true, []string{"x", "yz"}, false},
+		{"gzipped cut body", gzipped(t, framed("one", "two"))[:20], "", true, nil, true},
+		{"base64 padded", base64Lines(base64.StdEncoding, "a", "ab", "abc"), FormatBase64Lines, false, []string{"a", "ab", "abc"}, false},
+		{"base64 raw", base64Lines(base64.RawStdEncoding, "a", "ab", "abc"), FormatBase64Lines, false, []string{"a", "ab", "abc"}, false},
+		{"base64 with blank lines", []byte("YQ==\n\n  YWI=  \n"), FormatBase64Lines, false, []string{"a", "ab"}, false},
+		{"mitm", mitm, FormatMitm, false, []string{string(mitm)}, false},
+		{"opaque", []byte("\xff\xfe opaque payload!"), FormatRaw, false, []string{"\xff\xfe opaque payload!"}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := sniffCapture(tc.in)
+			if tc.corrupt {
+				if !errors.Is(err, errCorruptCapture) {
+					t.Fatalf("err %v, want errCorruptCapture", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Format != tc.format || c.Gzipped != tc.gzipped {
+				t.Fatalf("format %q gzipped %v, want %q and %v", c.Format, c.Gzipped, tc.format, tc.gzipped)
+			}
+			var got []string
+			for _, r := range c.Records {
+				got = append(got, string(r))
+			}
+			if !reflect.DeepEqual(got, tc.records) {
+				t.Fatalf("records %q, want %q", got, tc.records)
+			}
+		})
+	}
+}
+
+func TestFileGroupsDone(t *testing.T) {
+	g := &fileGroups{m: map[string]map[int]bool{}}
+	g.Start("/scan/a.bin", []int{1, 2, 3})
+
+	// fora de ordem e com repetição: só a última parte pendente encerra
+	steps := []struct{ part, left int }{{2, 2}, {2, 2}, {3, 1}, {1, 0}}
+	for _, s := range steps {
+		if left := g.Done("/scan/a.bin", s.part); left != s.left {
+			t.Fatalf("Done(part %d) = %d, want %d", s.part, left, s.left)
+		}
+	}
+	if left := g.Done("/scan/unknown.bin", 1); left != 0 {
+		t.Fatalf("unknown path has %d part(s) left", left)
+	}
+	g.Start("/scan/b.bin", []int{1, 2})
+	g.Drop("/scan/b.bin")
+	if left := g.Done("/scan/b.bin", 1); left != 0 {
+		t.Fatalf("dropped path has %d part(s) left", left)
+	}
+}
+
+func TestMultiRecordFileRemovedAfterLastPart(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.General.StableMs = 0
+	s := openTestSpool(t, cfg)
+	sc := newDirScanner(cfg, ScanSource{Dir: cfg.General.ScanDir, MinSize: 16})
+	w := NewDataWorker(cfg, 1, NewSendPipeline(cfg), nil)
+
+	path := filepath.Join(cfg.General.ScanDir, "records.bin")
+	if err := os.WriteFile(path, framed("record one", "record two", "record three"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	offer(t, sc, path)
+
+	items := []SendItem{nextItem(t, s), nextItem(t, s), nextItem(t, s)}
+	// entregues fora de ordem: o arquivo fica até a última parte
+	for i, idx := range []int{2, 0, 1} {
+		it := items[idx]
+		if it.Parts != 3 || it.Path != path {
+			t.Fatalf("item %+v, want part of 3 from %s", it, path)
+		}
+		finishItem(w, it, it.Payload, "sent")
+		_, err := os.Stat(path)
+		if last := i == 2; last != os.IsNotExist(err) {
+			t.Fatalf("after %d of 3 part(s): stat err %v", i+1, err)
+		}
+	}
+	if scanClaims.Owned(path) {
+		t.Fatal("claim kept after the last part")
+	}
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ClaimSet guarda os arquivos do scan_dir que já pertencem ao worker: do
+// momento em que o scanner os lê até o envio ser concluído (arquivo removido)
+// ou falhar (claim liberado, o arquivo volta a ser elegível).
+type ClaimSet struct {
+	mu    sync.Mutex
+	owned map[string]time.Time
+}
+
+// NewClaimSet cria um conjunto vazio.
+func NewClaimSet() *ClaimSet {
+	return &ClaimSet{owned: map[string]time.Time{}}
+}
+
+// scanClaims é o conjunto usado pelo scanner e pelo pipeline de envio.
+var scanClaims = NewClaimSet()
+
+// Claim toma posse de path. Retorna false se ele já tinha dono.
+func (c *ClaimSet) Claim(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.owned[path]; ok {
+		return false
+	}
+	c.owned[path] = time.Now()
+	metrics.Inc("claims.claimed")
+	return true
+}
+
+// Owned informa se path tem dono.
+func (c *ClaimSet) Owned(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.owned[path]
+	return ok
+}
+
+// Release devolve path depois de uma falha; a próxima passada o lê de novo.
+func (c *ClaimSet) Release(path string) {
+	if c.drop(path) {
+		metrics.Inc("claims.released")
+	}
+}
+
+// Done encerra o claim de um arquivo entregue (e removido).
+func (c *ClaimSet) Done(path string) {
+	if c.drop(path) {
+		metrics.Inc("claims.done")
+	}
+}
+
+func (c *ClaimSet) drop(path string) bool {
+	if path == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.owned[path]; !ok {
+		return false
+	}
+	delete(c.owned, path)
+	return true
+}
+
+// Len retorna quantos arquivos têm dono.
+func (c *ClaimSet) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.owned)
+}
+
+// Paths lista os arquivos com dono, em ordem.
+func (c *ClaimSet) Paths() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]string, 0, len(c.owned))
+	for p := range c.owned {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// nextItem tira o próximo item do spool ou falha o teste.
+func nextItem(t *testing.T, s *Spool) SendItem {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	it, err := s.Next(ctx)
+	if err != nil {
+		t.Fatalf("spool.Next: %v", err)
+	}
+	return it
+}
+
+// expectNoItem falha se o spool entrega algum item em pouco tempo.
+func expectNoItem(t *testing.T, s *Spool) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if it, err := s.Next(ctx); err == nil {
+		t.Fatalf("unexpected item %s", describeItem(it))
+	}
+}
+
+// offer faz o scanner oferecer path como se o inotify avisasse que terminou.
+func offer(t *testing.T, sc *dirScanner, path string) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.offerFile(path, info, true)
+}
+
+func TestClaimSet(t *testing.T) {
+	c := NewClaimSet()
+	if !c.Claim("/a") {
+		t.Fatal("first claim refused")
+	}
+	if c.Claim("/a") {
+		t.Fatal("second claim of the same path accepted")
+	}
+	if !c.Owned("/a") || c.Owned("/b") {
+		t.Fatal("Owned does not match the claims")
+	}
+	c.Claim("/b")
+	if c.Len() != 2 || len(c.Paths()) != 2 {
+		t.Fatalf("Len=%d Paths=%v, want 2", c.Len(), c.Paths())
+	}
+
+	c.Release("/a")
+	if c.Owned("/a") {
+		t.Fatal("released path still owned")
+	}
+	if !c.Claim("/a") {
+		t.Fatal("released path cannot be claimed again")
+	}
+	c.Done("/a")
+	c.Done("/b")
+	if c.Len() != 0 {
+		t.Fatalf("Len=%d after Done, want 0", c.Len())
+	}
+}
+
+func TestOfferFileClaimLifecycle(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.General.StableMs = 0
+	s := openTestSpool(t, cfg)
+	sc := newDirScanner(cfg, ScanSource{Dir: cfg.General.ScanDir, MinSize: 16})
+	w := NewDataWorker(cfg, 1, NewSendPipeline(cfg), nil)
+
+	path := filepath.Join(cfg.General.ScanDir, "capture.bin")
+	if err := os.WriteFile(path, rpcRequestPayload(t, 1, []byte("payload one")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	offer(t, sc, path)
+	if !scanClaims.Owned(path) {
+		t.Fatal("offered file is not claimed")
+	}
+	it := nextItem(t, s)
+	if it.Path != path {
+		t.Fatalf("item path %q, want %q", it.Path, path)
+	}
+
+	// enquanto o claim existe o arquivo não é lido de novo
+	offer(t, sc, path)
+	expectNoItem(t, s)
+
+	// falha de envio: sai do spool, libera o claim e o arquivo fica
+	releaseItem(it, 0)
+	if scanClaims.Owned(path) {
+		t.Fatal("claim kept after releaseItem")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("file gone after releaseItem: %v", err)
+	}
+
+	offer(t, sc, path)
+	if !scanClaims.Owned(path) {
+		t.Fatal("re-read file is not claimed")
+	}
+	it = nextItem(t, s)
+
+	finishItem(w, it, it.Payload, "sent")
+	if scanClaims.Owned(path) || scanClaims.Len() != 0 {
+		t.Fatal("claim kept after finishItem")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("file not removed after finishItem: %v", err)
+	}
+	expectNoItem(t, s)
+}
+
+func TestOfferFileKeepsClaimWhenQuarantineFails(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.General.StableMs = 0
+	s := openTestSpool(t, cfg)
+
+	// quarantine_dir embaixo de um arquivo comum: MkdirAll falha
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.General.QuarantineDir = filepath.Join(blocker, "quarantine")
+	sc := newDirScanner(cfg, ScanSource{Dir: cfg.General.ScanDir, MinSize: 16})
+
+	// cabeçalho gzip com o corpo cortado
+	path := filepath.Join(cfg.General.ScanDir, "broken.gz")
+	broken := []byte{0x1f, 0x8b, 0x08, 0x00, 0, 0, 0, 0, 0x00, 0xff, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06}
+	if err := os.WriteFile(path, broken, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	offer(t, sc, path)
+	if !scanClaims.Owned(path) {
+		t.Fatal("claim released although the file could not be quarantined")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("corrupt file moved or removed: %v", err)
+	}
+	expectNoItem(t, s)
+
+	// com a quarentena funcionando o arquivo sai e o claim é liberado
+	scanClaims.Release(path)
+	sc.quarantine = t.TempDir()
+	offer(t, sc, path)
+	if scanClaims.Owned(path) {
+		t.Fatal("claim kept after a successful quarantine")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("corrupt file still in scan dir: %v", err)
+	}
+	moved, _ := os.ReadDir(sc.quarantine)
+	if len(moved) != 1 {
+		t.Fatalf("quarantine has %d file(s), want 1", len(moved))
+	}
+}
@@ -0,0 +1,365 @@
+package internal
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/proto"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+// Codec comprime e descomprime payloads. A saída de Encode é determinística.
+type Codec interface {
+	Name() string
+	Encode(b []byte) ([]byte, error)
+	Decode(b []byte) ([]byte, error)
+}
+
+// Nomes de codec aceitos em rotom.compression_codec.
+const (
+	CodecNone    = "none"
+	CodecGzip    = "gzip"
+	CodecZstd    = "zstd"
+	CodecDeflate = "deflate"
+)
+
+// NewCodec cria o codec name. level 0 usa o padrão do codec.
+func NewCodec(name string, level int) (Codec, error) {
+	switch strings.ToLower(name) {
+	case "", CodecNone:
+		return noneCodec{}, nil
+	case CodecGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return nil, fmt.Errorf("gzip level %d out of range", level)
+		}
+		return gzipCodec{level: level}, nil
+	case CodecDeflate:
+		if level == 0 {
+			level = flate.DefaultCompression
+		}
+		if level < flate.HuffmanOnly || level > flate.BestCompression {
+			return nil, fmt.Errorf("deflate level %d out of range", level)
+		}
+		return deflateCodec{level: level}, nil
+	case CodecZstd:
+		zl := zstd.SpeedDefault
+		if level != 0 {
+			zl = zstd.EncoderLevelFromZstd(level)
+		}
+		return &zstdCodec{level: zl}, nil
+	}
+	return nil, fmt.Errorf("unknown compression codec %q", name)
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string                    { return CodecNone }
+func (noneCodec) Encode(b []byte) ([]byte, error) { return b, nil }
+func (noneCodec) Decode(b []byte) ([]byte, error) { return b, nil }
+
+type gzipCodec struct{ level int }
+
+func (gzipCodec) Name() string { return CodecGzip }
+
+// Encode não grava timestamp no header, então a saída é determinística.
+func (c gzipCodec) Encode(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	_, err = gw.Write(b)
+	if cerr := gw.Close(); err == nil {
+		err = cerr
+	}
+	return buf.Bytes(), err
+}
+
+func (gzipCodec) Decode(b []byte) ([]byte, error) { return gunzip(b) }
+
+type deflateCodec struct{ level int }
+
+func (deflateCodec) Name() string { return CodecDeflate }
+
+func (c deflateCodec) Encode(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fw.Write(b)
+	if cerr := fw.Close(); err == nil {
+		err = cerr
+	}
+	return buf.Bytes(), err
+}
+
+func (deflateCodec) Decode(b []byte) ([]byte, error) {
+	fr := flate.NewReader(bytes.NewReader(b))
+	defer fr.Close()
+	return io.ReadAll(fr)
+}
+
+// zstdCodec reaproveita um encoder/decoder (EncodeAll/DecodeAll são seguros
+// para uso concorrente).
+type zstdCodec struct {
+	level zstd.EncoderLevel
+
+	once sync.Once
+	enc  *zstd.Encoder
+	dec  *zstd.Decoder
+	err  error
+}
+
+func (*zstdCodec) Name() string { return CodecZstd }
+
+func (c *zstdCodec) init() {
+	c.enc, c.err = zstd.NewWriter(nil, zstd.WithEncoderLevel(c.level), zstd.WithEncoderConcurrency(1))
+	if c.err == nil {
+		c.dec, c.err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	}
+}
+
+func (c *zstdCodec) Encode(b []byte) ([]byte, error) {
+	c.once.Do(c.init)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.enc.EncodeAll(b, nil), nil
+}
+
+func (c *zstdCodec) Decode(b []byte) ([]byte, error) {
+	c.once.Do(c.init)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.dec.DecodeAll(b, nil)
+}
+
+// CompressionPolicy decide quando e como comprimir. Com negotiate, só se
+// comprime depois que o peer anunciou suporte no login (enable_compression no
+// LoginRequest recebido, ou supports_compression no LoginResponse).
+type CompressionPolicy struct {
+	Codec     Codec
+	MinSize   int
+	Negotiate bool
+}
+
+// compressionFromConfig monta a política a partir de rotom.compression_*. O
+// antigo rotom.use_compression ainda vale como "gzip" quando nenhum codec é
+// configurado; a variável ROTOM_USE_COMPRESSION não é mais lida.
+func compressionFromConfig(cfg Config) (*CompressionPolicy, error) {
+	if GetEnv("ROTOM_USE_COMPRESSION", "") != "" {
+		NewLogger().Warn("[send] ROTOM_USE_COMPRESSION is ignored; set rotom.compression_codec instead")
+	}
+	name := cfg.Rotom.CompressionCodec
+	if name == "" {
+		name = CodecNone
+		if cfg.Rotom.UseCompression {
+			name = CodecGzip
+		}
+	}
+	codec, err := NewCodec(name, cfg.Rotom.CompressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	return &CompressionPolicy{
+		Codec:     codec,
+		MinSize:   cfg.Rotom.CompressionMinSize,
+		Negotiate: cfg.Rotom.CompressionNegotiate,
+	}, nil
+}
+
+// Enabled informa se há um codec de verdade.
+func (p *CompressionPolicy) Enabled() bool {
+	return p != nil && p.Codec != nil && p.Codec.Name() != CodecNone
+}
+
+// activeCompression é a política do pipeline em uso; os handlers a consultam
+// para responder ao login.
+var activeCompression atomic.Pointer[CompressionPolicy]
+
+// ActiveCompression devolve a política em uso (nil = sem compressão).
+func ActiveCompression() *CompressionPolicy {
+	return activeCompression.Load()
+}
+
+// Estado da negociação de compressão de um worker com o Rotom.
+const (
+	peerCompressionUnknown int32 = iota
+	peerCompressionYes
+	peerCompressionNo
+)
+
+// setPeerCompression registra o que o Rotom anunciou no login.
+func (w *DataWorker) setPeerCompression(supported bool) {
+	v := peerCompressionNo
+	if supported {
+		v = peerCompressionYes
+	}
+	if w.peerCompress.Swap(v) != v {
+		w.logger.Infof("[%s] peer compression support: %v", w.ID, supported)
+	}
+}
+
+// shouldCompress aplica a política para w (nil = dry run: vale a config).
+func (p *CompressionPolicy) shouldCompress(w *DataWorker) bool {
+	if !p.Enabled() {
+		return false
+	}
+	if !p.Negotiate || w == nil {
+		return true
+	}
+	return w.peerCompress.Load() == peerCompressionYes
+}
+
+// negotiateStage anuncia no LoginRequest enviado (enable_compression) se o
+// worker quer compressão.
+func negotiateStage(job *SendJob, p *CompressionPolicy) error {
+	if job.Hooked || !p.Negotiate {
+		return nil
+	}
+	req, ok := decodeMitmRequest(job.Payload)
+	if !ok || req.GetMethod() != rotompb.MitmRequest_LOGIN || req.GetLoginRequest() == nil {
+		return nil
+	}
+	if req.GetLoginRequest().GetEnableCompression() == p.Enabled() {
+		return nil
+	}
+	req.GetLoginRequest().EnableCompression = p.Enabled()
+	out, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return err
+	}
+	job.Payload = out
+	return nil
+}
+
+// compressStage comprime de acordo com a política. Num MitmRequest, cada
+// SingleRpcRequest.payload a partir de MinSize é comprimido e marcado com
+// is_compressed (o frame continua um MitmRequest legível); um payload opaco é
+// comprimido inteiro e o job fica com Compressed.
+func compressStage(job *SendJob, p *CompressionPolicy) error {
+	if job.Hooked || !p.shouldCompress(job.worker) {
+		return nil
+	}
+	logger := NewLogger()
+	if req, ok := decodeMitmRequest(job.Payload); ok {
+		changed := false
+		for _, single := range req.GetRpcRequest().GetRequest() {
+			if single.GetIsCompressed() || len(single.GetPayload()) < p.MinSize {
+				continue
+			}
+			out, err := p.Codec.Encode(single.GetPayload())
+			if err != nil {
+				logger.Warnf("[send] %s compress failed: %v (sending uncompressed)", p.Codec.Name(), err)
+				return nil
+			}
+			single.Payload, single.IsCompressed = out, true
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		out, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+		if err != nil {
+			return err
+		}
+		metrics.Inc("compress.rpc")
+		job.Payload = out
+		return nil
+	}
+
+	if len(job.Payload) < p.MinSize {
+		return nil
+	}
+	out, err := p.Codec.Encode(job.Payload)
+	if err != nil {
+		logger.Warnf("[send] %s compress failed: %v (sending uncompressed)", p.Codec.Name(), err)
+		return nil
+	}
+	metrics.Inc("compress.opaque")
+	job.Payload = out
+	job.Compressed = true
+	return nil
+}
+
+// sharedZstd abre payloads zstd do peer quando o codec configurado é outro.
+var sharedZstd = &zstdCodec{level: zstd.SpeedDefault}
+
+// sniffCodec identifica pelo magic o codec de um payload comprimido pelo peer.
+// Deflate cru não tem magic: sem o magic de gzip ou zstd é deflate, qualquer
+// que seja o codec configurado.
+func sniffCodec(b []byte) Codec {
+	switch {
+	case len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b:
+		return gzipCodec{level: gzip.DefaultCompression}
+	case len(b) >= 4 && b[0] == 0x28 && b[1] == 0xb5 && b[2] == 0x2f && b[3] == 0xfd:
+		return sharedZstd
+	}
+	return deflateCodec{level: flate.DefaultCompression}
+}
+
+// decompressRpcRequest abre os SingleRpcRequest marcados com is_compressed e
+// devolve o codec usado pelo peer (nil se nada veio comprimido).
+func decompressRpcRequest(req *rotompb.MitmRequest) (Codec, error) {
+	var used Codec
+	for i, single := range req.GetRpcRequest().GetRequest() {
+		if !single.GetIsCompressed() {
+			continue
+		}
+		codec := sniffCodec(single.GetPayload())
+		raw, err := codec.Decode(single.GetPayload())
+		if err != nil {
+			return nil, fmt.Errorf("request %d (method %d): %s: %w", i, single.GetMethod(), codec.Name(), err)
+		}
+		single.Payload, single.IsCompressed = raw, false
+		used = codec
+	}
+	return used, nil
+}
+
+// decompressRpcResponse faz o mesmo para os SingleRpcResponse.
+func decompressRpcResponse(resp *rotompb.MitmResponse) (Codec, error) {
+	var used Codec
+	for i, single := range resp.GetRpcResponse().GetResponse() {
+		if !single.GetIsCompressed() {
+			continue
+		}
+		codec := sniffCodec(single.GetPayload())
+		raw, err := codec.Decode(single.GetPayload())
+		if err != nil {
+			return nil, fmt.Errorf("response %d (method %d): %s: %w", i, single.GetMethod(), codec.Name(), err)
+		}
+		single.Payload, single.IsCompressed = raw, false
+		used = codec
+	}
+	return used, nil
+}
+
+// compressRpcResponse comprime com codec os SingleRpcResponse a partir de
+// minSize que ainda não estejam comprimidos, marcando is_compressed.
+func compressRpcResponse(resp *rotompb.MitmResponse, codec Codec, minSize int) error {
+	for i, single := range resp.GetRpcResponse().GetResponse() {
+		if single.GetIsCompressed() || len(single.GetPayload()) < minSize {
+			continue
+		}
+		out, err := codec.Encode(single.GetPayload())
+		if err != nil {
+			return fmt.Errorf("response %d (method %d): %s: %w", i, single.GetMethod(), codec.Name(), err)
+		}
+		single.Payload, single.IsCompressed = out, true
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+func TestCompressionFromConfigIgnoresEnv(t *testing.T) {
+	t.Setenv("ROTOM_USE_COMPRESSION", "1")
+	cfg := defaultConfig()
+	p, err := compressionFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Enabled() {
+		t.Fatalf("ROTOM_USE_COMPRESSION turned on %s", p.Codec.Name())
+	}
+
+	// o campo legado da config continua valendo
+	cfg.Rotom.UseCompression = true
+	if p, err = compressionFromConfig(cfg); err != nil || p.Codec.Name() != CodecGzip {
+		t.Fatalf("use_compression: codec %v, err %v", p, err)
+	}
+}
+
+// codecsUnderTest são os codecs que o peer pode usar.
+var codecsUnderTest = []string{CodecGzip, CodecZstd, CodecDeflate}
+
+// withActiveCompression troca a política global durante o teste.
+func withActiveCompression(t *testing.T, p *CompressionPolicy) {
+	t.Helper()
+	old := activeCompression.Swap(p)
+	t.Cleanup(func() { activeCompression.Store(old) })
+}
+
+// withRpcHandler registra fn para method só durante o teste.
+func withRpcHandler(t *testing.T, method int32, fn RpcHandlerFn) {
+	t.Helper()
+	RegisterRpcHandler(method, fn)
+	t.Cleanup(func() {
+		handlersMu.Lock()
+		delete(rpcHandlers, method)
+		handlersMu.Unlock()
+	})
+}
+
+func newTestCodec(t *testing.T, name string) Codec {
+	t.Helper()
+	codec, err := NewCodec(name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return codec
+}
+
+// compressedRpcRequest monta um RPC_REQUEST com um SingleRpcRequest
+// comprimido por codec, como o peer manda.
+func compressedRpcRequest(t *testing.T, codec Codec, id uint32, method int32, payload []byte) []byte {
+	t.Helper()
+	enc, err := codec.Encode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := proto.Marshal(&rotompb.MitmRequest{
+		Id:     id,
+		Method: rotompb.MitmRequest_RPC_REQUEST,
+		Payload: &rotompb.MitmRequest_RpcRequest_{RpcRequest: &rotompb.MitmRequest_RpcRequest{
+			Request: []*rotompb.MitmRequest_RpcRequest_SingleRpcRequest{
+				{Method: method, Payload: enc, IsCompressed: true},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecompressRpcRequestPerCodec(t *testing.T) {
+	withActiveCompression(t, nil)
+	payload := bytes.Repeat([]byte("rpc request body "), 32)
+	for _, name := range codecsUnderTest {
+		t.Run(name, func(t *testing.T) {
+			var req rotompb.MitmRequest
+			if err := proto.Unmarshal(compressedRpcRequest(t, newTestCodec(t, name), 1, 106, payload), &req); err != nil {
+				t.Fatal(err)
+			}
+			used, err := decompressRpcRequest(&req)
+			if err != nil {
+				t.Fatalf("decompressRpcRequest: %v", err)
+			}
+			if used == nil || used.Name() != name {
+				t.Fatalf("detected codec %v, want %s", used, name)
+			}
+			single := req.GetRpcRequest().GetRequest()[0]
+			if single.GetIsCompressed() || !bytes.Equal(single.GetPayload(), payload) {
+				t.Fatal("payload not restored")
+			}
+		})
+	}
+}
+
+func TestDispatchMitmRequestAnswersWithPeerCodec(t *testing.T) {
+	withActiveCompression(t, nil)
+	const method = 9106
+	request := bytes.Repeat([]byte("rpc request body "), 32)
+	reply := bytes.Repeat([]byte("rpc response body "), 32)
+	withRpcHandler(t, method, func(req *rotompb.MitmRequest, single *rotompb.MitmRequest_RpcRequest_SingleRpcRequest) *rotompb.MitmResponse_RpcResponse_SingleRpcResponse {
+		// o handler sempre vê o payload aberto
+		if single.GetIsCompressed() || !bytes.Equal(single.GetPayload(), request) {
+			t.Errorf("handler got a compressed or altered payload")
+		}
+		return &rotompb.MitmResponse_RpcResponse_SingleRpcResponse{Payload: reply}
+	})
+
+	for _, name := range codecsUnderTest {
+		t.Run(name, func(t *testing.T) {
+			codec := newTestCodec(t, name)
+			handled, out, err := DispatchMitmRequest(compressedRpcRequest(t, codec, 42, method, request))
+			if err != nil || !handled {
+				t.Fatalf("DispatchMitmRequest: handled=%v err=%v", handled, err)
+			}
+			var resp rotompb.MitmResponse
+			if err := proto.Unmarshal(out, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.GetId() != 42 {
+				t.Fatalf("response id %d, want 42", resp.GetId())
+			}
+			single := resp.GetRpcResponse().GetResponse()[0]
+			if !single.GetIsCompressed() {
+				t.Fatal("response not compressed with the peer codec")
+			}
+			if got := sniffCodec(single.GetPayload()).Name(); got != name {
+				t.Fatalf("response compressed with %s, want %s", got, name)
+			}
+			raw, err := codec.Decode(single.GetPayload())
+			if err != nil || !bytes.Equal(raw, reply) {
+				t.Fatalf("response payload does not decode back: %v", err)
+			}
+		})
+	}
+}
+
+func TestCompressRpcResponsePerCodec(t *testing.T) {
+	big := bytes.Repeat([]byte("rpc response body "), 32)
+	small := []byte("tiny")
+	for _, name := range codecsUnderTest {
+		t.Run(name, func(t *testing.T) {
+			codec := newTestCodec(t, name)
+			resp := &rotompb.MitmResponse{Payload: &rotompb.MitmResponse_RpcResponse_{RpcResponse: &rotompb.MitmResponse_RpcResponse{
+				Response: []*rotompb.MitmResponse_RpcResponse_SingleRpcResponse{
+					{Method: 1, Payload: big},
+					{Method: 2, Payload: small},
+					{Method: 3, Payload: []byte("already compressed"), IsCompressed: true},
+				},
+			}}}
+			if err := compressRpcResponse(resp, codec, 64); err != nil {
+				t.Fatal(err)
+			}
+			singles := resp.GetRpcResponse().GetResponse()
+			if !singles[0].GetIsCompressed() {
+				t.Fatal("payload above min size not compressed")
+			}
+			if raw, err := codec.Decode(singles[0].GetPayload()); err != nil || !bytes.Equal(raw, big) {
+				t.Fatalf("compressed payload does not decode back: %v", err)
+			}
+			if singles[1].GetIsCompressed() || !bytes.Equal(singles[1].GetPayload(), small) {
+				t.Fatal("payload below min size was compressed")
+			}
+			if string(singles[2].GetPayload()) != "already compressed" {
+				t.Fatal("payload already compressed was compressed again")
+			}
+		})
+	}
+}
+
+func TestSniffCodecRawDeflateFallback(t *testing.T) {
+	payload := bytes.Repeat([]byte("rpc body "), 32)
+	raw, err := newTestCodec(t, CodecDeflate).Encode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sem magic e sem política ativa: deflate cru
+	withActiveCompression(t, nil)
+	if got := sniffCodec(raw).Name(); got != CodecDeflate {
+		t.Fatalf("no active codec: sniffed %s, want deflate", got)
+	}
+	if out, err := sniffCodec(raw).Decode(raw); err != nil || !bytes.Equal(out, payload) {
+		t.Fatalf("deflate fallback does not decode: %v", err)
+	}
+
+	// o codec configurado não muda nada: sem magic é sempre deflate, e gzip e
+	// zstd seguem pelo magic
+	for _, active := range []string{CodecGzip, CodecZstd, CodecDeflate} {
+		activeCompression.Store(&CompressionPolicy{Codec: newTestCodec(t, active)})
+		if got := sniffCodec(raw).Name(); got != CodecDeflate {
+			t.Fatalf("%s active: sniffed %s for a payload without magic", active, got)
+		}
+		if out, err := sniffCodec(raw).Decode(raw); err != nil || !bytes.Equal(out, payload) {
+			t.Fatalf("%s active: deflate payload does not decode: %v", active, err)
+		}
+	}
+	for _, name := range []string{CodecGzip, CodecZstd} {
+		enc, err := newTestCodec(t, name).Encode(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sniffCodec(enc).Name(); got != name {
+			t.Fatalf("sniffed %s for a %s payload", got, name)
+		}
+	}
+}
@@ -15,14 +15,33 @@ type Config struct {
 		WorkerEndpoint string `json:"worker_endpoint"`
 		DeviceEndpoint string `json:"device_endpoint"`
 		Secret         string `json:"secret"`
-		UseCompression bool   `json:"use_compression"`
+		UseCompression bool   `json:"use_compression"` // legado: equivale a compression_codec "gzip"
+		AckMode        string `json:"ack_mode"`        // none | response | frame
+		AckTimeoutMs   int    `json:"ack_timeout_ms"`
+		AckField       string `json:"ack_field"`      // campo com o id no frame de ack (modo frame)
+		AckSeqField    string `json:"ack_seq_field"`  // campo com a sequência do frame sem MitmRequest.id (modo frame)
+		DispatchOrder  string `json:"dispatch_order"` // hooks_first | handlers_first
+
+		// CompressionCodec: none | gzip | zstd | deflate
+		CompressionCodec     string `json:"compression_codec"`
+		CompressionLevel     int    `json:"compression_level"`     // 0 = padrão do codec
+		CompressionMinSize   int    `json:"compression_min_size"`  // payloads menores vão sem compressão
+		CompressionNegotiate bool   `json:"compression_negotiate"` // só comprime se o Rotom anunciar suporte no login
 	} `json:"rotom"`
 
 	General struct {
-		DeviceName string `json:"device_name"`
-		Workers    int    `json:"workers"`
-		DnsServer  string `json:"dns_server"`
-		ScanDir    string `json:"scan_dir"`
+		DeviceName     string `json:"device_name"`
+		Workers        int    `json:"workers"`
+		DnsServer      string `json:"dns_server"`
+		ScanDir        string `json:"scan_dir"`
+		ScanMode       string `json:"scan_mode"` // auto | inotify | poll
+		ScanIntervalMs int    `json:"scan_interval_ms"`
+		StableMs       int    `json:"stable_ms"`       // tamanho/mtime parados por esse tempo
+		TempSuffix     string `json:"temp_suffix"`     // arquivos ainda sendo escritos (ex: ".tmp")
+		QuarantineDir  string `json:"quarantine_dir"`  // capturas truncadas/corrompidas
+		DeadLetterDir  string `json:"dead_letter_dir"` // itens que esgotaram max_attempts
+		// ScanSources substitui scan_dir quando definido
+		ScanSources []ScanSource `json:"scan_sources"`
 	} `json:"general"`
 
 	Log struct {
@@ -36,11 +55,64 @@ type Config struct {
 		FilePath  string `json:"file_path"`
 	} `json:"log"`
 
+	Spool struct {
+		Dir             string `json:"dir"`
+		MaxSizeMb       int    `json:"max_size_mb"`
+		SegmentSizeMb   int    `json:"segment_size_mb"`
+		Fsync           string `json:"fsync"` // always | interval | never
+		FsyncIntervalMs int    `json:"fsync_interval_ms"`
+		// LaneWeights divide os envios entre as faixas high/normal/bulk
+		LaneWeights LaneWeights `json:"lane_weights"`
+	} `json:"spool"`
+
+	Batch struct {
+		Enabled    bool `json:"enabled"`      // agrupa itens num frame MitmBatch
+		MaxDelayMs int  `json:"max_delay_ms"` // espera máxima para completar um batch
+		MaxBytes   int  `json:"max_bytes"`
+		MaxItems   int  `json:"max_items"`
+	} `json:"batch"`
+
+	Pogo struct {
+		DescriptorSet string `json:"descriptor_set"` // FileDescriptorSet (.desc/.binpb) dos protos do jogo
+		MethodMap     string `json:"method_map"`     // JSON: método RPC -> tipos de request/resposta
+		LogPayloads   bool   `json:"log_payloads"`   // loga (debug) os payloads RPC recebidos como JSON
+	} `json:"pogo"`
+
+	Location struct {
+		// um deslocamento maior que JumpDistanceM em menos de JumpWindowMs gera aviso (0 = desliga)
+		JumpDistanceM float64 `json:"jump_distance_m"`
+		JumpWindowMs  int     `json:"jump_window_ms"`
+	} `json:"location"`
+
 	Tuning struct {
 		WorkerSpawnDelayMs int `json:"worker_spawn_delay_ms"`
+		RequestTimeoutMs   int `json:"request_timeout_ms"`
+		MaxAttempts        int `json:"max_attempts"` // 0 = sem limite
+		// RetryBackoffMs é a espera após cada falha; o último valor se repete
+		RetryBackoffMs []int `json:"retry_backoff_ms"`
+		// DedupWindowMs descarta payloads repetidos nesse intervalo (0 = desliga)
+		DedupWindowMs   int `json:"dedup_window_ms"`
+		DedupMaxEntries int `json:"dedup_max_entries"`
+		// limites de saída no /data, somando todos os workers (0 = sem limite).
+		// rate_msgs_per_sec negativo (o padrão) vira 8 msg/s por worker.
+		RateMsgsPerSec  float64 `json:"rate_msgs_per_sec"`
+		RateBytesPerSec float64 `json:"rate_bytes_per_sec"`
+		RateBurstMsgs   int     `json:"rate_burst_msgs"`
+		RateBurstBytes  int     `json:"rate_burst_bytes"`
 	} `json:"tuning"`
 }
 
+// ScanSource é um diretório varrido pelo scanner e os filtros dele.
+type ScanSource struct {
+	Dir     string   `json:"dir"`
+	Include []string `json:"include"` // globs; vazio = tudo
+	Exclude []string `json:"exclude"`
+	Depth   int      `json:"depth"`    // níveis de subdiretórios (0 = só o topo, -1 = sem limite)
+	MinSize int64    `json:"min_size"` // bytes
+	MaxSize int64    `json:"max_size"` // bytes (0 = sem limite)
+	Tag     string   `json:"tag"`      // vai no SendItem.Tag
+}
+
 // defaultConfig preenche valores seguros caso falhe a leitura do arquivo.
 func defaultConfig() Config {
 	var c Config
@@ -48,11 +120,25 @@ func defaultConfig() Config {
 	c.Rotom.DeviceEndpoint = ""
 	c.Rotom.Secret = ""
 	c.Rotom.UseCompression = false
+	c.Rotom.CompressionCodec = ""
+	c.Rotom.CompressionMinSize = 256
+	c.Rotom.CompressionNegotiate = true
+	c.Rotom.AckMode = "none"
+	c.Rotom.AckTimeoutMs = 30000
+	c.Rotom.AckField = "ack"
+	c.Rotom.AckSeqField = "seq"
+	c.Rotom.DispatchOrder = DispatchHooksFirst
 
 	c.General.DeviceName = "android-device"
 	c.General.Workers = 1
 	c.General.DnsServer = "1.1.1.1:53"
 	c.General.ScanDir = "/data/local/tmp/rotom_inbox"
+	c.General.ScanMode = ScanModeAuto
+	c.General.ScanIntervalMs = 3000
+	c.General.StableMs = 1000
+	c.General.TempSuffix = ".tmp"
+	c.General.QuarantineDir = "/data/local/tmp/rotom_quarantine"
+	c.General.DeadLetterDir = "/data/local/tmp/rotom_dead_letter"
 
 	c.Log.Level = "info"
 	c.Log.UseColors = true
@@ -63,15 +149,40 @@ func defaultConfig() Config {
 	c.Log.Compress = false
 	c.Log.FilePath = "/data/local/tmp/rotom-worker.log"
 
+	c.Spool.Dir = "/data/local/tmp/rotom_spool"
+	c.Spool.MaxSizeMb = 256
+	c.Spool.SegmentSizeMb = 8
+	c.Spool.Fsync = "interval"
+	c.Spool.FsyncIntervalMs = 1000
+	c.Spool.LaneWeights = LaneWeights{High: 8, Normal: 3, Bulk: 1}
+
+	c.Batch.Enabled = false
+	c.Batch.MaxDelayMs = 50
+	c.Batch.MaxBytes = 256 << 10
+	c.Batch.MaxItems = 64
+
+	c.Location.JumpDistanceM = 5000
+	c.Location.JumpWindowMs = 60000
+
 	c.Tuning.WorkerSpawnDelayMs = 500
+	c.Tuning.RequestTimeoutMs = 30000
+	c.Tuning.MaxAttempts = 8
+	c.Tuning.RetryBackoffMs = []int{1000, 5000, 30000, 120000}
+	c.Tuning.DedupWindowMs = 60000
+	c.Tuning.DedupMaxEntries = 10000
+	// automático: o sanitize escala pelo número de workers
+	c.Tuning.RateMsgsPerSec = -1
 	return c
 }
 
 // ReadConfig lê o arquivo JSON em path. Em caso de erro, retorna defaults e imprime aviso.
+// Todo retorno passa pelo sanitize: alguns defaults (ex: rate_msgs_per_sec)
+// só são resolvidos nele.
 func ReadConfig(path string) Config {
 	cfg := defaultConfig()
 
 	if path == "" {
+		cfg.sanitize()
 		return cfg
 	}
 
@@ -79,11 +190,14 @@ func ReadConfig(path string) Config {
 	if err != nil {
 		// não aborta; apenas informa e retorna defaults
 		fmt.Fprintf(os.Stderr, "[config] warning: cannot read %s: %v — usando defaults\n", path, err)
+		cfg.sanitize()
 		return cfg
 	}
 
 	if err := json.Unmarshal(b, &cfg); err != nil {
 		fmt.Fprintf(os.Stderr, "[config] parse error %s: %v — usando defaults\n", path, err)
+		cfg = defaultConfig()
+		cfg.sanitize()
 		return cfg
 	}
 
@@ -111,12 +225,138 @@ func (c *Config) sanitize() {
 	}
 
 	// garantir valores mínimos válidos
+	switch AckMode(c.Rotom.AckMode) {
+	case AckNone, AckResponse, AckFrame:
+	default:
+		c.Rotom.AckMode = string(AckNone)
+	}
+	if c.Rotom.AckTimeoutMs <= 0 {
+		c.Rotom.AckTimeoutMs = 30000
+	}
+	if c.Rotom.AckField == "" {
+		c.Rotom.AckField = "ack"
+	}
+	if c.Rotom.AckSeqField == "" {
+		c.Rotom.AckSeqField = "seq"
+	}
+	if c.Rotom.AckSeqField == c.Rotom.AckField {
+		// o ack por id é lido primeiro: a sequência nunca seria confirmada
+		NewLogger().Warnf("[config] rotom.ack_seq_field %q is the same as rotom.ack_field; frames without MitmRequest.id will never be acked", c.Rotom.AckSeqField)
+	}
+	switch c.Rotom.DispatchOrder {
+	case DispatchHooksFirst, DispatchHandlersFirst:
+	default:
+		c.Rotom.DispatchOrder = DispatchHooksFirst
+	}
+	c.Rotom.CompressionCodec = strings.ToLower(strings.TrimSpace(c.Rotom.CompressionCodec))
+	if c.Rotom.CompressionMinSize < 0 {
+		c.Rotom.CompressionMinSize = 0
+	}
 	if c.General.Workers < 1 {
 		c.General.Workers = 1
 	}
+	switch c.General.ScanMode {
+	case ScanModeAuto, ScanModeInotify, ScanModePoll:
+	default:
+		c.General.ScanMode = ScanModeAuto
+	}
+	if c.General.ScanIntervalMs <= 0 {
+		c.General.ScanIntervalMs = 3000
+	}
+	if c.General.StableMs < 0 {
+		c.General.StableMs = 0
+	}
+	// fonte sem dir viraria "." (o diretório de trabalho); sem nenhuma fonte
+	// válida o scanner volta para o scan_dir
+	sources := c.General.ScanSources[:0]
+	for i, src := range c.General.ScanSources {
+		src.Dir = strings.TrimSpace(src.Dir)
+		if src.Dir == "" {
+			fmt.Fprintf(os.Stderr, "[config] warning: scan_sources[%d] has no dir — ignorada\n", i)
+			continue
+		}
+		if src.MinSize <= 0 {
+			src.MinSize = 16
+		}
+		if src.MaxSize < 0 {
+			src.MaxSize = 0
+		}
+		sources = append(sources, src)
+	}
+	c.General.ScanSources = sources
 	if c.Tuning.WorkerSpawnDelayMs <= 0 {
 		c.Tuning.WorkerSpawnDelayMs = 500
 	}
+	if c.Spool.Dir == "" {
+		c.Spool.Dir = "/data/local/tmp/rotom_spool"
+	}
+	if c.Spool.MaxSizeMb <= 0 {
+		c.Spool.MaxSizeMb = 256
+	}
+	if c.Spool.SegmentSizeMb <= 0 {
+		c.Spool.SegmentSizeMb = 8
+	}
+	switch c.Spool.Fsync {
+	case "always", "interval", "never":
+	default:
+		c.Spool.Fsync = "interval"
+	}
+	if c.Spool.FsyncIntervalMs <= 0 {
+		c.Spool.FsyncIntervalMs = 1000
+	}
+	if c.Spool.LaneWeights.High <= 0 {
+		c.Spool.LaneWeights.High = 8
+	}
+	if c.Spool.LaneWeights.Normal <= 0 {
+		c.Spool.LaneWeights.Normal = 3
+	}
+	if c.Spool.LaneWeights.Bulk <= 0 {
+		c.Spool.LaneWeights.Bulk = 1
+	}
+	if c.Batch.MaxDelayMs <= 0 {
+		c.Batch.MaxDelayMs = 50
+	}
+	if c.Batch.MaxBytes <= 0 {
+		c.Batch.MaxBytes = 256 << 10
+	}
+	if c.Batch.MaxItems < 0 {
+		c.Batch.MaxItems = 0
+	}
+	if c.Location.JumpDistanceM < 0 {
+		c.Location.JumpDistanceM = 0
+	}
+	if c.Location.JumpWindowMs <= 0 {
+		c.Location.JumpWindowMs = 60000
+	}
+	if c.Tuning.RequestTimeoutMs <= 0 {
+		c.Tuning.RequestTimeoutMs = 30000
+	}
+	if c.Tuning.MaxAttempts < 0 {
+		c.Tuning.MaxAttempts = 0
+	}
+	backoff := c.Tuning.RetryBackoffMs[:0]
+	for _, ms := range c.Tuning.RetryBackoffMs {
+		if ms > 0 {
+			backoff = append(backoff, ms)
+		}
+	}
+	if len(backoff) == 0 {
+		backoff = []int{1000, 5000, 30000, 120000}
+	}
+	c.Tuning.RetryBackoffMs = backoff
+	if c.Tuning.DedupWindowMs < 0 {
+		c.Tuning.DedupWindowMs = 0
+	}
+	if c.Tuning.DedupMaxEntries <= 0 {
+		c.Tuning.DedupMaxEntries = 10000
+	}
+	if c.Tuning.RateMsgsPerSec < 0 {
+		// ~ o antigo intervalo fixo de 120ms entre envios de cada worker
+		c.Tuning.RateMsgsPerSec = 8 * float64(c.General.Workers)
+	}
+	if c.Tuning.RateBytesPerSec < 0 {
+		c.Tuning.RateBytesPerSec = 0
+	}
 	if c.Log.MaxSize <= 0 {
 		c.Log.MaxSize = 10
 	}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeScanSources(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.General.ScanSources = []ScanSource{
+		{Dir: ""},
+		{Dir: "  /data/captures  ", Tag: "a"},
+		{Dir: "   "},
+		{Dir: "/data/other", MinSize: 64, MaxSize: -1},
+	}
+	cfg.sanitize()
+
+	got := cfg.General.ScanSources
+	if len(got) != 2 {
+		t.Fatalf("got %d source(s), want 2: %+v", len(got), got)
+	}
+	if got[0].Dir != "/data/captures" || got[0].MinSize != 16 || got[0].Tag != "a" {
+		t.Fatalf("first source %+v, want trimmed dir and min_size 16", got[0])
+	}
+	if got[1].MinSize != 64 || got[1].MaxSize != 0 {
+		t.Fatalf("second source %+v, want min_size 64 and max_size 0", got[1])
+	}
+}
+
+func TestSanitizeScalesDefaultRate(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		cfg := defaultConfig()
+		cfg.General.Workers = workers
+		cfg.sanitize()
+		if want := 8 * float64(workers); cfg.Tuning.RateMsgsPerSec != want {
+			t.Fatalf("%d worker(s): rate %v msg/s, want %v", workers, cfg.Tuning.RateMsgsPerSec, want)
+		}
+	}
+
+	// um valor explícito não muda com os workers
+	cfg := defaultConfig()
+	cfg.General.Workers = 4
+	cfg.Tuning.RateMsgsPerSec = 5
+	cfg.sanitize()
+	if cfg.Tuning.RateMsgsPerSec != 5 {
+		t.Fatalf("explicit rate changed to %v", cfg.Tuning.RateMsgsPerSec)
+	}
+}
+
+func TestReadConfigResolvesDefaultRateOnErrors(t *testing.T) {
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(broken, []byte(`{"general": {`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, path := range map[string]string{
+		"no path":     "",
+		"missing":     filepath.Join(dir, "missing.json"),
+		"parse error": broken,
+	} {
+		cfg := ReadConfig(path)
+		if cfg.Tuning.RateMsgsPerSec != 8 {
+			t.Fatalf("%s: rate %v msg/s, want the default 8", name, cfg.Tuning.RateMsgsPerSec)
+		}
+	}
+}
+
+func TestSanitizeAckFields(t *testing.T) {
+	cases := []struct {
+		name                  string
+		ackField, seqField    string
+		wantAck, wantSeqField string
+	}{
+		{"both empty", "", "", "ack", "seq"},
+		{"empty seq field", "id", "", "id", "seq"},
+		{"empty ack field keeps custom seq", "", "frame_seq", "ack", "frame_seq"},
+		{"both custom", "id", "n", "id", "n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.Rotom.AckField, cfg.Rotom.AckSeqField = tc.ackField, tc.seqField
+			cfg.sanitize()
+			if cfg.Rotom.AckField != tc.wantAck || cfg.Rotom.AckSeqField != tc.wantSeqField {
+				t.Fatalf("ack_field %q ack_seq_field %q, want %q and %q", cfg.Rotom.AckField, cfg.Rotom.AckSeqField, tc.wantAck, tc.wantSeqField)
+			}
+		})
+	}
+}
+
+func TestSanitizeScanModeAndInterval(t *testing.T) {
+	cases := []struct {
+		mode         string
+		interval     int
+		wantMode     string
+		wantInterval int
+	}{
+		{"", 0, ScanModeAuto, 3000},
+		{ScanModePoll, -5, ScanModePoll, 3000},
+		{ScanModeInotify, 250, ScanModeInotify, 250},
+		{"fanotify", 1000, ScanModeAuto, 1000},
+	}
+	for _, tc := range cases {
+		cfg := defaultConfig()
+		cfg.General.ScanMode, cfg.General.ScanIntervalMs = tc.mode, tc.interval
+		cfg.sanitize()
+		if cfg.General.ScanMode != tc.wantMode || cfg.General.ScanIntervalMs != tc.wantInterval {
+			t.Fatalf("scan_mode %q interval %d: got %q and %d, want %q and %d",
+				tc.mode, tc.interval, cfg.General.ScanMode, cfg.General.ScanIntervalMs, tc.wantMode, tc.wantInterval)
+		}
+	}
+}
@@ -2,7 +2,10 @@ package internal
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -68,8 +71,11 @@ func ControlLoop(ctx context.Context, cfg Config) {
 			logger.Warnf("[control] intro marshal failed: %v", err)
 		}
 
-		// reader goroutine
+		// o gorilla/websocket aceita um único escritor por conexão: o leitor
+		// só monta as respostas e este loop é quem as escreve
 		readErrCh := make(chan error, 1)
+		replies := make(chan map[string]any, 16)
+		done := make(chan struct{})
 		go func(c *websocket.Conn) {
 			defer close(readErrCh)
 			for {
@@ -80,29 +86,14 @@ func ControlLoop(ctx context.Context, cfg Config) {
 				}
 				// handle control message (json or text)
 				logger.Infof("[control] recv: %s", string(msg))
-
-				// Example: basic command handling (toggle debug or reload hooks)
-				var m map[string]any
-				if err := json.Unmarshal(msg, &m); err == nil {
-					if cmd, ok := m["cmd"].(string); ok {
-						switch cmd {
-						case "reload_hooks":
-							logger.Info("[control] reload_hooks command received; reloading libs")
-							// simplistic reload: unload and attempt to reload paths in ROTOM_LIBS
-							// (user libs reloaded only if variable set)
-							ReloadHookLibsFromEnv()
-						case "status":
-							// respond with a simple status message
-							status := map[string]any{
-								"type":    "status",
-								"workers": cfg.General.Workers,
-								"device":  cfg.General.DeviceName,
-							}
-							if bb, err := json.Marshal(status); err == nil {
-								_ = c.WriteMessage(websocket.TextMessage, bb)
-							}
-						}
-					}
+				reply := handleControlCommand(cfg, msg)
+				if reply == nil {
+					continue
+				}
+				select {
+				case replies <- reply:
+				case <-done:
+					return
 				}
 			}
 		}(conn)
@@ -111,6 +102,10 @@ func ControlLoop(ctx context.Context, cfg Config) {
 		ticker := time.NewTicker(15 * time.Second)
 		closed := false
 		for !closed {
+			var (
+				out  map[string]any
+				what string
+			)
 			select {
 			case <-ctx.Done():
 				logger.Info("[control] context canceled -> closing connection")
@@ -121,23 +116,37 @@ func ControlLoop(ctx context.Context, cfg Config) {
 				conn.Close()
 				closed = true
 			case <-ticker.C:
-				hb := map[string]any{
-					"type":     "heartbeat",
-					"ts":       time.Now().Unix(),
-					"workerId": cfg.General.DeviceName,
-				}
-				if b, err := json.Marshal(hb); err == nil {
-					conn.SetWriteDeadline(time.Now().Add(8 * time.Second))
-					if err := conn.WriteMessage(websocket.TextMessage, b); err != nil {
-						logger.Warnf("[control] heartbeat write failed: %v", err)
-						conn.Close()
-						closed = true
-					} else {
-						logger.Debug("[control] heartbeat sent")
-					}
+				out = map[string]any{
+					"type":      "heartbeat",
+					"ts":        time.Now().Unix(),
+					"workerId":  cfg.General.DeviceName,
+					"sessions":  SessionSnapshot(),
+					"locations": LocationSnapshot(),
 				}
+				what = "heartbeat"
+			case out = <-replies:
+				what = "reply"
+			case out = <-controlEvents:
+				what = "event"
+			}
+			if out == nil {
+				continue
+			}
+			b, err := json.Marshal(out)
+			if err != nil {
+				logger.Warnf("[control] %s marshal failed: %v", what, err)
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(8 * time.Second))
+			if err := conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				logger.Warnf("[control] %s write failed: %v", what, err)
+				conn.Close()
+				closed = true
+			} else if what == "heartbeat" {
+				logger.Debug("[control] heartbeat sent")
 			}
 		}
+		close(done)
 
 		// small pause before reconnect
 		ticker.Stop()
@@ -145,6 +154,148 @@ func ControlLoop(ctx context.Context, cfg Config) {
 	}
 }
 
+// controlEvents leva eventos (ex: location_jump) ao canal de controle; eles
+// saem junto com os heartbeats.
+var controlEvents = make(chan map[string]any, 64)
+
+// emitControlEvent enfileira ev sem bloquear; com a fila cheia (controle
+// desconectado), o evento é descartado.
+func emitControlEvent(ev map[string]any) {
+	select {
+	case controlEvents <- ev:
+	default:
+		metrics.Inc("control.events_dropped")
+	}
+}
+
+// handleControlCommand executa um comando recebido no /control e devolve a
+// resposta a ser escrita, ou nil quando o comando não tem resposta.
+func handleControlCommand(cfg Config, msg []byte) map[string]any {
+	logger := NewLogger()
+	// Example: basic command handling (toggle debug or reload hooks)
+	var m map[string]any
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return nil
+	}
+	cmd, _ := m["cmd"].(string)
+	switch cmd {
+	case "reload_hooks":
+		logger.Info("[control] reload_hooks command received; reloading libs")
+		// simplistic reload: unload and attempt to reload paths in ROTOM_LIBS
+		// (user libs reloaded only if variable set)
+		ReloadHookLibsFromEnv()
+	case "reload_pogo":
+		reply := map[string]any{"type": "reload_pogo"}
+		if err := LoadPogoDescriptors(cfg); err != nil {
+			reply["error"] = err.Error()
+			logger.Warnf("[control] reload_pogo failed: %v", err)
+		} else {
+			reply["ok"] = true
+		}
+		return reply
+	case "pause":
+		for _, w := range controlTargets(m) {
+			w.pause.Pause("paused by control channel")
+			logger.Infof("[control] %s paused", w.ID)
+		}
+	case "resume":
+		for _, w := range controlTargets(m) {
+			if w.pause.Resume() {
+				metrics.Inc("status.resumed")
+				logger.Infof("[control] %s resumed", w.ID)
+			}
+		}
+	case "inject":
+		reply := map[string]any{"type": "inject"}
+		if err := injectFromControl(m); err != nil {
+			reply["error"] = err.Error()
+			logger.Warnf("[control] inject failed: %v", err)
+		} else {
+			reply["ok"] = true
+		}
+		return reply
+	case "set_rate":
+		// campos ausentes mantêm o valor atual
+		l := sendLimiter.Limits()
+		if v, ok := m["msgs_per_sec"].(float64); ok && v >= 0 {
+			l.MsgsPerSec = v
+		}
+		if v, ok := m["bytes_per_sec"].(float64); ok && v >= 0 {
+			l.BytesPerSec = v
+		}
+		if v, ok := m["burst_msgs"].(float64); ok && v >= 0 {
+			l.BurstMsgs = int(v)
+		}
+		if v, ok := m["burst_bytes"].(float64); ok && v >= 0 {
+			l.BurstBytes = int(v)
+		}
+		sendLimiter.Set(l)
+		logger.Infof("[control] rate limits set: %+v", l)
+		return map[string]any{"type": "set_rate", "rate": l}
+	case "replay_dead_letters":
+		limit := 0
+		if v, ok := m["limit"].(float64); ok {
+			limit = int(v)
+		}
+		n, err := ReplayDeadLetters(limit)
+		reply := map[string]any{"type": "replay_dead_letters", "replayed": n}
+		if err != nil {
+			reply["error"] = err.Error()
+			logger.Warnf("[control] replay_dead_letters stopped after %d item(s): %v", n, err)
+		} else {
+			logger.Infof("[control] replayed %d dead-lettered item(s)", n)
+		}
+		return reply
+	case "status":
+		// respond with a simple status message
+		return map[string]any{
+			"type":        "status",
+			"workers":     cfg.General.Workers,
+			"device":      cfg.General.DeviceName,
+			"dataWorkers": DataWorkersSnapshot(),
+			"sessions":    SessionSnapshot(),
+			"metrics":     metrics.Snapshot(),
+			"spool":       sendSpool.Stats(),
+			"claims":      scanClaims.Len(),
+			"rate":        sendLimiter.Limits(),
+			"locations":   LocationSnapshot(),
+		}
+	}
+	return nil
+}
+
+// controlTargets resolve o campo opcional "worker" de um comando; sem ele o
+// comando vale para todos os workers.
+func controlTargets(m map[string]any) []*DataWorker {
+	if id, ok := m["worker"].(string); ok && id != "" {
+		if w := DataWorkerByID(id); w != nil {
+			return []*DataWorker{w}
+		}
+		NewLogger().Warnf("[control] unknown worker %q", id)
+		return nil
+	}
+	return DataWorkers()
+}
+
+// injectFromControl grava no spool o payload (base64) de um comando "inject":
+// {"cmd":"inject","payload":"...","priority":"high","worker":"...","tag":"..."}.
+func injectFromControl(m map[string]any) error {
+	b64, _ := m["payload"].(string)
+	payload, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return fmt.Errorf("payload: %w", err)
+	}
+	if len(payload) == 0 {
+		return errors.New("payload is empty")
+	}
+	it := SendItem{Payload: payload, Origin: OriginControl}
+	prio, _ := m["priority"].(string)
+	it.Priority = ParsePriority(prio)
+	it.WorkerID, _ = m["worker"].(string)
+	it.Tag, _ = m["tag"].(string)
+	return Enqueue(it)
+}
+
 // ReloadHookLibsFromEnv is a small helper that unloads current hook libs and attempts to reload
 // the colon-separated paths in ROTOM_LIBS environment variable.
 func ReloadHookLibsFromEnv() {
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestControlLoopSerializesRepliesAndEvents(t *testing.T) {
+	const n = 50
+	type result struct{ statuses, events int }
+	results := make(chan result, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		go func() {
+			for i := 0; i < n; i++ {
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"cmd":"status"}`))
+			}
+		}()
+		var res result
+		conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		for res.statuses < n || res.events < n {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				break
+			}
+			var m map[string]any
+			if err := json.Unmarshal(msg, &m); err != nil {
+				t.Errorf("frame is not json: %q", msg)
+				break
+			}
+			switch m["type"] {
+			case "status":
+				res.statuses++
+			case "location_jump":
+				res.events++
+			}
+		}
+		results <- res
+	}))
+	defer srv.Close()
+
+	cfg := testConfig(t)
+	cfg.Rotom.DeviceEndpoint = "ws" + strings.TrimPrefix(srv.URL, "http")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ControlLoop(ctx, cfg)
+
+	// os eventos saem do loop principal enquanto o leitor responde aos status
+	go func() {
+		for i := 0; i < n; i++ {
+			controlEvents <- map[string]any{"type": "location_jump", "seq": i}
+		}
+	}()
+
+	select {
+	case res := <-results:
+		if res.statuses != n || res.events != n {
+			t.Fatalf("got %d status replies and %d events, want %d of each", res.statuses, res.events, n)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("control loop did not deliver replies and events")
+	}
+}
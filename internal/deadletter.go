@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDeadLettered é o erro entregue ao OnResult de um item que esgotou as
+// tentativas e foi para o dead-letter.
+var ErrDeadLettered = errors.New("send gave up; item moved to dead-letter")
+
+// retryPolicy limita as tentativas de envio de um SendItem. Esgotado o
+// orçamento, o item vai para o diretório de dead-letter.
+type retryPolicy struct {
+	maxAttempts int
+	backoff     []time.Duration
+	dir         string
+}
+
+// retry é a política ativa; SetRetryPolicy a troca pela da configuração.
+var retry = retryPolicy{
+	maxAttempts: 8,
+	backoff:     []time.Duration{time.Second, 5 * time.Second, 30 * time.Second, 2 * time.Minute},
+	dir:         "/data/local/tmp/rotom_dead_letter",
+}
+
+// SetRetryPolicy aplica tuning.max_attempts, tuning.retry_backoff_ms e
+// general.dead_letter_dir.
+func SetRetryPolicy(cfg Config) {
+	p := retryPolicy{maxAttempts: cfg.Tuning.MaxAttempts, dir: cfg.General.DeadLetterDir}
+	for _, ms := range cfg.Tuning.RetryBackoffMs {
+		p.backoff = append(p.backoff, time.Duration(ms)*time.Millisecond)
+	}
+	if len(p.backoff) == 0 {
+		p.backoff = retry.backoff
+	}
+	retry = p
+}
+
+// delay é a espera antes da tentativa seguinte à de número attempts (1-based);
+// o último valor do schedule se repete.
+func (p retryPolicy) delay(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	if attempts > len(p.backoff) {
+		return p.backoff[len(p.backoff)-1]
+	}
+	return p.backoff[attempts-1]
+}
+
+// attemptState é o histórico de um arquivo do scan_dir. Ele sobrevive ao
+// claim liberado: a releitura do arquivo continua a contagem.
+type attemptState struct {
+	attempts  int
+	lastError string
+	notBefore time.Time
+}
+
+var (
+	attemptsMu   sync.Mutex
+	fileAttempts = map[string]attemptState{}
+)
+
+// restoreAttempts copia o histórico de path para item e informa se o arquivo
+// ainda está esperando o backoff.
+func restoreAttempts(item *SendItem) (waiting bool) {
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	st, ok := fileAttempts[item.Path]
+	if !ok {
+		return false
+	}
+	item.Attempts, item.LastError = st.attempts, st.lastError
+	return time.Now().Before(st.notBefore)
+}
+
+func forgetAttempts(path string) {
+	if path == "" {
+		return
+	}
+	attemptsMu.Lock()
+	delete(fileAttempts, path)
+	attemptsMu.Unlock()
+}
+
+// failItem conta uma tentativa falha de it. Com o orçamento esgotado, o item
+// vai para o dead-letter; senão volta depois do backoff.
+func failItem(it SendItem, cause error) {
+	logger := NewLogger()
+	it.Attempts++
+	it.LastError = cause.Error()
+	metrics.Inc("send.failed")
+
+	if retry.maxAttempts > 0 && it.Attempts >= retry.maxAttempts {
+		deadLetter(it)
+		return
+	}
+	delay := retry.delay(it.Attempts)
+	logger.Warnf("[send] attempt %d/%d for %s failed: %v; retrying in %s", it.Attempts, retry.maxAttempts, describeItem(it), cause, delay)
+	if it.Path != "" {
+		attemptsMu.Lock()
+		fileAttempts[it.Path] = attemptState{attempts: it.Attempts, lastError: it.LastError, notBefore: time.Now().Add(delay)}
+		attemptsMu.Unlock()
+		time.AfterFunc(delay, kickScanner)
+	}
+	releaseItem(it, delay)
+}
+
+// deadLetterRecord é o sidecar JSON gravado ao lado do payload.
+type deadLetterRecord struct {
+	Path      string    `json:"path,omitempty"`
+	Tag       string    `json:"tag,omitempty"`
+	WorkerID  string    `json:"worker,omitempty"`
+	Part      int       `json:"part,omitempty"`
+	Parts     int       `json:"parts,omitempty"`
+	Size      int       `json:"size"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	FailedAt  time.Time `json:"failed_at"`
+	// Payload é o nome do arquivo com os bytes, no mesmo diretório.
+	Payload string `json:"payload"`
+}
+
+// deadLetter grava it no diretório de dead-letter e o tira de circulação: o
+// registro sai do spool e o arquivo de origem é tratado como entregue.
+func deadLetter(it SendItem) {
+	logger := NewLogger()
+	metrics.Inc("send.dead_lettered")
+	if err := writeDeadLetter(retry.dir, it); err != nil {
+		// sem onde guardar: melhor manter no spool do que perder
+		logger.Errorf("[send] cannot dead-letter %s: %v; keeping it queued", describeItem(it), err)
+		releaseItem(it, retry.delay(it.Attempts))
+		return
+	}
+	logger.Errorf("[send] gave up on %s after %d attempt(s): %s; moved to %s", describeItem(it), it.Attempts, it.LastError, retry.dir)
+	if it.OnResult != nil {
+		// SendRequest espera pelo resultado: não haverá outra tentativa
+		it.OnResult(PendingResult{Item: it, Err: fmt.Errorf("%w after %d attempt(s): %s", ErrDeadLettered, it.Attempts, it.LastError)})
+	}
+
+	ackItem(it)
+	clearInFlight(it.Path)
+	if it.Path == "" {
+		return
+	}
+	if it.Parts > 1 && captureGroups.Done(it.Path, it.Part) > 0 {
+		// as outras partes seguem; a cópia desta já está no dead-letter
+		return
+	}
+	forgetAttempts(it.Path)
+	_ = os.Remove(it.Path)
+	scanClaims.Done(it.Path)
+}
+
+func writeDeadLetter(dir string, it SendItem) error {
+	if dir == "" {
+		return errors.New("dead_letter_dir is not set")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	base := fmt.Sprintf("%d-%s", time.Now().UnixNano(), strings.ReplaceAll(describeItem(it), "/", "_"))
+	payload := base + ".bin"
+	if err := os.WriteFile(filepath.Join(dir, payload), it.Payload, 0644); err != nil {
+		return err
+	}
+	rec := deadLetterRecord{
+		Path:      it.Path,
+		Tag:       it.Tag,
+		WorkerID:  it.WorkerID,
+		Part:      it.Part,
+		Parts:     it.Parts,
+		Size:      len(it.Payload),
+		Attempts:  it.Attempts,
+		LastError: it.LastError,
+		FailedAt:  time.Now().UTC(),
+		Payload:   payload,
+	}
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	// o sidecar por último: um .json sempre tem o .bin correspondente
+	return os.WriteFile(filepath.Join(dir, base+".json"), b, 0644)
+}
+
+// ReplayDeadLetters devolve ao spool até limit itens do dead-letter (0 = todos),
+// do mais antigo ao mais novo, com o contador de tentativas zerado.
+func ReplayDeadLetters(limit int) (replayed int, err error) {
+	dir := retry.dir
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var sidecars []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			sidecars = append(sidecars, e.Name())
+		}
+	}
+	sort.Strings(sidecars)
+
+	for _, name := range sidecars {
+		if limit > 0 && replayed >= limit {
+			break
+		}
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return replayed, err
+		}
+		var rec deadLetterRecord
+		if err := json.Unmarshal(b, &rec); err != nil {
+			NewLogger().Warnf("[send] bad dead-letter sidecar %s: %v", name, err)
+			continue
+		}
+		payload, err := os.ReadFile(filepath.Join(dir, rec.Payload))
+		if err != nil {
+			return replayed, err
+		}
+		// o arquivo de origem já foi removido: o item volta só pelo spool
+		it := SendItem{Payload: payload, Tag: rec.Tag, WorkerID: rec.WorkerID, Origin: OriginControl}
+		if err := enqueueAgain([]SendItem{it}); err != nil {
+			return replayed, err
+		}
+		_ = os.Remove(filepath.Join(dir, name))
+		_ = os.Remove(filepath.Join(dir, rec.Payload))
+		replayed++
+		metrics.Inc("send.dead_letter_replayed")
+	}
+	return replayed, nil
+}
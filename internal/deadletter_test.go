@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// deadLetterConfig devolve uma config de teste com três tentativas e backoff
+// curto.
+func deadLetterConfig(t *testing.T) Config {
+	t.Helper()
+	cfg := testConfig(t)
+	cfg.Tuning.MaxAttempts = 3
+	cfg.Tuning.RetryBackoffMs = []int{1}
+	return cfg
+}
+
+func TestFailItemDeadLettersSpoolItem(t *testing.T) {
+	cfg := deadLetterConfig(t)
+	s := openTestSpool(t, cfg)
+	if err := Enqueue(SendItem{Payload: []byte("doomed payload")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// cada falha passa pelo Requeue do spool; a terceira esgota o orçamento
+	for attempt := 1; attempt <= cfg.Tuning.MaxAttempts; attempt++ {
+		it := nextItem(t, s)
+		if it.Attempts != attempt-1 {
+			t.Fatalf("attempt %d: item has %d previous attempt(s)", attempt, it.Attempts)
+		}
+		failItem(it, errors.New("write: broken pipe"))
+	}
+	expectNoItem(t, s)
+
+	sidecars, _ := filepath.Glob(filepath.Join(cfg.General.DeadLetterDir, "*.json"))
+	if len(sidecars) != 1 {
+		t.Fatalf("dead-letter has %d sidecar(s), want 1", len(sidecars))
+	}
+	if st := s.Stats(); st.Ready+st.InFlight != 0 {
+		t.Fatalf("spool still holds the item: %+v", st)
+	}
+}
+
+func TestDeadLetterNotifiesOnResult(t *testing.T) {
+	cfg := deadLetterConfig(t)
+	openTestSpool(t, cfg)
+
+	got := make(chan PendingResult, 1)
+	it := SendItem{
+		Payload:  []byte("request"),
+		Attempts: cfg.Tuning.MaxAttempts - 1,
+		OnResult: func(r PendingResult) { got <- r },
+	}
+	failItem(it, errors.New("write: broken pipe"))
+
+	select {
+	case r := <-got:
+		if !errors.Is(r.Err, ErrDeadLettered) {
+			t.Fatalf("OnResult error %v, want ErrDeadLettered", r.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnResult not called for a dead-lettered item")
+	}
+	if entries, _ := os.ReadDir(cfg.General.DeadLetterDir); len(entries) != 2 {
+		t.Fatalf("dead-letter has %d file(s), want payload and sidecar", len(entries))
+	}
+}
+
+func TestRejectingHookDeadLetters(t *testing.T) {
+	cfg := deadLetterConfig(t)
+	s := openTestSpool(t, cfg)
+	old := processRequestHook
+	processRequestHook = func([]byte) ([]byte, error) {
+		return nil, errors.New("HandleRequest rejected the payload (rc=1)")
+	}
+	defer func() { processRequestHook = old }()
+
+	pipeline := NewSendPipeline(cfg)
+	w := NewDataWorker(cfg, 1, pipeline, nil)
+	conn, frames := wsPair(t)
+	if err := Enqueue(SendItem{Payload: []byte("rejected payload")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// o hook recusa toda vez: nada vai para o socket e o orçamento se esgota
+	for attempt := 1; attempt <= cfg.Tuning.MaxAttempts; attempt++ {
+		it := nextItem(t, s)
+		if it.Attempts != attempt-1 {
+			t.Fatalf("attempt %d: item has %d previous attempt(s)", attempt, it.Attempts)
+		}
+		if err := pipeline.Send(context.Background(), w, conn, it); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	expectNoItem(t, s)
+	select {
+	case f := <-frames:
+		t.Fatalf("rejected payload written to the socket: %q", f)
+	default:
+	}
+
+	sidecars, _ := filepath.Glob(filepath.Join(cfg.General.DeadLetterDir, "*.json"))
+	if len(sidecars) != 1 {
+		t.Fatalf("dead-letter has %d sidecar(s), want 1", len(sidecars))
+	}
+	if st := s.Stats(); st.Ready+st.InFlight != 0 {
+		t.Fatalf("spool still holds the item: %+v", st)
+	}
+}
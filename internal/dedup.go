@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrDuplicate indica que o mesmo conteúdo já entrou no spool dentro da janela
+// de dedup; o produtor pode descartar a cópia.
+var ErrDuplicate = errors.New("duplicate payload")
+
+// dedupWindow lembra o SHA-256 do que entrou no spool nos últimos window. O
+// estado é um LRU limitado a max entradas, então a memória fica estável.
+type dedupWindow struct {
+	mu     sync.Mutex
+	window time.Duration
+	max    int
+	ll     *list.List // frente = mais recente
+	idx    map[[sha256.Size]byte]*list.Element
+}
+
+type dedupEntry struct {
+	sum  [sha256.Size]byte
+	seen time.Time
+}
+
+func newDedupWindow(window time.Duration, max int) *dedupWindow {
+	return &dedupWindow{
+		window: window,
+		max:    max,
+		ll:     list.New(),
+		idx:    map[[sha256.Size]byte]*list.Element{},
+	}
+}
+
+// sendDedup é a janela usada por Enqueue; SetDedupWindow aplica a config.
+var sendDedup = newDedupWindow(time.Minute, 10000)
+
+// SetDedupWindow aplica tuning.dedup_window_ms e tuning.dedup_max_entries
+// (janela 0 desliga o dedup).
+func SetDedupWindow(cfg Config) {
+	sendDedup = newDedupWindow(time.Duration(cfg.Tuning.DedupWindowMs)*time.Millisecond, cfg.Tuning.DedupMaxEntries)
+}
+
+// dedupKey é o hash do conteúdo de items (um arquivo com vários registros
+// conta como uma unidade).
+func dedupKey(items []SendItem) [sha256.Size]byte {
+	if len(items) == 1 {
+		return sha256.Sum256(items[0].Payload)
+	}
+	h := sha256.New()
+	var n [4]byte
+	for _, it := range items {
+		binary.BigEndian.PutUint32(n[:], uint32(len(it.Payload)))
+		h.Write(n[:])
+		h.Write(it.Payload)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// admit registra sum e informa se é uma cópia vista dentro da janela.
+func (d *dedupWindow) admit(sum [sha256.Size]byte) (duplicate bool) {
+	if d.window <= 0 {
+		return false
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.evictExpired(now)
+	if e, ok := d.idx[sum]; ok {
+		d.ll.MoveToFront(e)
+		entry := e.Value.(*dedupEntry)
+		if now.Sub(entry.seen) < d.window {
+			return true
+		}
+		entry.seen = now
+		return false
+	}
+	d.idx[sum] = d.ll.PushFront(&dedupEntry{sum: sum, seen: now})
+	for d.max > 0 && d.ll.Len() > d.max {
+		d.removeLocked(d.ll.Back())
+	}
+	return false
+}
+
+// forget desfaz admit (ex: o spool recusou o item e ele vai voltar).
+func (d *dedupWindow) forget(sum [sha256.Size]byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.idx[sum]; ok {
+		d.removeLocked(e)
+	}
+}
+
+// evictExpired tira do fim do LRU as entradas mais velhas que a janela. Uma
+// entrada promovida por cópia não renova seen, então pode haver expiradas no
+// meio; elas saem quando chegam ao fim ou renovam quando aparecem de novo.
+func (d *dedupWindow) evictExpired(now time.Time) {
+	for e := d.ll.Back(); e != nil; e = d.ll.Back() {
+		if now.Sub(e.Value.(*dedupEntry).seen) < d.window {
+			return
+		}
+		d.removeLocked(e)
+	}
+}
+
+func (d *dedupWindow) removeLocked(e *list.Element) {
+	delete(d.idx, e.Value.(*dedupEntry).sum)
+	d.ll.Remove(e)
+}
+
+// Len retorna quantos hashes estão guardados.
+func (d *dedupWindow) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ll.Len()
+}
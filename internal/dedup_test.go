@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDedupWindowAdmit(t *testing.T) {
+	d := newDedupWindow(50*time.Millisecond, 10)
+	a, b := sha256.Sum256([]byte("a")), sha256.Sum256([]byte("b"))
+
+	if d.admit(a) {
+		t.Fatal("first copy reported as duplicate")
+	}
+	if !d.admit(a) {
+		t.Fatal("copy inside the window accepted")
+	}
+	if d.admit(b) {
+		t.Fatal("different content reported as duplicate")
+	}
+
+	// depois da janela o mesmo conteúdo volta a entrar
+	time.Sleep(60 * time.Millisecond)
+	if d.admit(a) {
+		t.Fatal("copy after the window rejected")
+	}
+	if !d.admit(a) {
+		t.Fatal("copy right after re-admission accepted")
+	}
+
+	// janela 0 desliga o dedup
+	off := newDedupWindow(0, 10)
+	if off.admit(a) || off.admit(a) {
+		t.Fatal("dedup with a zero window rejected a copy")
+	}
+}
+
+func TestDedupWindowEvictsOldestOverMax(t *testing.T) {
+	d := newDedupWindow(time.Minute, 3)
+	sums := make([][sha256.Size]byte, 4)
+	for i := range sums {
+		sums[i] = sha256.Sum256([]byte{byte(i)})
+		d.admit(sums[i])
+	}
+	if d.Len() != 3 {
+		t.Fatalf("%d entries, want max 3", d.Len())
+	}
+	// o mais antigo saiu do LRU; os outros continuam guardados
+	if _, ok := d.idx[sums[0]]; ok {
+		t.Fatal("oldest entry not evicted")
+	}
+	for i, sum := range sums[1:] {
+		if _, ok := d.idx[sum]; !ok {
+			t.Fatalf("entry %d evicted before the oldest", i+1)
+		}
+	}
+	if d.admit(sums[0]) {
+		t.Fatal("evicted entry still reported as duplicate")
+	}
+	// uma cópia promove a entrada: quem sai depois é a menos recente
+	if !d.admit(sums[2]) {
+		t.Fatal("recent entry not reported as duplicate")
+	}
+	d.admit(sha256.Sum256([]byte("new")))
+	if _, ok := d.idx[sums[3]]; ok {
+		t.Fatal("least recently used entry not evicted")
+	}
+	if _, ok := d.idx[sums[2]]; !ok {
+		t.Fatal("promoted entry evicted")
+	}
+}
+
+func TestDedupWindowForget(t *testing.T) {
+	d := newDedupWindow(time.Minute, 10)
+	sum := sha256.Sum256([]byte("payload"))
+	d.admit(sum)
+	d.forget(sum)
+	if d.Len() != 0 {
+		t.Fatalf("%d entries after forget", d.Len())
+	}
+	if d.admit(sum) {
+		t.Fatal("forgotten content reported as duplicate")
+	}
+	// forget de algo desconhecido não faz nada
+	d.forget(sha256.Sum256([]byte("other")))
+	if d.Len() != 1 {
+		t.Fatalf("%d entries, want 1", d.Len())
+	}
+}
+
+func TestEnqueueAllCountsDuplicatesByOrigin(t *testing.T) {
+	cfg := testConfig(t)
+	openTestSpool(t, cfg)
+
+	cases := []struct {
+		origin, metric string
+	}{
+		{OriginScanner, "dedup.scanner"},
+		{OriginTCP, "dedup.tcp"},
+		{"", "dedup.unknown"},
+	}
+	for _, tc := range cases {
+		payload := []byte("same content from " + tc.metric)
+		total, byOrigin := metrics.Get("dedup.duplicates"), metrics.Get(tc.metric)
+		if err := Enqueue(SendItem{Payload: payload, Origin: tc.origin}); err != nil {
+			t.Fatalf("%s: first copy: %v", tc.metric, err)
+		}
+		if err := Enqueue(SendItem{Payload: payload, Origin: tc.origin}); !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("%s: second copy: %v, want ErrDuplicate", tc.metric, err)
+		}
+		if metrics.Get("dedup.duplicates") != total+1 || metrics.Get(tc.metric) != byOrigin+1 {
+			t.Fatalf("%s: counters not incremented once", tc.metric)
+		}
+	}
+}
+
+func TestEnqueueAllForgetsFailedPut(t *testing.T) {
+	cfg := testConfig(t)
+	s := openTestSpool(t, cfg)
+	item := SendItem{Payload: []byte("payload the spool refused")}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Enqueue(item); !errors.Is(err, ErrSpoolClosed) {
+		t.Fatalf("Enqueue on a closed spool: %v, want ErrSpoolClosed", err)
+	}
+	if sendDedup.Len() != 0 {
+		t.Fatal("refused payload kept in the dedup window")
+	}
+
+	// o produtor tenta de novo e o conteúdo não é tomado por cópia
+	openTestSpool(t, cfg)
+	if err := Enqueue(item); err != nil {
+		t.Fatalf("retry after a failed put: %v", err)
+	}
+}
+
+func TestReleasedScanFileIsEnqueuedAgain(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.General.StableMs = 0
+	s := openTestSpool(t, cfg)
+	sc := newDirScanner(cfg, ScanSource{Dir: cfg.General.ScanDir, MinSize: 16})
+
+	payload := rpcRequestPayload(t, 1, []byte("never delivered"))
+	path := filepath.Join(cfg.General.ScanDir, "capture.bin")
+	if err := os.WriteFile(path, payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	offer(t, sc, path)
+	it := nextItem(t, s)
+
+	// o envio falhou: a releitura do arquivo não pode cair no dedup, senão o
+	// scanner apagaria um arquivo que nunca foi entregue
+	duplicates := metrics.Get("dedup.scanner")
+	releaseItem(it, 0)
+	offer(t, sc, path)
+	if metrics.Get("dedup.scanner") != duplicates {
+		t.Fatal("released file counted as a duplicate")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("released file removed as a duplicate: %v", err)
+	}
+	if again := nextItem(t, s); again.Path != path || string(again.Payload) != string(payload) {
+		t.Fatalf("re-read item %q from %q", again.Payload, again.Path)
+	}
+
+	// a janela continua valendo para uma cópia de verdade
+	copyPath := filepath.Join(cfg.General.ScanDir, "copy.bin")
+	if err := os.WriteFile(copyPath, payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	offer(t, sc, copyPath)
+	if metrics.Get("dedup.scanner") != duplicates+1 {
+		t.Fatal("copy of an enqueued file not counted as a duplicate")
+	}
+	if _, err := os.Stat(copyPath); !os.IsNotExist(err) {
+		t.Fatalf("duplicate file kept: %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package internal
 import (
 	"fmt"
 	"path/filepath"
+	"sync"
 
 	"google.golang.org/protobuf/proto"
 	rotompb "rotomworker/proto_gen"
@@ -14,85 +15,270 @@ type ReqHandlerFn func(req *rotompb.MitmRequest, resp *rotompb.MitmResponse)
 // RespHandlerFn manipula uma MitmResponse recebida
 type RespHandlerFn func(resp *rotompb.MitmResponse)
 
+// RpcHandlerFn manipula um SingleRpcRequest de um método RPC. Pode reescrever
+// single.Payload e devolver a própria SingleRpcResponse (nil = sem resposta).
+type RpcHandlerFn func(req *rotompb.MitmRequest, single *rotompb.MitmRequest_RpcRequest_SingleRpcRequest) *rotompb.MitmResponse_RpcResponse_SingleRpcResponse
+
+// RequestDispatcher trata um MitmRequest já decodificado (e descomprimido).
+// resp nil = sem resposta a enviar.
+type RequestDispatcher func(req *rotompb.MitmRequest) (resp *rotompb.MitmResponse, handled bool, err error)
+
+// ResponseDispatcher trata uma MitmResponse já decodificada.
+type ResponseDispatcher func(resp *rotompb.MitmResponse) (handled bool, err error)
+
+// RequestMiddleware envolve o dispatch de requests, como um middleware de
+// net/http: pode logar, medir, validar, reescrever req antes de next ou a
+// resposta depois dele, ou não chamar next.
+type RequestMiddleware func(next RequestDispatcher) RequestDispatcher
+
+// ResponseMiddleware envolve o dispatch de responses.
+type ResponseMiddleware func(next ResponseDispatcher) ResponseDispatcher
+
+// Os registros aceitam vários handlers por chave, chamados na ordem de
+// registro. Registro e dispatch podem acontecer em goroutines diferentes.
 var (
-	requestHandlers  = map[string]ReqHandlerFn{}
-	responseHandlers = map[string]RespHandlerFn{}
+	handlersMu         sync.RWMutex
+	requestHandlers    = map[string][]ReqHandlerFn{}
+	responseHandlers   = map[string][]RespHandlerFn{}
+	rpcHandlers        = map[int32][]RpcHandlerFn{}
+	requestMiddleware  []RequestMiddleware
+	responseMiddleware []ResponseMiddleware
 )
 
-// RegisterRequestHandler registra um handler de request por nome (ex: "LOGIN", "RPC_REQUEST")
+// RegisterRequestHandler registra um handler de request por nome (ex: "LOGIN",
+// "RPC_REQUEST"). Todos os handlers do nome preenchem a mesma MitmResponse.
 func RegisterRequestHandler(name string, h ReqHandlerFn) {
-	requestHandlers[name] = h
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	requestHandlers[name] = append(requestHandlers[name], h)
+}
+
+// RegisterRpcHandler registra um handler por método RPC interno
+// (SingleRpcRequest.method). Cada handler vê o payload já reescrito pelos
+// anteriores; vale a última SingleRpcResponse não nil.
+func RegisterRpcHandler(method int32, h RpcHandlerFn) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	rpcHandlers[method] = append(rpcHandlers[method], h)
 }
 
 // RegisterResponseHandler registra um handler de response por chave (ex: status code)
 func RegisterResponseHandler(name string, h RespHandlerFn) {
-	responseHandlers[name] = h
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	responseHandlers[name] = append(responseHandlers[name], h)
+}
+
+// UseRequestMiddleware adiciona middleware ao dispatch de requests. O primeiro
+// registrado é o mais externo.
+func UseRequestMiddleware(mw ...RequestMiddleware) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	requestMiddleware = append(requestMiddleware, mw...)
+}
+
+// UseResponseMiddleware adiciona middleware ao dispatch de responses.
+func UseResponseMiddleware(mw ...ResponseMiddleware) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	responseMiddleware = append(responseMiddleware, mw...)
+}
+
+// requestChain monta dispatchRequest envolto pela middleware atual.
+func requestChain() RequestDispatcher {
+	handlersMu.RLock()
+	mws := requestMiddleware
+	handlersMu.RUnlock()
+	d := RequestDispatcher(dispatchRequest)
+	for i := len(mws) - 1; i >= 0; i-- {
+		d = mws[i](d)
+	}
+	return d
 }
 
-// DispatchMitmRequest decodifica MitmRequest e chama handler apropriado.
+// responseChain monta dispatchResponse envolto pela middleware atual.
+func responseChain() ResponseDispatcher {
+	handlersMu.RLock()
+	mws := responseMiddleware
+	handlersMu.RUnlock()
+	d := ResponseDispatcher(dispatchResponse)
+	for i := len(mws) - 1; i >= 0; i-- {
+		d = mws[i](d)
+	}
+	return d
+}
+
+// DispatchMitmRequest decodifica MitmRequest e o passa pela middleware até os
+// handlers. Os handlers sempre recebem payloads RPC descomprimidos; se o peer
+// mandou algo comprimido, a resposta volta comprimida com o mesmo codec.
 func DispatchMitmRequest(raw []byte) (handled bool, respBytes []byte, err error) {
 	var req rotompb.MitmRequest
 	if err := proto.Unmarshal(raw, &req); err != nil {
 		return false, nil, fmt.Errorf("failed to unmarshal MitmRequest: %w", err)
 	}
+	peerCodec, err := decompressRpcRequest(&req)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to decompress MitmRequest: %w", err)
+	}
+
+	resp, handled, err := requestChain()(&req)
+	if err != nil || resp == nil {
+		return handled, nil, err
+	}
+	// a resposta sempre leva o id do request
+	resp.Id = req.GetId()
+	if peerCodec != nil {
+		minSize := 0
+		if p := ActiveCompression(); p != nil {
+			minSize = p.MinSize
+		}
+		if cerr := compressRpcResponse(resp, peerCodec, minSize); cerr != nil {
+			return true, nil, fmt.Errorf("failed to compress MitmResponse: %w", cerr)
+		}
+	}
+	out, perr := proto.Marshal(resp)
+	if perr != nil {
+		return true, nil, fmt.Errorf("failed to marshal MitmResponse: %w", perr)
+	}
+	return true, out, nil
+}
 
-	methodName := "UNSET"
+// requestMethodName é a chave de requestHandlers para req.
+func requestMethodName(req *rotompb.MitmRequest) string {
 	switch req.GetMethod() {
 	case rotompb.MitmRequest_LOGIN:
-		methodName = "LOGIN"
+		return "LOGIN"
 	case rotompb.MitmRequest_RPC_REQUEST:
-		methodName = "RPC_REQUEST"
-	default:
-		methodName = fmt.Sprintf("METHOD_%d", int(req.GetMethod()))
+		return "RPC_REQUEST"
 	}
+	return fmt.Sprintf("METHOD_%d", int(req.GetMethod()))
+}
 
-	if h, ok := requestHandlers[methodName]; ok {
-		var resp rotompb.MitmResponse
-		h(&req, &resp)
+// dispatchRequest é o fim da cadeia: chama os handlers registrados para req.
+func dispatchRequest(req *rotompb.MitmRequest) (*rotompb.MitmResponse, bool, error) {
+	methodName := requestMethodName(req)
+	handlersMu.RLock()
+	hs := requestHandlers[methodName]
+	handlersMu.RUnlock()
 
-		if resp.GetStatus() != rotompb.MitmResponse_UNSET {
-			out, perr := proto.Marshal(&resp)
-			if perr != nil {
-				return true, nil, fmt.Errorf("failed to marshal MitmResponse: %w", perr)
-			}
-			return true, out, nil
+	perRpc := methodName == "RPC_REQUEST" && hasRpcHandler(req)
+	if len(hs) == 0 && !perRpc {
+		return nil, false, nil
+	}
+	var resp rotompb.MitmResponse
+	if perRpc {
+		dispatchRpcRequest(req, &resp, hs)
+	} else {
+		for _, h := range hs {
+			h(req, &resp)
+		}
+	}
+	if resp.GetStatus() == rotompb.MitmResponse_UNSET {
+		return nil, true, nil
+	}
+	return &resp, true, nil
+}
+
+// rpcHandlersFor devolve os handlers do método RPC interno method.
+func rpcHandlersFor(method int32) []RpcHandlerFn {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	return rpcHandlers[method]
+}
+
+// hasRpcHandler informa se algum SingleRpcRequest de req tem handler próprio.
+func hasRpcHandler(req *rotompb.MitmRequest) bool {
+	for _, single := range req.GetRpcRequest().GetRequest() {
+		if len(rpcHandlersFor(single.GetMethod())) > 0 {
+			return true
 		}
-		return true, nil, nil
 	}
+	return false
+}
 
-	// fallback para RPC_REQUEST (inspeciona internamente, se desejar)
-	if methodName == "RPC_REQUEST" {
-		if rr := req.GetRpcRequest(); rr != nil {
-			for _, single := range rr.GetRequest() {
-				_ = single.GetMethod()
+// dispatchRpcRequest chama os handlers de cada SingleRpcRequest, em ordem, e
+// depois os handlers "RPC_REQUEST" (top) já com os payloads reescritos. A
+// RpcResponse final tem uma entrada por request, na mesma ordem: a dos
+// handlers do método, senão a de mesma posição de top, senão uma resposta
+// vazia.
+func dispatchRpcRequest(req *rotompb.MitmRequest, resp *rotompb.MitmResponse, top []ReqHandlerFn) {
+	singles := req.GetRpcRequest().GetRequest()
+	own := make([]*rotompb.MitmResponse_RpcResponse_SingleRpcResponse, len(singles))
+	for i, single := range singles {
+		for _, h := range rpcHandlersFor(single.GetMethod()) {
+			if r := h(req, single); r != nil {
+				own[i] = r
 			}
 		}
 	}
+	for _, h := range top {
+		h(req, resp)
+	}
+	if resp.GetStatus() == rotompb.MitmResponse_UNSET {
+		resp.Status = rotompb.MitmResponse_SUCCESS
+	}
 
-	return false, nil, nil
+	rr := resp.GetRpcResponse()
+	if rr == nil {
+		rr = &rotompb.MitmResponse_RpcResponse{RpcStatus: rotompb.RpcStatus_RPC_STATUS_SUCCESS}
+	}
+	fallback := rr.GetResponse()
+	combined := make([]*rotompb.MitmResponse_RpcResponse_SingleRpcResponse, len(singles))
+	for i, single := range singles {
+		r := own[i]
+		if r == nil && i < len(fallback) {
+			r = fallback[i]
+		}
+		if r == nil {
+			r = &rotompb.MitmResponse_RpcResponse_SingleRpcResponse{}
+		}
+		if r.Method == 0 {
+			r.Method = single.GetMethod()
+		}
+		combined[i] = r
+	}
+	rr.Response = combined
+	resp.Payload = &rotompb.MitmResponse_RpcResponse_{RpcResponse: rr}
 }
 
-// DispatchMitmResponse decodifica MitmResponse e chama response handlers.
+// DispatchMitmResponse decodifica MitmResponse (descomprimindo os payloads
+// RPC) e a passa pela middleware até os response handlers.
 func DispatchMitmResponse(raw []byte) (handled bool, err error) {
 	var resp rotompb.MitmResponse
 	if err := proto.Unmarshal(raw, &resp); err != nil {
 		return false, fmt.Errorf("failed to unmarshal MitmResponse: %w", err)
 	}
-
-	key := fmt.Sprintf("%d", int(resp.GetStatus()))
-	if h, ok := responseHandlers[key]; ok {
-		h(&resp)
-		return true, nil
+	if _, err := decompressRpcResponse(&resp); err != nil {
+		return false, fmt.Errorf("failed to decompress MitmResponse: %w", err)
 	}
+	return responseChain()(&resp)
+}
 
-	if rr := resp.GetRpcResponse(); rr != nil {
-		_ = rr
+// dispatchResponse é o fim da cadeia: chama os handlers do status de resp.
+func dispatchResponse(resp *rotompb.MitmResponse) (bool, error) {
+	key := fmt.Sprintf("%d", int(resp.GetStatus()))
+	handlersMu.RLock()
+	hs := responseHandlers[key]
+	handlersMu.RUnlock()
+	for _, h := range hs {
+		h(resp)
 	}
-	return false, nil
+	return len(hs) > 0, nil
 }
 
-// RegisterDefaultHandlers registra handlers padrão no estilo Cosmog.
+var defaultHandlersOnce sync.Once
+
+// RegisterDefaultHandlers registra handlers padrão no estilo Cosmog e a
+// middleware padrão (recover, log, tempo, validação). Só a primeira chamada
+// tem efeito, já que os registros acumulam handlers.
 func RegisterDefaultHandlers() {
+	defaultHandlersOnce.Do(registerDefaultHandlers)
+}
+
+func registerDefaultHandlers() {
+	UseRequestMiddleware(RecoverRequests, LogRequests, TimeRequests, ValidateRequests)
+	UseResponseMiddleware(RecoverResponses, LogResponses)
+
 	RegisterRequestHandler("LOGIN", func(req *rotompb.MitmRequest, resp *rotompb.MitmResponse) {
 		resp.Status = rotompb.MitmResponse_SUCCESS
 
@@ -101,7 +287,8 @@ func RegisterDefaultHandlers() {
 			lr.WorkerId = loginReq.GetWorkerId()
 		}
 		lr.Status = rotompb.AuthStatus_AUTH_STATUS_GOT_AUTH_TOKEN
-		lr.SupportsCompression = false
+		// só anuncia compressão se houver um codec configurado
+		lr.SupportsCompression = ActiveCompression().Enabled()
 		lr.Useragent = "rotom-worker-go/1.0"
 
 		resp.Payload = &rotompb.MitmResponse_LoginResponse_{
@@ -120,11 +307,6 @@ func RegisterDefaultHandlers() {
 	})
 }
 
-// DecodePogoPayload tenta interpretar payloads RPC usando POGOProtos.
-func DecodePogoPayload(raw []byte) (string, bool) {
-	return "", false
-}
-
 func basename(p string) string {
 	return filepath.Base(p)
 }
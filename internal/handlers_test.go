@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"testing"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+// isolateHandlers troca os registros de handlers e middleware por registros
+// vazios durante o teste.
+func isolateHandlers(t *testing.T) {
+	t.Helper()
+	handlersMu.Lock()
+	req, resp, rpc := requestHandlers, responseHandlers, rpcHandlers
+	reqMw, respMw := requestMiddleware, responseMiddleware
+	requestHandlers = map[string][]ReqHandlerFn{}
+	responseHandlers = map[string][]RespHandlerFn{}
+	rpcHandlers = map[int32][]RpcHandlerFn{}
+	requestMiddleware, responseMiddleware = nil, nil
+	handlersMu.Unlock()
+	t.Cleanup(func() {
+		handlersMu.Lock()
+		requestHandlers, responseHandlers, rpcHandlers = req, resp, rpc
+		requestMiddleware, responseMiddleware = reqMw, respMw
+		handlersMu.Unlock()
+	})
+}
+
+// rpcRequestFor monta um RPC_REQUEST com um SingleRpcRequest por método, cujo
+// payload é "in<i>".
+func rpcRequestFor(id uint32, methods ...int32) *rotompb.MitmRequest {
+	rr := &rotompb.MitmRequest_RpcRequest{}
+	for i, m := range methods {
+		rr.Request = append(rr.Request, &rotompb.MitmRequest_RpcRequest_SingleRpcRequest{Method: m, Payload: []byte{'i', 'n', byte('0' + i)}})
+	}
+	return &rotompb.MitmRequest{Id: id, Method: rotompb.MitmRequest_RPC_REQUEST, Payload: &rotompb.MitmRequest_RpcRequest_{RpcRequest: rr}}
+}
+
+// replyWith é um RpcHandlerFn que responde payload.
+func replyWith(payload string) RpcHandlerFn {
+	return func(*rotompb.MitmRequest, *rotompb.MitmRequest_RpcRequest_SingleRpcRequest) *rotompb.MitmResponse_RpcResponse_SingleRpcResponse {
+		return &rotompb.MitmResponse_RpcResponse_SingleRpcResponse{Payload: []byte(payload)}
+	}
+}
+
+func TestDispatchRpcRequestKeepsRequestOrder(t *testing.T) {
+	// falha: o handler não produz resposta
+	fails := RpcHandlerFn(func(*rotompb.MitmRequest, *rotompb.MitmRequest_RpcRequest_SingleRpcRequest) *rotompb.MitmResponse_RpcResponse_SingleRpcResponse {
+		return nil
+	})
+	rewrite := RpcHandlerFn(func(_ *rotompb.MitmRequest, single *rotompb.MitmRequest_RpcRequest_SingleRpcRequest) *rotompb.MitmResponse_RpcResponse_SingleRpcResponse {
+		single.Payload = []byte("rewritten")
+		return &rotompb.MitmResponse_RpcResponse_SingleRpcResponse{Payload: []byte("first")}
+	})
+	echo := RpcHandlerFn(func(_ *rotompb.MitmRequest, single *rotompb.MitmRequest_RpcRequest_SingleRpcRequest) *rotompb.MitmResponse_RpcResponse_SingleRpcResponse {
+		return &rotompb.MitmResponse_RpcResponse_SingleRpcResponse{Payload: append([]byte("echo:"), single.GetPayload()...)}
+	})
+	// top responde por posição, como um handler "RPC_REQUEST" genérico
+	top := ReqHandlerFn(func(req *rotompb.MitmRequest, resp *rotompb.MitmResponse) {
+		rr := &rotompb.MitmResponse_RpcResponse{RpcStatus: rotompb.RpcStatus_RPC_STATUS_SUCCESS}
+		for i := range req.GetRpcRequest().GetRequest() {
+			rr.Response = append(rr.Response, &rotompb.MitmResponse_RpcResponse_SingleRpcResponse{Payload: []byte{'t', 'o', 'p', byte('0' + i)}})
+		}
+		resp.Status = rotompb.MitmResponse_SUCCESS
+		resp.Payload = &rotompb.MitmResponse_RpcResponse_{RpcResponse: rr}
+	})
+
+	cases := []struct {
+		name     string
+		methods  []int32
+		handlers map[int32][]RpcHandlerFn
+		top      bool
+		want     []string
+	}{
+		{
+			name:     "one handler between methods without handlers",
+			methods:  []int32{10, 20, 30},
+			handlers: map[int32][]RpcHandlerFn{20: {replyWith("r20")}},
+			want:     []string{"", "r20", ""},
+		},
+		{
+			name:     "several handlers for one method",
+			methods:  []int32{10, 20},
+			handlers: map[int32][]RpcHandlerFn{10: {rewrite, echo}},
+			want:     []string{"echo:rewritten", ""},
+		},
+		{
+			name:     "failing handler keeps its slot",
+			methods:  []int32{10, 20, 30},
+			handlers: map[int32][]RpcHandlerFn{10: {replyWith("r10")}, 20: {fails}, 30: {replyWith("r30")}},
+			want:     []string{"r10", "", "r30"},
+		},
+		{
+			name:     "failing handler after a good one",
+			methods:  []int32{10, 20},
+			handlers: map[int32][]RpcHandlerFn{10: {replyWith("r10"), fails}, 20: {replyWith("r20")}},
+			want:     []string{"r10", "r20"},
+		},
+		{
+			name:     "same method twice",
+			methods:  []int32{10, 20, 10},
+			handlers: map[int32][]RpcHandlerFn{10: {echo}},
+			want:     []string{"echo:in0", "", "echo:in2"},
+		},
+		{
+			name:     "top fills the gaps by position",
+			methods:  []int32{10, 20, 30},
+			handlers: map[int32][]RpcHandlerFn{20: {replyWith("r20")}, 30: {fails}},
+			top:      true,
+			want:     []string{"top0", "r20", "top2"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			isolateHandlers(t)
+			for m, hs := range tc.handlers {
+				for _, h := range hs {
+					RegisterRpcHandler(m, h)
+				}
+			}
+			if tc.top {
+				RegisterRequestHandler("RPC_REQUEST", top)
+			}
+
+			resp, handled, err := dispatchRequest(rpcRequestFor(7, tc.methods...))
+			if err != nil || !handled || resp == nil {
+				t.Fatalf("dispatchRequest: resp=%v handled=%v err=%v", resp, handled, err)
+			}
+			if resp.GetStatus() != rotompb.MitmResponse_SUCCESS {
+				t.Fatalf("status %s, want SUCCESS", resp.GetStatus())
+			}
+			got := resp.GetRpcResponse().GetResponse()
+			if len(got) != len(tc.methods) {
+				t.Fatalf("%d response(s) for %d request(s)", len(got), len(tc.methods))
+			}
+			for i, r := range got {
+				if r.GetMethod() != tc.methods[i] {
+					t.Fatalf("response %d has method %d, want %d", i, r.GetMethod(), tc.methods[i])
+				}
+				if string(r.GetPayload()) != tc.want[i] {
+					t.Fatalf("response %d payload %q, want %q", i, r.GetPayload(), tc.want[i])
+				}
+			}
+		})
+	}
+}
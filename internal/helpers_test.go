@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// testConfig devolve a config padrão com spool, scan e quarentena em
+// diretórios temporários do teste.
+func testConfig(t *testing.T) Config {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Spool.Dir = t.TempDir()
+	cfg.Spool.Fsync = "never"
+	cfg.General.ScanDir = t.TempDir()
+	cfg.General.QuarantineDir = t.TempDir()
+	cfg.General.DeadLetterDir = t.TempDir()
+	cfg.sanitize()
+	return cfg
+}
+
+// openTestSpool abre o spool global de envio para o teste e o fecha no fim,
+// zerando os registros globais que dependem dele.
+func openTestSpool(t *testing.T, cfg Config) *Spool {
+	t.Helper()
+	s, err := OpenSendSpool(cfg)
+	if err != nil {
+		t.Fatalf("OpenSendSpool: %v", err)
+	}
+	SetRetryPolicy(cfg)
+	t.Cleanup(func() {
+		_ = s.Close()
+		sendSpool = nil
+		scanClaims = NewClaimSet()
+	})
+	return s
+}
+
+// wsPair abre um websocket contra um servidor local e devolve a ponta do
+// cliente e os frames binários que o servidor recebe.
+func wsPair(t *testing.T) (*websocket.Conn, <-chan []byte) {
+	t.Helper()
+	frames := make(chan []byte, 16)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			frames <- msg
+		}
+	}))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, frames
+}
@@ -46,6 +46,9 @@ type ElfHook struct {
 // Lista de hooks carregados
 var activeElfHooks []*ElfHook
 
+// ErrNoRequestHook indica que nenhuma lib carregada implementa HandleRequest.
+var ErrNoRequestHook = errors.New("no HandleRequest active")
+
 // LoadElfHooks carrega as bibliotecas ELF e resolve os símbolos principais
 func LoadElfHooks(paths []string) error {
 	for _, path := range paths {
@@ -93,15 +96,20 @@ func LoadElfHooks(paths []string) error {
 	return nil
 }
 
-// TryProcessRequest envia um buffer para HandleRequest se a lib implementar
+// TryProcessRequest envia um buffer para HandleRequest se a lib implementar.
+// Sem nenhum HandleRequest devolve ErrNoRequestHook; se todos recusarem o
+// buffer (rc != 0), devolve o rc do último.
 func TryProcessRequest(buf []byte) ([]byte, error) {
+	var rc C.int
+	found := false
 	for _, h := range activeElfHooks {
 		if h.handleRequest != nil {
 			var out *C.uchar
 			var outLen C.size_t
 
 			fn := (C.handle_fn)(h.handleRequest)
-			rc := C.call_handle_fn(fn,
+			found = true
+			rc = C.call_handle_fn(fn,
 				(*C.uchar)(unsafe.Pointer(&buf[0])),
 				C.size_t(len(buf)),
 				&out,
@@ -112,9 +120,16 @@ func TryProcessRequest(buf []byte) ([]byte, error) {
 				goBuf := C.GoBytes(unsafe.Pointer(out), C.int(outLen))
 				return goBuf, nil
 			}
+			if rc == 0 {
+				// aceito sem reescrever
+				return nil, nil
+			}
 		}
 	}
-	return nil, errors.New("no HandleRequest active")
+	if !found {
+		return nil, ErrNoRequestHook
+	}
+	return nil, fmt.Errorf("HandleRequest rejected the payload (rc=%d)", int(rc))
 }
 
 // TryProcessResponse envia um buffer para HandleResponse se a lib implementar
@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+	rotompb "rotomworker/proto_gen"
+)
+
+// Ordem entre hooks C e handlers Go (rotom.dispatch_order).
+const (
+	DispatchHooksFirst    = "hooks_first"
+	DispatchHandlersFirst = "handlers_first"
+)
+
+// InboundJob carrega um frame recebido em /data pelos estágios de entrada.
+type InboundJob struct {
+	MessageType int
+	Msg         []byte
+	// Request/Response são o frame já decodificado (no máximo um deles).
+	Request  *rotompb.MitmRequest
+	Response *rotompb.MitmResponse
+
+	worker *DataWorker
+	conn   *websocket.Conn
+}
+
+// InboundStage é um estágio plugável da entrada. handled=true encerra a cadeia;
+// um erro é logado e o frame segue para o próximo estágio.
+type InboundStage interface {
+	Name() string
+	Handle(job *InboundJob) (handled bool, err error)
+}
+
+// inboundStageFunc adapta uma função simples para InboundStage.
+type inboundStageFunc struct {
+	name string
+	fn   func(job *InboundJob) (bool, error)
+}
+
+func (s inboundStageFunc) Name() string                         { return s.name }
+func (s inboundStageFunc) Handle(job *InboundJob) (bool, error) { return s.fn(job) }
+
+// NewInboundStage cria um estágio a partir de uma função.
+func NewInboundStage(name string, fn func(job *InboundJob) (bool, error)) InboundStage {
+	return inboundStageFunc{name: name, fn: fn}
+}
+
+// InboundPipeline trata os frames que nenhum request nosso estava esperando,
+// compartilhado por todos os workers: hooks (C) e dispatch (handlers Go), na
+// ordem de rotom.dispatch_order.
+type InboundPipeline struct {
+	stages []InboundStage
+}
+
+// NewInboundPipeline monta a entrada padrão a partir da configuração. Com
+// pogo.log_payloads, um estágio "inspect" loga os payloads antes de tudo.
+func NewInboundPipeline(cfg Config) *InboundPipeline {
+	p := &InboundPipeline{}
+	if cfg.Pogo.LogPayloads {
+		p.stages = append(p.stages, NewInboundStage("inspect", inspectInboundStage))
+	}
+	hooks := NewInboundStage("hooks", hooksInboundStage)
+	dispatch := NewInboundStage("dispatch", dispatchInboundStage)
+	if cfg.Rotom.DispatchOrder == DispatchHandlersFirst {
+		p.stages = append(p.stages, dispatch, hooks)
+	} else {
+		p.stages = append(p.stages, hooks, dispatch)
+	}
+	return p
+}
+
+// Stages retorna os nomes dos estágios, em ordem.
+func (p *InboundPipeline) Stages() []string {
+	names := make([]string, 0, len(p.stages))
+	for _, s := range p.stages {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+// Insert adiciona stage antes do estágio chamado before (ou no fim, se não existir).
+func (p *InboundPipeline) Insert(before string, stage InboundStage) {
+	for i, s := range p.stages {
+		if s.Name() == before {
+			p.stages = append(p.stages[:i], append([]InboundStage{stage}, p.stages[i:]...)...)
+			return
+		}
+	}
+	p.stages = append(p.stages, stage)
+}
+
+// Handle passa job pelos estágios até um deles tratar o frame.
+func (p *InboundPipeline) Handle(job *InboundJob) bool {
+	w := job.worker
+	for _, s := range p.stages {
+		handled, err := s.Handle(job)
+		if err != nil {
+			w.logger.Warnf("[%s] inbound %s: %v", w.ID, s.Name(), err)
+		}
+		if handled {
+			metrics.Inc("inbound." + s.Name())
+			return true
+		}
+	}
+	return false
+}
+
+// reply escreve out (uma MitmResponse nossa) de volta em job.conn.
+func (job *InboundJob) reply(out []byte) error {
+	w := job.worker
+	if resp, ok := decodeMitmResponse(out); ok {
+		w.session.ObserveResponse(resp)
+	}
+	return w.writeMessage(job.conn, websocket.BinaryMessage, out, 10*time.Second)
+}
+
+// handleRequestHook é a chamada ao HandleRequest usada pelo hooksInboundStage
+// (os testes trocam por um hook falso).
+var handleRequestHook = TryHandleRequest
+
+// hooksInboundStage oferece o frame aos hooks ELF: HandleResponse/ProcessResponse
+// podem produzir uma resposta, HandleRequest pode consumir o frame. Um request
+// consumido pelo HandleRequest ainda conduz a sessão (ObserveHookedRequest).
+func hooksInboundStage(job *InboundJob) (bool, error) {
+	w := job.worker
+	if len(job.Msg) == 0 {
+		return false, nil
+	}
+	if out, err := TryProcessResponse(job.Msg); err == nil && len(out) > 0 {
+		w.logger.Debugf("[%s] HandleResponse produced output; forwarding to data WS", w.ID)
+		return true, job.reply(out)
+	}
+	if handled, out, err := handleRequestHook(job.Msg); err == nil && handled {
+		w.logger.Debugf("[%s] incoming message handled by HandleRequest hook", w.ID)
+		if job.Request != nil {
+			w.session.ObserveHookedRequest(job.Request, out)
+		}
+		return true, nil
+	}
+	if handled, _, err := TryHandleResponse(job.Msg); err == nil && handled {
+		w.logger.Debugf("[%s] incoming message handled by HandleResponse hook", w.ID)
+		return true, nil
+	}
+	return false, nil
+}
+
+// dispatchInboundStage entrega o frame aos handlers Go (handlers.go). A
+// MitmResponse produzida volta pela mesma conexão, com o id do request.
+func dispatchInboundStage(job *InboundJob) (bool, error) {
+	w := job.worker
+	switch {
+	case job.Request != nil:
+		handled, out, err := DispatchMitmRequest(job.Msg)
+		if err != nil {
+			return handled, err
+		}
+		if !handled {
+			return false, nil
+		}
+		if out == nil {
+			w.logger.Debugf("[%s] MitmRequest id=%d handled without response", w.ID, job.Request.GetId())
+			return true, nil
+		}
+		w.logger.Debugf("[%s] MitmRequest id=%d (%s) answered by Go handler", w.ID, job.Request.GetId(), job.Request.GetMethod())
+		if err := job.reply(out); err != nil {
+			return true, fmt.Errorf("write response id=%d: %w", job.Request.GetId(), err)
+		}
+		return true, nil
+	case job.Response != nil:
+		return DispatchMitmResponse(job.Msg)
+	}
+	return false, nil
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+func TestNewInboundPipelineStageOrder(t *testing.T) {
+	cases := []struct {
+		name        string
+		order       string
+		logPayloads bool
+		want        []string
+	}{
+		{"default", "", false, []string{"hooks", "dispatch"}},
+		{"hooks first", DispatchHooksFirst, false, []string{"hooks", "dispatch"}},
+		{"handlers first", DispatchHandlersFirst, false, []string{"dispatch", "hooks"}},
+		{"hooks first with inspect", DispatchHooksFirst, true, []string{"inspect", "hooks", "dispatch"}},
+		{"handlers first with inspect", DispatchHandlersFirst, true, []string{"inspect", "dispatch", "hooks"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.Rotom.DispatchOrder = tc.order
+			cfg.Pogo.LogPayloads = tc.logPayloads
+			if got := NewInboundPipeline(cfg).Stages(); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("stages %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInboundDispatchOrderPicksFirstHandler(t *testing.T) {
+	isolateHandlers(t)
+	RegisterRpcHandler(106, replyWith("from go"))
+	hooked := 0
+	old := handleRequestHook
+	handleRequestHook = func([]byte) (bool, []byte, error) {
+		hooked++
+		return true, nil, nil
+	}
+	defer func() { handleRequestHook = old }()
+
+	req := rpcRequestFor(31, 106)
+	msg, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		order     string
+		wantHook  int
+		wantReply bool
+	}{
+		// hooks_first: o HandleRequest consome o frame e o handler Go não roda
+		{DispatchHooksFirst, 1, false},
+		// handlers_first: o handler Go responde e o hook nem é chamado
+		{DispatchHandlersFirst, 0, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.order, func(t *testing.T) {
+			hooked = 0
+			cfg := testConfig(t)
+			cfg.Rotom.DispatchOrder = tc.order
+			w := NewDataWorker(cfg, 1, nil, nil)
+			conn, frames := wsPair(t)
+
+			p := NewInboundPipeline(cfg)
+			if !p.Handle(&InboundJob{Msg: msg, Request: req, worker: w, conn: conn}) {
+				t.Fatal("frame not handled")
+			}
+			if hooked != tc.wantHook {
+				t.Fatalf("HandleRequest called %d time(s), want %d", hooked, tc.wantHook)
+			}
+			select {
+			case f := <-frames:
+				if !tc.wantReply {
+					t.Fatalf("unexpected reply %q", f)
+				}
+				resp := &rotompb.MitmResponse{}
+				if err := proto.Unmarshal(f, resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.GetId() != req.GetId() {
+					t.Fatalf("reply id=%d, want %d", resp.GetId(), req.GetId())
+				}
+				if got := string(resp.GetRpcResponse().GetResponse()[0].GetPayload()); got != "from go" {
+					t.Fatalf("reply payload %q, want %q", got, "from go")
+				}
+			case <-time.After(300 * time.Millisecond):
+				if tc.wantReply {
+					t.Fatal("Go handler reply not written")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"container/list"
+	"strings"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+// Priority é a faixa (lane) de um item no spool de envio.
+type Priority int
+
+const (
+	// PriorityAuto deixa a faixa ser escolhida pela origem e pelo método.
+	PriorityAuto Priority = iota
+	PriorityHigh
+	PriorityNormal
+	PriorityBulk
+)
+
+// laneCount é o número de faixas reais (sem PriorityAuto).
+const laneCount = 3
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityNormal:
+		return "normal"
+	case PriorityBulk:
+		return "bulk"
+	}
+	return "auto"
+}
+
+// ParsePriority converte "high", "normal" ou "bulk"; o resto vira PriorityAuto.
+func ParsePriority(s string) Priority {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "high":
+		return PriorityHigh
+	case "normal":
+		return PriorityNormal
+	case "bulk":
+		return PriorityBulk
+	}
+	return PriorityAuto
+}
+
+// Origens de um SendItem.
+const (
+	OriginTCP     = "tcp"
+	OriginScanner = "scanner"
+	OriginControl = "control"
+)
+
+// laneFor resolve a faixa de item. Uma prioridade explícita vale; senão um
+// LOGIN é sempre high, e o resto segue a origem: TCP (tráfego ao vivo) é
+// high, o que vem do controle é normal e o cache do scanner é bulk. Um
+// RPC_REQUEST nunca fica abaixo de normal.
+func laneFor(item SendItem) Priority {
+	if item.Priority != PriorityAuto {
+		return item.Priority
+	}
+	p := PriorityNormal
+	switch item.Origin {
+	case OriginTCP:
+		p = PriorityHigh
+	case OriginScanner:
+		p = PriorityBulk
+	}
+	if req, ok := decodeMitmRequest(item.Payload); ok {
+		switch req.GetMethod() {
+		case rotompb.MitmRequest_LOGIN:
+			p = PriorityHigh
+		case rotompb.MitmRequest_RPC_REQUEST:
+			if p == PriorityBulk {
+				p = PriorityNormal
+			}
+		}
+	}
+	return p
+}
+
+// LaneWeights são os pesos do escalonador (spool.lane_weights).
+type LaneWeights struct {
+	High   int `json:"high"`
+	Normal int `json:"normal"`
+	Bulk   int `json:"bulk"`
+}
+
+// laneScheduler escolhe a próxima faixa por round-robin ponderado suave: em
+// cada janela de High+Normal+Bulk escolhas, cada faixa com itens recebe sua
+// parte, então bulk nunca passa fome.
+type laneScheduler struct {
+	weight  [laneCount]int
+	current [laneCount]int
+	lanes   [laneCount]*list.List
+}
+
+func newLaneScheduler(w LaneWeights) *laneScheduler {
+	s := &laneScheduler{weight: [laneCount]int{w.High, w.Normal, w.Bulk}}
+	for i := range s.lanes {
+		if s.weight[i] <= 0 {
+			s.weight[i] = 1
+		}
+		s.lanes[i] = list.New()
+	}
+	return s
+}
+
+func (s *laneScheduler) lane(p Priority) *list.List {
+	if p < PriorityHigh || p > PriorityBulk {
+		p = PriorityNormal
+	}
+	return s.lanes[p-PriorityHigh]
+}
+
+// next retira o primeiro elemento da faixa escolhida (nil se tudo vazio).
+func (s *laneScheduler) next() any {
+	best, total := -1, 0
+	for i, l := range s.lanes {
+		if l.Len() == 0 {
+			continue
+		}
+		s.current[i] += s.weight[i]
+		total += s.weight[i]
+		if best < 0 || s.current[i] > s.current[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	s.current[best] -= total
+	l := s.lanes[best]
+	return l.Remove(l.Front())
+}
+
+// Len soma os itens prontos de todas as faixas.
+func (s *laneScheduler) Len() int {
+	n := 0
+	for _, l := range s.lanes {
+		n += l.Len()
+	}
+	return n
+}
+
+// Depths devolve os itens prontos por faixa.
+func (s *laneScheduler) Depths() map[string]int {
+	out := make(map[string]int, laneCount)
+	for i, l := range s.lanes {
+		out[(PriorityHigh + Priority(i)).String()] = l.Len()
+	}
+	return out
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+// mitmRequestPayload serializa um MitmRequest vazio do método m.
+func mitmRequestPayload(t *testing.T, m rotompb.MitmRequest_Method) []byte {
+	t.Helper()
+	b, err := proto.Marshal(&rotompb.MitmRequest{Id: 1, Method: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// fillLane coloca n elementos "<lane>-<i>" na faixa p.
+func fillLane(s *laneScheduler, p Priority, n int) {
+	for i := 0; i < n; i++ {
+		s.lane(p).PushBack(fmt.Sprintf("%s-%d", p, i))
+	}
+}
+
+// laneOf devolve a faixa de um elemento criado por fillLane.
+func laneOf(v any) string {
+	lane, _, _ := strings.Cut(v.(string), "-")
+	return lane
+}
+
+func TestLaneSchedulerSharesEachWindow(t *testing.T) {
+	w := LaneWeights{High: 8, Normal: 3, Bulk: 1}
+	s := newLaneScheduler(w)
+	const windows = 10
+	for _, p := range []Priority{PriorityHigh, PriorityNormal, PriorityBulk} {
+		fillLane(s, p, 20*windows)
+	}
+
+	// com as três faixas cheias, toda janela de 12 escolhas dá 8/3/1
+	want := map[string]int{"high": w.High, "normal": w.Normal, "bulk": w.Bulk}
+	for win := 0; win < windows; win++ {
+		got := map[string]int{}
+		for i := 0; i < w.High+w.Normal+w.Bulk; i++ {
+			got[laneOf(s.next())]++
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("window %d: %v, want %v", win, got, want)
+		}
+	}
+}
+
+func TestLaneSchedulerServesBulkUnderHighLoad(t *testing.T) {
+	w := LaneWeights{High: 8, Normal: 3, Bulk: 1}
+	s := newLaneScheduler(w)
+	fillLane(s, PriorityHigh, 1)
+	fillLane(s, PriorityBulk, 5)
+
+	// high nunca esvazia: cada escolha high é reposta na hora
+	window := w.High + w.Bulk
+	bulkAt := []int{}
+	for pick := 0; pick < 5*window; pick++ {
+		switch laneOf(s.next()) {
+		case "high":
+			fillLane(s, PriorityHigh, 1)
+		case "bulk":
+			bulkAt = append(bulkAt, pick)
+		}
+	}
+	if len(bulkAt) != 5 {
+		t.Fatalf("bulk served %d of 5 time(s) in %d picks under high load", len(bulkAt), 5*window)
+	}
+	for i, pick := range bulkAt {
+		if pick/window != i {
+			t.Fatalf("bulk item %d served at pick %d, want one per window of %d", i, pick, window)
+		}
+	}
+
+	// uma faixa vazia não consome a parte das outras
+	s = newLaneScheduler(w)
+	fillLane(s, PriorityNormal, 30)
+	fillLane(s, PriorityBulk, 30)
+	got := map[string]int{}
+	for i := 0; i < 4*(w.Normal+w.Bulk); i++ {
+		got[laneOf(s.next())]++
+	}
+	if got["normal"] != 4*w.Normal || got["bulk"] != 4*w.Bulk {
+		t.Fatalf("without high: %v, want normal %d and bulk %d", got, 4*w.Normal, 4*w.Bulk)
+	}
+	if s.next() == nil || newLaneScheduler(w).next() != nil {
+		t.Fatal("next must return nil only when every lane is empty")
+	}
+}
+
+func TestLaneFor(t *testing.T) {
+	login := mitmRequestPayload(t, rotompb.MitmRequest_LOGIN)
+	rpc := mitmRequestPayload(t, rotompb.MitmRequest_RPC_REQUEST)
+	opaque := []byte("opaque capture")
+
+	cases := []struct {
+		name string
+		item SendItem
+		want Priority
+	}{
+		{"tcp opaque", SendItem{Origin: OriginTCP, Payload: opaque}, PriorityHigh},
+		{"control opaque", SendItem{Origin: OriginControl, Payload: opaque}, PriorityNormal},
+		{"scanner opaque", SendItem{Origin: OriginScanner, Payload: opaque}, PriorityBulk},
+		{"no origin", SendItem{Payload: opaque}, PriorityNormal},
+		{"scanner login", SendItem{Origin: OriginScanner, Payload: login}, PriorityHigh},
+		{"control login", SendItem{Origin: OriginControl, Payload: login}, PriorityHigh},
+		{"scanner rpc", SendItem{Origin: OriginScanner, Payload: rpc}, PriorityNormal},
+		{"control rpc", SendItem{Origin: OriginControl, Payload: rpc}, PriorityNormal},
+		{"tcp rpc", SendItem{Origin: OriginTCP, Payload: rpc}, PriorityHigh},
+		{"explicit priority", SendItem{Origin: OriginTCP, Payload: login, Priority: PriorityNormal}, PriorityNormal},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := laneFor(tc.item); got != tc.want {
+				t.Fatalf("lane %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSpoolStatsLaneDepths(t *testing.T) {
+	s, err := OpenSpool(SpoolOptions{Dir: t.TempDir(), Fsync: FsyncNever, LaneWeights: LaneWeights{High: 8, Normal: 3, Bulk: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	items := []SendItem{
+		{Origin: OriginTCP, Payload: []byte("live")},
+		{Origin: OriginScanner, Payload: mitmRequestPayload(t, rotompb.MitmRequest_LOGIN)},
+		{Origin: OriginControl, Payload: []byte("control")},
+		{Origin: OriginScanner, Payload: []byte("cache-1")},
+		{Origin: OriginScanner, Payload: []byte("cache-2")},
+		{Origin: OriginScanner, Payload: []byte("cache-3")},
+	}
+	for _, it := range items {
+		if _, err := s.Put(it); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]int{"high": 2, "normal": 1, "bulk": 3}
+	if got := s.Stats().Lanes; !reflect.DeepEqual(got, want) {
+		t.Fatalf("lanes %v, want %v", got, want)
+	}
+
+	// o que sai em voo deixa de contar na faixa
+	if it := nextItem(t, s); it.Origin != OriginTCP {
+		t.Fatalf("first item from %q, want the high lane", it.Origin)
+	}
+	want["high"] = 1
+	if st := s.Stats(); !reflect.DeepEqual(st.Lanes, want) || st.InFlight != 1 {
+		t.Fatalf("after Next: lanes %v in flight %d, want %v and 1", st.Lanes, st.InFlight, want)
+	}
+}
@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+// earthRadiusM é o raio médio da Terra usado no haversine.
+const earthRadiusM = 6371000.0
+
+// haversineM é a distância em metros entre dois pontos (graus).
+func haversineM(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusM * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// LocationJump descreve um deslocamento acima de location.jump_distance_m
+// dentro de location.jump_window_ms.
+type LocationJump struct {
+	WorkerID         string
+	FromLat, FromLon float64
+	ToLat, ToLon     float64
+	DistanceM        float64
+	Elapsed          time.Duration
+	SpeedKmh         float64
+}
+
+// WorkerLocation guarda a última posição vista nos RpcRequest de um worker e
+// a velocidade implícita desde a posição anterior.
+type WorkerLocation struct {
+	mu        sync.Mutex
+	workerID  string
+	known     bool
+	lat, lon  float64
+	at        time.Time
+	lastMoveM float64
+	speedKmh  float64
+	jumps     uint64
+}
+
+var (
+	locationsMu sync.Mutex
+	locations   = map[string]*WorkerLocation{}
+)
+
+// RegisterLocation cria (ou devolve) a posição de workerID no registro global,
+// que é exposto pelo canal de controle.
+func RegisterLocation(workerID string) *WorkerLocation {
+	locationsMu.Lock()
+	defer locationsMu.Unlock()
+	if l, ok := locations[workerID]; ok {
+		return l
+	}
+	l := &WorkerLocation{workerID: workerID}
+	locations[workerID] = l
+	return l
+}
+
+// Observe registra a posição (lat, lon) vista em at. Devolve o salto se o
+// deslocamento desde a posição anterior passar de maxDistM em menos de window
+// (maxDistM <= 0 desliga o aviso).
+func (l *WorkerLocation) Observe(lat, lon float64, at time.Time, maxDistM float64, window time.Duration) *LocationJump {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.known {
+		l.known, l.lat, l.lon, l.at = true, lat, lon, at
+		return nil
+	}
+	dist := haversineM(l.lat, l.lon, lat, lon)
+	elapsed := at.Sub(l.at)
+	// requests quase simultâneos dariam velocidades absurdas: mede no mínimo 1s
+	speed := dist / math.Max(elapsed.Seconds(), 1) * 3.6
+	var jump *LocationJump
+	if maxDistM > 0 && dist > maxDistM && elapsed < window {
+		l.jumps++
+		jump = &LocationJump{
+			WorkerID:  l.workerID,
+			FromLat:   l.lat,
+			FromLon:   l.lon,
+			ToLat:     lat,
+			ToLon:     lon,
+			DistanceM: dist,
+			Elapsed:   elapsed,
+			SpeedKmh:  speed,
+		}
+	}
+	l.lat, l.lon, l.at = lat, lon, at
+	l.lastMoveM, l.speedKmh = dist, speed
+	return jump
+}
+
+// LocationSnapshot lista a posição conhecida de cada worker, ordenada por worker id.
+func LocationSnapshot() []map[string]any {
+	locationsMu.Lock()
+	list := make([]*WorkerLocation, 0, len(locations))
+	for _, l := range locations {
+		list = append(list, l)
+	}
+	locationsMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].workerID < list[j].workerID })
+
+	out := make([]map[string]any, 0, len(list))
+	for _, l := range list {
+		l.mu.Lock()
+		if l.known {
+			out = append(out, map[string]any{
+				"workerId":  l.workerID,
+				"lat":       l.lat,
+				"lon":       l.lon,
+				"at":        l.at.Unix(),
+				"speedKmh":  math.Round(l.speedKmh*10) / 10,
+				"lastMoveM": math.Round(l.lastMoveM),
+				"jumps":     l.jumps,
+			})
+		}
+		l.mu.Unlock()
+	}
+	return out
+}
+
+// observeLocation registra a posição de um RPC_REQUEST (em qualquer direção).
+// Um salto acima do limite vira aviso no log e evento no canal de controle.
+func (w *DataWorker) observeLocation(req *rotompb.MitmRequest) {
+	rr := req.GetRpcRequest()
+	if rr == nil || (rr.GetLat() == 0 && rr.GetLon() == 0) {
+		return
+	}
+	window := time.Duration(w.cfg.Location.JumpWindowMs) * time.Millisecond
+	jump := w.location.Observe(rr.GetLat(), rr.GetLon(), time.Now(), w.cfg.Location.JumpDistanceM, window)
+	if jump == nil {
+		return
+	}
+	metrics.Inc("location.jumps")
+	w.logger.Warnf("[%s] location jump: %.0fm in %s (%.1f km/h) from %.6f,%.6f to %.6f,%.6f",
+		w.ID, jump.DistanceM, jump.Elapsed.Round(time.Millisecond), jump.SpeedKmh, jump.FromLat, jump.FromLon, jump.ToLat, jump.ToLon)
+	emitControlEvent(map[string]any{
+		"type":      "location_jump",
+		"ts":        time.Now().Unix(),
+		"workerId":  jump.WorkerID,
+		"from":      []float64{jump.FromLat, jump.FromLon},
+		"to":        []float64{jump.ToLat, jump.ToLon},
+		"distanceM": math.Round(jump.DistanceM),
+		"elapsedMs": jump.Elapsed.Milliseconds(),
+		"speedKmh":  math.Round(jump.SpeedKmh*10) / 10,
+	})
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// metersNorth devolve a latitude d metros ao norte de lat.
+func metersNorth(lat, d float64) float64 {
+	return lat + d/earthRadiusM*180/math.Pi
+}
+
+func TestWorkerLocationObserve(t *testing.T) {
+	const (
+		lat, lon = -23.55, -46.63
+		maxDistM = 1000
+		window   = 10 * time.Second
+	)
+	t0 := time.Unix(1700000000, 0)
+
+	cases := []struct {
+		name     string
+		distM    float64 // a partir do ponto anterior
+		after    time.Duration
+		wantJump bool
+		wantKmh  float64
+	}{
+		// 2km em 500ms: salto; a velocidade é medida sobre 1s, não 500ms
+		{"jump under the speed floor", 2000, 500 * time.Millisecond, true, 7200},
+		{"short move", 500, 5 * time.Second, false, 360},
+		{"just under the distance limit", 999, 5 * time.Second, false, 719},
+		{"outside the window", 5000, 10 * time.Second, false, 1800},
+		{"jump inside the window", 5000, 9 * time.Second, true, 2000},
+		{"same instant", 100, 0, false, 360},
+	}
+
+	l := &WorkerLocation{workerID: t.Name()}
+	// o primeiro ponto só fixa a posição
+	if jump := l.Observe(lat, lon, t0, maxDistM, window); jump != nil {
+		t.Fatalf("first point reported a jump: %+v", jump)
+	}
+	curLat, at := lat, t0
+	for _, tc := range cases {
+		nextLat := metersNorth(curLat, tc.distM)
+		at = at.Add(tc.after)
+		jump := l.Observe(nextLat, lon, at, maxDistM, window)
+		if (jump != nil) != tc.wantJump {
+			t.Fatalf("%s: jump=%+v, want jump=%v", tc.name, jump, tc.wantJump)
+		}
+		if math.Abs(l.speedKmh-tc.wantKmh) > 1 {
+			t.Fatalf("%s: speed %.1f km/h, want %.0f", tc.name, l.speedKmh, tc.wantKmh)
+		}
+		if jump != nil {
+			if jump.FromLat != curLat || jump.ToLat != nextLat || jump.Elapsed != tc.after {
+				t.Fatalf("%s: jump %+v", tc.name, jump)
+			}
+			if math.Abs(jump.DistanceM-tc.distM) > 1 {
+				t.Fatalf("%s: distance %.1fm, want %.0f", tc.name, jump.DistanceM, tc.distM)
+			}
+		}
+		curLat = nextLat
+	}
+	if l.jumps != 2 {
+		t.Fatalf("%d jump(s) counted, want 2", l.jumps)
+	}
+
+	// maxDistM <= 0 desliga o aviso, mas a posição continua sendo seguida
+	off := &WorkerLocation{workerID: t.Name()}
+	off.Observe(lat, lon, t0, 0, window)
+	if jump := off.Observe(metersNorth(lat, 50000), lon, t0.Add(time.Second), 0, window); jump != nil {
+		t.Fatalf("jump reported with the check disabled: %+v", jump)
+	}
+	if off.lastMoveM < 49000 {
+		t.Fatalf("last move %.0fm not tracked with the check disabled", off.lastMoveM)
+	}
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// metricSet é um conjunto simples de contadores nomeados (ex: "pending.late").
+// Os valores são expostos pelo comando "status" do canal de controle.
+type metricSet struct {
+	mu       sync.RWMutex
+	counters map[string]*uint64
+}
+
+var metrics = &metricSet{counters: map[string]*uint64{}}
+
+func (m *metricSet) counter(name string) *uint64 {
+	m.mu.RLock()
+	c, ok := m.counters[name]
+	m.mu.RUnlock()
+	if ok {
+		return c
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok = m.counters[name]; !ok {
+		c = new(uint64)
+		m.counters[name] = c
+	}
+	return c
+}
+
+// Inc incrementa o contador name em 1.
+func (m *metricSet) Inc(name string) {
+	atomic.AddUint64(m.counter(name), 1)
+}
+
+// Add soma delta ao contador name.
+func (m *metricSet) Add(name string, delta uint64) {
+	atomic.AddUint64(m.counter(name), delta)
+}
+
+// Get retorna o valor atual do contador name.
+func (m *metricSet) Get(name string) uint64 {
+	return atomic.LoadUint64(m.counter(name))
+}
+
+// Snapshot copia todos os contadores para um map (seguro para json.Marshal).
+func (m *metricSet) Snapshot() map[string]uint64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[string]uint64, len(m.counters))
+	for k, c := range m.counters {
+		out[k] = atomic.LoadUint64(c)
+	}
+	return out
+}
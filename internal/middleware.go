@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+// RecoverRequests transforma o panic de um handler em erro, sem derrubar o
+// reader do /data.
+func RecoverRequests(next RequestDispatcher) RequestDispatcher {
+	return func(req *rotompb.MitmRequest) (resp *rotompb.MitmResponse, handled bool, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				metrics.Inc("handlers.panics")
+				NewLogger().Errorf("[handlers] panic handling MitmRequest id=%d (%s): %v\n%s", req.GetId(), req.GetMethod(), r, debug.Stack())
+				resp, handled, err = nil, true, fmt.Errorf("handler panic: %v", r)
+			}
+		}()
+		return next(req)
+	}
+}
+
+// RecoverResponses é RecoverRequests para response handlers.
+func RecoverResponses(next ResponseDispatcher) ResponseDispatcher {
+	return func(resp *rotompb.MitmResponse) (handled bool, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				metrics.Inc("handlers.panics")
+				NewLogger().Errorf("[handlers] panic handling MitmResponse id=%d (%s): %v\n%s", resp.GetId(), resp.GetStatus(), r, debug.Stack())
+				handled, err = true, fmt.Errorf("handler panic: %v", r)
+			}
+		}()
+		return next(resp)
+	}
+}
+
+// LogRequests loga (debug) cada request despachado e o resultado.
+func LogRequests(next RequestDispatcher) RequestDispatcher {
+	return func(req *rotompb.MitmRequest) (*rotompb.MitmResponse, bool, error) {
+		resp, handled, err := next(req)
+		logger := NewLogger()
+		switch {
+		case err != nil:
+			logger.Warnf("[handlers] MitmRequest id=%d (%s) failed: %v", req.GetId(), req.GetMethod(), err)
+		case handled:
+			logger.Debugf("[handlers] MitmRequest id=%d (%s) -> %s", req.GetId(), req.GetMethod(), resp.GetStatus())
+		}
+		return resp, handled, err
+	}
+}
+
+// LogResponses loga (debug) cada response despachada.
+func LogResponses(next ResponseDispatcher) ResponseDispatcher {
+	return func(resp *rotompb.MitmResponse) (bool, error) {
+		handled, err := next(resp)
+		if err != nil {
+			NewLogger().Warnf("[handlers] MitmResponse id=%d (%s) failed: %v", resp.GetId(), resp.GetStatus(), err)
+		} else if handled {
+			NewLogger().Debugf("[handlers] MitmResponse id=%d (%s) handled", resp.GetId(), resp.GetStatus())
+		}
+		return handled, err
+	}
+}
+
+// slowHandler é o tempo a partir do qual um dispatch é logado como lento.
+const slowHandler = 250 * time.Millisecond
+
+// TimeRequests conta os requests despachados e o tempo gasto nos handlers
+// (handlers.requests, handlers.request_us).
+func TimeRequests(next RequestDispatcher) RequestDispatcher {
+	return func(req *rotompb.MitmRequest) (*rotompb.MitmResponse, bool, error) {
+		start := time.Now()
+		resp, handled, err := next(req)
+		elapsed := time.Since(start)
+		metrics.Inc("handlers.requests")
+		metrics.Add("handlers.request_us", uint64(elapsed.Microseconds()))
+		if elapsed > slowHandler {
+			NewLogger().Warnf("[handlers] MitmRequest id=%d (%s) took %s", req.GetId(), req.GetMethod(), elapsed)
+		}
+		return resp, handled, err
+	}
+}
+
+// ValidateRequests recusa requests sem id ou cujo payload não bate com o
+// método, antes de chegarem aos handlers.
+func ValidateRequests(next RequestDispatcher) RequestDispatcher {
+	return func(req *rotompb.MitmRequest) (*rotompb.MitmResponse, bool, error) {
+		if err := validateRequest(req); err != nil {
+			metrics.Inc("handlers.invalid")
+			return nil, false, err
+		}
+		return next(req)
+	}
+}
+
+func validateRequest(req *rotompb.MitmRequest) error {
+	if req.GetId() == 0 {
+		return errors.New("MitmRequest without id")
+	}
+	switch req.GetMethod() {
+	case rotompb.MitmRequest_LOGIN:
+		if req.GetLoginRequest() == nil {
+			return fmt.Errorf("MitmRequest id=%d: LOGIN without login_request", req.GetId())
+		}
+	case rotompb.MitmRequest_RPC_REQUEST:
+		if req.GetRpcRequest() == nil {
+			return fmt.Errorf("MitmRequest id=%d: RPC_REQUEST without rpc_request", req.GetId())
+		}
+	default:
+		return fmt.Errorf("MitmRequest id=%d: unknown method %d", req.GetId(), int(req.GetMethod()))
+	}
+	return nil
+}
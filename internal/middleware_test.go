@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+func marshalRequest(t *testing.T, req *rotompb.MitmRequest) []byte {
+	t.Helper()
+	b, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRequestMiddlewareOrder(t *testing.T) {
+	isolateHandlers(t)
+	var trace []string
+	mark := func(name string) RequestMiddleware {
+		return func(next RequestDispatcher) RequestDispatcher {
+			return func(req *rotompb.MitmRequest) (*rotompb.MitmResponse, bool, error) {
+				trace = append(trace, name+">")
+				resp, handled, err := next(req)
+				trace = append(trace, "<"+name)
+				return resp, handled, err
+			}
+		}
+	}
+	UseRequestMiddleware(mark("a"), mark("b"))
+	UseRequestMiddleware(mark("c"))
+	RegisterRequestHandler("RPC_REQUEST", func(_ *rotompb.MitmRequest, resp *rotompb.MitmResponse) {
+		trace = append(trace, "h1")
+		resp.Status = rotompb.MitmResponse_SUCCESS
+	})
+	RegisterRequestHandler("RPC_REQUEST", func(*rotompb.MitmRequest, *rotompb.MitmResponse) {
+		trace = append(trace, "h2")
+	})
+
+	handled, out, err := DispatchMitmRequest(marshalRequest(t, rpcRequestFor(1, 10)))
+	if err != nil || !handled || out == nil {
+		t.Fatalf("DispatchMitmRequest: handled=%v out=%v err=%v", handled, out, err)
+	}
+	// o primeiro registrado é o mais externo; os handlers seguem o registro
+	if got, want := strings.Join(trace, " "), "a> b> c> h1 h2 <c <b <a"; got != want {
+		t.Fatalf("trace %q, want %q", got, want)
+	}
+}
+
+func TestDefaultMiddlewareOrder(t *testing.T) {
+	isolateHandlers(t)
+	registerDefaultHandlers()
+
+	// Validate fica dentro de Time: um request inválido é medido e nunca
+	// chega aos handlers
+	reached := false
+	RegisterRpcHandler(10, func(*rotompb.MitmRequest, *rotompb.MitmRequest_RpcRequest_SingleRpcRequest) *rotompb.MitmResponse_RpcResponse_SingleRpcResponse {
+		reached = true
+		return nil
+	})
+	requests, invalid := metrics.Get("handlers.requests"), metrics.Get("handlers.invalid")
+	handled, _, err := DispatchMitmRequest(marshalRequest(t, rpcRequestFor(0, 10)))
+	if err == nil || handled || reached {
+		t.Fatalf("request without id: handled=%v err=%v reached=%v", handled, err, reached)
+	}
+	if metrics.Get("handlers.invalid") != invalid+1 || metrics.Get("handlers.requests") != requests+1 {
+		t.Fatal("invalid request not validated inside TimeRequests")
+	}
+
+	// Recover é o mais externo: o panic atravessa Time (que não conta o
+	// request) e vira erro
+	RegisterRpcHandler(20, func(*rotompb.MitmRequest, *rotompb.MitmRequest_RpcRequest_SingleRpcRequest) *rotompb.MitmResponse_RpcResponse_SingleRpcResponse {
+		panic("boom")
+	})
+	requests, panics := metrics.Get("handlers.requests"), metrics.Get("handlers.panics")
+	handled, out, err := DispatchMitmRequest(marshalRequest(t, rpcRequestFor(2, 20)))
+	if err == nil || !strings.Contains(err.Error(), "boom") || !handled || out != nil {
+		t.Fatalf("panicking handler: handled=%v out=%v err=%v", handled, out, err)
+	}
+	if metrics.Get("handlers.panics") != panics+1 || metrics.Get("handlers.requests") != requests {
+		t.Fatal("panic not recovered outside TimeRequests")
+	}
+
+	// e o dispatch segue funcionando depois do panic
+	handled, out, err = DispatchMitmRequest(marshalRequest(t, rpcRequestFor(3, 10)))
+	if err != nil || !handled || out == nil {
+		t.Fatalf("dispatch after a panic: handled=%v out=%v err=%v", handled, out, err)
+	}
+}
+
+func TestRecoverResponses(t *testing.T) {
+	isolateHandlers(t)
+	UseResponseMiddleware(RecoverResponses, LogResponses)
+	RegisterResponseHandler(fmt.Sprintf("%d", int(rotompb.MitmResponse_SUCCESS)), func(*rotompb.MitmResponse) {
+		panic("boom")
+	})
+	b, err := proto.Marshal(&rotompb.MitmResponse{Id: 1, Status: rotompb.MitmResponse_SUCCESS})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handled, err := DispatchMitmResponse(b)
+	if err == nil || !handled {
+		t.Fatalf("panicking response handler: handled=%v err=%v", handled, err)
+	}
+}
+
+func TestRegisterWhileDispatching(t *testing.T) {
+	isolateHandlers(t)
+	UseRequestMiddleware(RecoverRequests)
+	RegisterRpcHandler(10, replyWith("r10"))
+	msg := marshalRequest(t, rpcRequestFor(1, 10, 20))
+	resp, err := proto.Marshal(&rotompb.MitmResponse{Id: 1, Status: rotompb.MitmResponse_SUCCESS})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// dispatch e registro rodam juntos; o -race acusa acesso sem o RWMutex
+	const rounds = 200
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for n := 0; n < rounds; n++ {
+				if _, _, err := DispatchMitmRequest(msg); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := DispatchMitmResponse(resp); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-start
+		for n := 0; n < rounds; n++ {
+			RegisterRpcHandler(20, replyWith("r20"))
+			RegisterRequestHandler("RPC_REQUEST", func(*rotompb.MitmRequest, *rotompb.MitmResponse) {})
+			RegisterResponseHandler(fmt.Sprintf("%d", int(rotompb.MitmResponse_SUCCESS)), func(*rotompb.MitmResponse) {})
+			if n%50 == 0 {
+				UseRequestMiddleware(TimeRequests)
+				UseResponseMiddleware(RecoverResponses)
+			}
+		}
+	}()
+	close(start)
+	wg.Wait()
+
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	if len(rpcHandlers[20]) != rounds {
+		t.Fatalf("%d handler(s) for method 20, want %d", len(rpcHandlers[20]), rounds)
+	}
+}
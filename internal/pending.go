@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	rotompb "rotomworker/proto_gen"
+)
+
+var (
+	// ErrRequestTimeout é entregue quando nenhuma MitmResponse chega antes do deadline.
+	ErrRequestTimeout = errors.New("mitm request timed out")
+	// ErrConnectionLost é entregue aos pendentes quando o socket /data cai.
+	ErrConnectionLost = errors.New("data connection lost")
+)
+
+// lateWindow limita quantos ids expirados lembramos para classificar respostas atrasadas.
+const lateWindow = 1024
+
+// PendingResult é o resultado de um MitmRequest em voo.
+type PendingResult struct {
+	ID   uint32
+	Resp *rotompb.MitmResponse
+	Err  error
+	Item SendItem
+}
+
+// PendingCallback recebe o resultado de um request rastreado (resposta, timeout ou queda).
+type PendingCallback func(PendingResult)
+
+type pendingEntry struct {
+	id       uint32
+	deadline time.Time
+	item     SendItem
+	cb       PendingCallback
+	timer    *time.Timer
+}
+
+// PendingTable rastreia MitmRequests enviados no /data, indexados por MitmRequest.id,
+// e entrega a MitmResponse correspondente para quem enviou.
+type PendingTable struct {
+	name    string // prefixo das métricas (ex: "pending", "ack")
+	mu      sync.Mutex
+	entries map[uint32]*pendingEntry
+	expired map[uint32]struct{}
+	order   []uint32 // fila FIFO dos ids em expired
+}
+
+// NewPendingTable cria uma tabela vazia; name prefixa as métricas e os logs.
+func NewPendingTable(name string) *PendingTable {
+	return &PendingTable{
+		name:    name,
+		entries: map[uint32]*pendingEntry{},
+		expired: map[uint32]struct{}{},
+	}
+}
+
+// Track registra um request em voo. cb é chamado exatamente uma vez: com a resposta,
+// com ErrRequestTimeout após timeout, ou com o erro passado para FailAll.
+// Se o id já estiver em voo, o registro anterior é falhado e substituído.
+func (t *PendingTable) Track(id uint32, timeout time.Duration, item SendItem, cb PendingCallback) {
+	e := &pendingEntry{id: id, deadline: time.Now().Add(timeout), item: item, cb: cb}
+
+	t.mu.Lock()
+	old := t.entries[id]
+	t.entries[id] = e
+	delete(t.expired, id)
+	e.timer = time.AfterFunc(timeout, func() { t.expire(e) })
+	t.mu.Unlock()
+
+	metrics.Inc(t.name + ".tracked")
+	if old != nil {
+		old.timer.Stop()
+		metrics.Inc(t.name + ".replaced")
+		old.finish(PendingResult{ID: id, Err: errors.New("request id reused"), Item: old.item})
+	}
+}
+
+// Await é um atalho bloqueante sobre Track: devolve um canal que recebe o resultado.
+func (t *PendingTable) Await(id uint32, timeout time.Duration, item SendItem) <-chan PendingResult {
+	ch := make(chan PendingResult, 1)
+	t.Track(id, timeout, item, func(r PendingResult) { ch <- r })
+	return ch
+}
+
+// Resolve entrega resp ao request pendente de mesmo id. Retorna false quando a
+// resposta não corresponde a nada em voo (desconhecida ou atrasada); esses casos
+// são contados e logados aqui.
+func (t *PendingTable) Resolve(resp *rotompb.MitmResponse) bool {
+	return t.resolve(resp.GetId(), resp)
+}
+
+// ResolveID entrega uma confirmação sem MitmResponse (ex: frame de ack).
+func (t *PendingTable) ResolveID(id uint32) bool {
+	return t.resolve(id, nil)
+}
+
+func (t *PendingTable) resolve(id uint32, resp *rotompb.MitmResponse) bool {
+	t.mu.Lock()
+	e, ok := t.entries[id]
+	if ok {
+		delete(t.entries, id)
+		e.timer.Stop()
+	}
+	_, late := t.expired[id]
+	if late {
+		delete(t.expired, id)
+	}
+	t.mu.Unlock()
+
+	if !ok {
+		logger := NewLogger()
+		if late {
+			metrics.Inc(t.name + ".late")
+			logger.Warnf("[%s] late MitmResponse id=%d status=%s (request already timed out)", t.name, id, resp.GetStatus())
+		} else {
+			metrics.Inc(t.name + ".unmatched")
+			logger.Warnf("[%s] unmatched MitmResponse id=%d status=%s", t.name, id, resp.GetStatus())
+		}
+		return false
+	}
+
+	metrics.Inc(t.name + ".matched")
+	e.finish(PendingResult{ID: id, Resp: resp, Item: e.item})
+	return true
+}
+
+// FailAll falha todos os requests em voo com err (ex: conexão caiu).
+func (t *PendingTable) FailAll(err error) {
+	t.mu.Lock()
+	entries := t.entries
+	t.entries = map[uint32]*pendingEntry{}
+	t.mu.Unlock()
+
+	for _, e := range entries {
+		e.timer.Stop()
+		metrics.Inc(t.name + ".failed")
+		e.finish(PendingResult{ID: e.id, Err: err, Item: e.item})
+	}
+}
+
+// Forget remove o registro de id sem chamar o callback (ex: escrita falhou e o
+// item vai ser reenviado com o mesmo id).
+func (t *PendingTable) Forget(id uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.entries[id]; ok {
+		e.timer.Stop()
+		delete(t.entries, id)
+	}
+}
+
+// Len retorna quantos requests estão em voo.
+func (t *PendingTable) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.entries)
+}
+
+func (t *PendingTable) expire(e *pendingEntry) {
+	t.mu.Lock()
+	if cur, ok := t.entries[e.id]; !ok || cur != e {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.entries, e.id)
+	t.expired[e.id] = struct{}{}
+	t.order = append(t.order, e.id)
+	for len(t.order) > lateWindow {
+		delete(t.expired, t.order[0])
+		t.order = t.order[1:]
+	}
+	t.mu.Unlock()
+
+	metrics.Inc(t.name + ".timeout")
+	NewLogger().Warnf("[%s] MitmRequest id=%d timed out (deadline %s)", t.name, e.id, e.deadline.Format(time.RFC3339))
+	e.finish(PendingResult{ID: e.id, Err: ErrRequestTimeout, Item: e.item})
+}
+
+func (e *pendingEntry) finish(r PendingResult) {
+	if e.cb != nil {
+		e.cb(r)
+	}
+}
+
+// requestID extrai MitmRequest.id de um payload serializado. ok=false quando o
+// payload não é um MitmRequest reconhecível ou não tem id.
+func requestID(payload []byte) (uint32, bool) {
+	req, ok := decodeMitmRequest(payload)
+	if !ok {
+		return 0, false
+	}
+	return req.GetId(), req.GetId() != 0
+}
+
+// decodeMitmResponse tenta interpretar msg como MitmResponse. Só aceita frames
+// com um status conhecido diferente de UNSET, para não confundir com MitmRequest
+// (os dois tipos compartilham os números de campo).
+func decodeMitmResponse(msg []byte) (*rotompb.MitmResponse, bool) {
+	var resp rotompb.MitmResponse
+	if err := proto.Unmarshal(msg, &resp); err != nil {
+		return nil, false
+	}
+	if resp.GetStatus() == rotompb.MitmResponse_UNSET {
+		return nil, false
+	}
+	if _, known := rotompb.MitmResponse_Status_name[int32(resp.GetStatus())]; !known {
+		return nil, false
+	}
+	return &resp, true
+}
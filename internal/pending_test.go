@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+var pendingTables atomic.Int32
+
+// newTestPendingTable cria uma tabela com nome único, para que as métricas
+// dela comecem zeradas mesmo com -count.
+func newTestPendingTable(t *testing.T) *PendingTable {
+	return NewPendingTable(fmt.Sprintf("%s.%d", t.Name(), pendingTables.Add(1)))
+}
+
+// awaitResult espera o resultado de ch ou falha o teste.
+func awaitResult(t *testing.T, ch <-chan PendingResult) PendingResult {
+	t.Helper()
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(3 * time.Second):
+		t.Fatal("no pending result")
+	}
+	return PendingResult{}
+}
+
+// expectNoResult confirma que ch não recebeu nada.
+func expectNoResult(t *testing.T, ch <-chan PendingResult) {
+	t.Helper()
+	select {
+	case r := <-ch:
+		t.Fatalf("unexpected pending result %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func successResponse(id uint32) *rotompb.MitmResponse {
+	return &rotompb.MitmResponse{Id: id, Status: rotompb.MitmResponse_SUCCESS}
+}
+
+func TestPendingTableResolveMatchesByID(t *testing.T) {
+	p := newTestPendingTable(t)
+	first := p.Await(1, time.Minute, SendItem{Path: "/scan/a.bin"})
+	second := p.Await(2, time.Minute, SendItem{Path: "/scan/b.bin"})
+	if p.Len() != 2 {
+		t.Fatalf("%d request(s) in flight, want 2", p.Len())
+	}
+
+	// fora de ordem: cada resposta vai para o seu id
+	if !p.Resolve(successResponse(2)) {
+		t.Fatal("response id=2 not matched")
+	}
+	r := awaitResult(t, second)
+	if r.ID != 2 || r.Err != nil || r.Item.Path != "/scan/b.bin" || r.Resp.GetStatus() != rotompb.MitmResponse_SUCCESS {
+		t.Fatalf("result for id=2: %+v", r)
+	}
+	expectNoResult(t, first)
+
+	if !p.ResolveID(1) {
+		t.Fatal("ack id=1 not matched")
+	}
+	if r := awaitResult(t, first); r.ID != 1 || r.Err != nil || r.Resp != nil {
+		t.Fatalf("result for id=1: %+v", r)
+	}
+	if p.Len() != 0 {
+		t.Fatalf("%d request(s) still in flight", p.Len())
+	}
+	if got := metrics.Get(p.name + ".matched"); got != 2 {
+		t.Fatalf("%d matched, want 2", got)
+	}
+}
+
+func TestPendingTableTimeout(t *testing.T) {
+	p := newTestPendingTable(t)
+	ch := p.Await(7, 20*time.Millisecond, SendItem{Path: "/scan/slow.bin"})
+	r := awaitResult(t, ch)
+	if !errors.Is(r.Err, ErrRequestTimeout) || r.ID != 7 || r.Item.Path != "/scan/slow.bin" {
+		t.Fatalf("result %+v, want ErrRequestTimeout for id=7", r)
+	}
+	if p.Len() != 0 {
+		t.Fatalf("%d request(s) in flight after timeout", p.Len())
+	}
+	if got := metrics.Get(p.name + ".timeout"); got != 1 {
+		t.Fatalf("%d timeout(s), want 1", got)
+	}
+}
+
+func TestPendingTableLateAndUnmatched(t *testing.T) {
+	p := newTestPendingTable(t)
+	awaitResult(t, p.Await(3, 10*time.Millisecond, SendItem{}))
+
+	// resposta de um id que expirou é atrasada; a segunda vez já é desconhecida
+	cases := []struct {
+		id            uint32
+		late, unknown uint64
+	}{
+		{3, 1, 0},
+		{3, 1, 1},
+		{99, 1, 2},
+	}
+	for _, tc := range cases {
+		if p.Resolve(successResponse(tc.id)) {
+			t.Fatalf("response id=%d matched nothing in flight", tc.id)
+		}
+		late, unknown := metrics.Get(p.name+".late"), metrics.Get(p.name+".unmatched")
+		if late != tc.late || unknown != tc.unknown {
+			t.Fatalf("after id=%d: late=%d unmatched=%d, want %d and %d", tc.id, late, unknown, tc.late, tc.unknown)
+		}
+	}
+}
+
+func TestPendingTableFailAll(t *testing.T) {
+	p := newTestPendingTable(t)
+	chans := []<-chan PendingResult{
+		p.Await(1, time.Minute, SendItem{}),
+		p.Await(2, time.Minute, SendItem{}),
+		p.Await(3, time.Minute, SendItem{}),
+	}
+	p.FailAll(ErrConnectionLost)
+	for i, ch := range chans {
+		if r := awaitResult(t, ch); !errors.Is(r.Err, ErrConnectionLost) {
+			t.Fatalf("request %d: %+v, want ErrConnectionLost", i+1, r)
+		}
+	}
+	if p.Len() != 0 {
+		t.Fatalf("%d request(s) in flight after FailAll", p.Len())
+	}
+	if got := metrics.Get(p.name + ".failed"); got != 3 {
+		t.Fatalf("%d failed, want 3", got)
+	}
+	// depois da queda a resposta não casa com nada nem é contada como atrasada
+	if p.Resolve(successResponse(1)) {
+		t.Fatal("response matched after FailAll")
+	}
+	if got := metrics.Get(p.name + ".late"); got != 0 {
+		t.Fatalf("%d late after FailAll, want 0", got)
+	}
+}
+
+func TestPendingTableForget(t *testing.T) {
+	p := newTestPendingTable(t)
+	ch := p.Await(5, 30*time.Millisecond, SendItem{})
+	p.Forget(5)
+	if p.Len() != 0 {
+		t.Fatalf("%d request(s) in flight after Forget", p.Len())
+	}
+	// nem timeout nem resposta chegam ao callback
+	expectNoResult(t, ch)
+	if p.Resolve(successResponse(5)) {
+		t.Fatal("response matched a forgotten id")
+	}
+	if got := metrics.Get(p.name + ".timeout"); got != 0 {
+		t.Fatalf("%d timeout(s) for a forgotten id", got)
+	}
+}
+
+func TestPendingTableReusesResolvedID(t *testing.T) {
+	p := newTestPendingTable(t)
+	first := p.Await(9, time.Minute, SendItem{Path: "/scan/first.bin"})
+	p.Resolve(successResponse(9))
+	awaitResult(t, first)
+
+	// o mesmo id volta a ser rastreado e resolvido normalmente
+	second := p.Await(9, time.Minute, SendItem{Path: "/scan/second.bin"})
+	if !p.Resolve(successResponse(9)) {
+		t.Fatal("reused id=9 not matched")
+	}
+	if r := awaitResult(t, second); r.Err != nil || r.Item.Path != "/scan/second.bin" {
+		t.Fatalf("result for reused id: %+v", r)
+	}
+	expectNoResult(t, first)
+
+	// um id reaproveitado ainda em voo falha o registro anterior
+	third := p.Await(9, time.Minute, SendItem{Path: "/scan/third.bin"})
+	fourth := p.Await(9, time.Minute, SendItem{Path: "/scan/fourth.bin"})
+	if r := awaitResult(t, third); r.Err == nil || r.Item.Path != "/scan/third.bin" {
+		t.Fatalf("replaced request: %+v, want an error", r)
+	}
+	p.Resolve(successResponse(9))
+	if r := awaitResult(t, fourth); r.Err != nil || r.Item.Path != "/scan/fourth.bin" {
+		t.Fatalf("replacing request: %+v", r)
+	}
+	if got := metrics.Get(p.name + ".replaced"); got != 1 {
+		t.Fatalf("%d replaced, want 1", got)
+	}
+}
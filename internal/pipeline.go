@@ -0,0 +1,300 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// errSkipItem encerra o pipeline sem erro (ex: payload vazio descartado).
+	errSkipItem = errors.New("send item skipped")
+	// errDryRun encerra o pipeline antes da escrita (usado por Encode).
+	errDryRun = errors.New("dry run")
+)
+
+// SendJob carrega um SendItem pelos estágios do pipeline de envio.
+type SendJob struct {
+	Item SendItem
+
+	// Payload são os bytes atuais; cada estágio pode substituí-los.
+	Payload []byte
+	// Hooked indica que um hook ELF substituiu o payload (não comprimir de novo).
+	Hooked bool
+	// Compressed indica que o payload já foi comprimido.
+	Compressed bool
+	// RequestID é o MitmRequest.id do payload (0 se não for um MitmRequest).
+	RequestID uint32
+	// AwaitAck indica que o cleanup espera a confirmação do Rotom (ack_mode).
+	AwaitAck bool
+	// AckSeq é o frame que confirma um item sem RequestID (modo "frame").
+	AckSeq uint32
+
+	// MessageType/Frame são o que vai para o websocket.
+	MessageType int
+	Frame       []byte
+
+	ctx     context.Context
+	worker  *DataWorker
+	conn    *websocket.Conn
+	tracked bool
+}
+
+// SendStage é um estágio plugável do pipeline de envio.
+type SendStage interface {
+	Name() string
+	Process(job *SendJob) error
+}
+
+// sendStageFunc adapta uma função simples para SendStage.
+type sendStageFunc struct {
+	name string
+	fn   func(job *SendJob) error
+}
+
+func (s sendStageFunc) Name() string               { return s.name }
+func (s sendStageFunc) Process(job *SendJob) error { return s.fn(job) }
+
+// NewSendStage cria um estágio a partir de uma função.
+func NewSendStage(name string, fn func(job *SendJob) error) SendStage {
+	return sendStageFunc{name: name, fn: fn}
+}
+
+// SendPipeline é o único caminho de envio, compartilhado por todos os workers:
+// hook -> negotiate -> compress -> frame -> write -> cleanup.
+type SendPipeline struct {
+	stages      []SendStage
+	batch       batchOptions
+	compression *CompressionPolicy
+}
+
+// NewSendPipeline monta o pipeline padrão a partir da configuração. A política
+// de compressão (rotom.compression_*) é montada uma vez aqui, para que todos
+// os workers codifiquem igual; um codec inválido desliga a compressão.
+func NewSendPipeline(cfg Config) *SendPipeline {
+	compression, err := compressionFromConfig(cfg)
+	if err != nil {
+		NewLogger().Errorf("[send] %v; compression disabled", err)
+		compression = &CompressionPolicy{Codec: noneCodec{}}
+	}
+	return &SendPipeline{stages: []SendStage{
+		NewSendStage("hook", hookStage),
+		NewSendStage("negotiate", func(job *SendJob) error { return negotiateStage(job, compression) }),
+		NewSendStage("compress", func(job *SendJob) error { return compressStage(job, compression) }),
+		NewSendStage("frame", frameStage),
+		NewSendStage("write", writeStage),
+		NewSendStage("cleanup", cleanupStage),
+	}, batch: newBatchOptions(cfg), compression: compression}
+}
+
+// Compression devolve a política de compressão do pipeline.
+func (p *SendPipeline) Compression() *CompressionPolicy {
+	return p.compression
+}
+
+// Stages retorna os nomes dos estágios, em ordem.
+func (p *SendPipeline) Stages() []string {
+	names := make([]string, 0, len(p.stages))
+	for _, s := range p.stages {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+// Insert adiciona stage antes do estágio chamado before (ou no fim, se não existir).
+func (p *SendPipeline) Insert(before string, stage SendStage) {
+	for i, s := range p.stages {
+		if s.Name() == before {
+			p.stages = append(p.stages[:i], append([]SendStage{stage}, p.stages[i:]...)...)
+			return
+		}
+	}
+	p.stages = append(p.stages, stage)
+}
+
+// Replace troca o estágio chamado name. Retorna false se ele não existir.
+func (p *SendPipeline) Replace(name string, stage SendStage) bool {
+	for i, s := range p.stages {
+		if s.Name() == name {
+			p.stages[i] = stage
+			return true
+		}
+	}
+	return false
+}
+
+// Encode roda o pipeline sem conexão e devolve o job pronto para escrita.
+// Para a mesma configuração e o mesmo item, o Frame é sempre idêntico.
+func (p *SendPipeline) Encode(item SendItem) (*SendJob, error) {
+	job := &SendJob{Item: item, Payload: item.Payload}
+	err := p.run(job)
+	if err == errDryRun {
+		err = nil
+	}
+	return job, err
+}
+
+// Send leva item até a conexão conn de w. Um erro significa que a escrita
+// falhou (ou ctx foi cancelado): o item já foi reenfileirado e a conexão deve
+// ser refeita.
+func (p *SendPipeline) Send(ctx context.Context, w *DataWorker, conn *websocket.Conn, item SendItem) error {
+	job := &SendJob{Item: item, Payload: item.Payload, ctx: ctx, worker: w, conn: conn}
+	err := p.run(job)
+	if err == errSkipItem {
+		return nil
+	}
+	return err
+}
+
+func (p *SendPipeline) run(job *SendJob) error {
+	for _, s := range p.stages {
+		if err := s.Process(job); err != nil {
+			if err == errSkipItem || err == errDryRun {
+				return err
+			}
+			return fmt.Errorf("%s: %w", s.Name(), err)
+		}
+	}
+	return nil
+}
+
+// processRequestHook é a chamada aos hooks ELF usada pelo hookStage (os
+// testes trocam por um hook falso).
+var processRequestHook = TryProcessRequest
+
+// hookStage oferece o payload cru aos hooks ELF (HandleRequest). Se um hook
+// devolver um buffer, ele substitui o payload e a compressão é pulada. Um hook
+// que recusa o payload conta como tentativa falha (failItem).
+func hookStage(job *SendJob) error {
+	if len(job.Payload) == 0 {
+		// remove file to avoid infinite loop
+		NewLogger().Warnf("[send] got empty SendItem payload; skipping %s", describeItem(job.Item))
+		if job.Item.Path != "" {
+			_ = os.Remove(job.Item.Path)
+			scanClaims.Done(job.Item.Path)
+		}
+		ackItem(job.Item)
+		return errSkipItem
+	}
+	out, err := processRequestHook(job.Payload)
+	switch {
+	case errors.Is(err, ErrNoRequestHook):
+	case err != nil:
+		failItem(job.Item, fmt.Errorf("hook: %w", err))
+		return errSkipItem
+	case len(out) > 0:
+		job.Payload = out
+		job.Hooked = true
+	}
+	if id, ok := requestID(job.Payload); ok {
+		job.RequestID = id
+	}
+	return nil
+}
+
+// gunzip abre um payload gzip.
+func gunzip(b []byte) ([]byte, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	return io.ReadAll(gr)
+}
+
+// frameStage define o frame websocket (uma mensagem binária por item).
+func frameStage(job *SendJob) error {
+	job.MessageType = websocket.BinaryMessage
+	job.Frame = job.Payload
+	return nil
+}
+
+// writeStage registra o request como pendente (e, no modo ack, a espera pela
+// confirmação) e escreve o frame. Em caso de falha, desfaz os registros e
+// conta a tentativa (failItem).
+func writeStage(job *SendJob) error {
+	if job.conn == nil {
+		return errDryRun
+	}
+	w := job.worker
+	// antes do beginWrite: a espera não conta no prazo do ack
+	if err := sendLimiter.Wait(job.ctx, len(job.Frame)); err != nil {
+		// shutdown: o item não falhou, só volta para a fila
+		releaseItem(job.Item, 0)
+		return err
+	}
+	beginWrite(job)
+	var before func(uint32)
+	if job.AwaitAck && job.RequestID == 0 {
+		before = func(seq uint32) { w.trackSeqAck(seq, []*SendJob{job}) }
+	}
+	if err := w.writeFrame(job.conn, job.MessageType, job.Frame, 15*time.Second, before); err != nil {
+		abortWrite(job, err)
+		return err
+	}
+	return nil
+}
+
+// beginWrite registra job como pendente/aguardando ack. Deve ser chamado antes
+// da escrita, para não perder respostas rápidas. A espera de um item sem
+// RequestID é registrada na escrita, quando o número do frame é conhecido.
+func beginWrite(job *SendJob) {
+	w := job.worker
+	job.AwaitAck = w.awaitsAck(job)
+	if job.RequestID != 0 {
+		_, job.tracked = w.trackItem(job.Item, decodedPayload(job), job.AwaitAck)
+	}
+	if job.AwaitAck {
+		markInFlight(job.Item.Path)
+		if w.ackMode == AckFrame && job.RequestID != 0 {
+			w.acks.Track(job.RequestID, w.ackTimeout(), job.Item, w.onAckResult)
+		}
+	}
+}
+
+// abortWrite desfaz beginWrite depois de uma escrita que falhou.
+func abortWrite(job *SendJob, err error) {
+	w := job.worker
+	w.untrackItem(job.RequestID, job.tracked)
+	if job.AwaitAck {
+		if job.RequestID != 0 {
+			w.acks.Forget(job.RequestID)
+		} else if job.AckSeq != 0 {
+			w.seqAcks.Forget(job.AckSeq)
+		}
+		clearInFlight(job.Item.Path)
+	}
+	w.logger.Errorf("[%s] write message failed for %s: %v", w.ID, describeItem(job.Item), err)
+	failItem(job.Item, err)
+}
+
+// decodedPayload é o payload que o Rotom vai ecoar na resposta (antes da compressão).
+func decodedPayload(job *SendJob) []byte {
+	if job.Hooked {
+		return job.Payload
+	}
+	return job.Item.Payload
+}
+
+// cleanupStage confirma o item no spool e remove o arquivo de origem depois
+// de um envio com sucesso (encerrando o claim). No modo ack isso fica para a confirmação.
+func cleanupStage(job *SendJob) error {
+	w := job.worker
+	if job.AwaitAck {
+		if job.RequestID == 0 {
+			w.logger.Infof("[%s] sent %s (%d bytes); awaiting ack seq=%d", w.ID, describeItem(job.Item), len(job.Frame), job.AckSeq)
+			return nil
+		}
+		w.logger.Infof("[%s] sent %s (%d bytes); awaiting ack id=%d", w.ID, describeItem(job.Item), len(job.Frame), job.RequestID)
+		return nil
+	}
+	finishItem(w, job.Item, job.Frame, "sent")
+	return nil
+}
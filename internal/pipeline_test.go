@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	rotompb "rotomworker/proto_gen"
+)
+
+func rpcRequestPayload(t *testing.T, id uint32, payloads ...[]byte) []byte {
+	t.Helper()
+	rr := &rotompb.MitmRequest_RpcRequest{}
+	for i, p := range payloads {
+		rr.Request = append(rr.Request, &rotompb.MitmRequest_RpcRequest_SingleRpcRequest{Method: int32(100 + i), Payload: p})
+	}
+	b, err := proto.Marshal(&rotompb.MitmRequest{
+		Id:      id,
+		Method:  rotompb.MitmRequest_RPC_REQUEST,
+		Payload: &rotompb.MitmRequest_RpcRequest_{RpcRequest: rr},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSendPipelineEncodeDeterministic(t *testing.T) {
+	big := bytes.Repeat([]byte("rotom payload "), 64)
+	items := map[string]SendItem{
+		"rpc":    {Payload: rpcRequestPayload(t, 7, big, []byte("small"))},
+		"opaque": {Payload: big},
+	}
+	for _, codec := range []string{CodecNone, CodecGzip} {
+		cfg := defaultConfig()
+		cfg.Rotom.CompressionCodec = codec
+		for name, item := range items {
+			first, err := NewSendPipeline(cfg).Encode(item)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", codec, name, err)
+			}
+			for i := 0; i < 5; i++ {
+				job, err := NewSendPipeline(cfg).Encode(item)
+				if err != nil {
+					t.Fatalf("%s/%s: %v", codec, name, err)
+				}
+				if !bytes.Equal(job.Frame, first.Frame) {
+					t.Fatalf("%s/%s: frame differs between runs", codec, name)
+				}
+			}
+			compressed := !bytes.Equal(first.Frame, item.Payload)
+			if compressed != (codec != CodecNone) {
+				t.Fatalf("%s/%s: compressed=%v", codec, name, compressed)
+			}
+		}
+	}
+}
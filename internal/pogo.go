@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	rotompb "rotomworker/proto_gen"
+)
+
+// PogoMethodTypes são os tipos (nome completo) do request e da resposta de um
+// método RPC, como no arquivo de mapeamento:
+//
+//	{"106": {"request": "POGOProtos.Rpc.GetMapObjectsProto",
+//	         "response": "POGOProtos.Rpc.GetMapObjectsOutProto"}}
+type PogoMethodTypes struct {
+	Request  string `json:"request"`
+	Response string `json:"response"`
+}
+
+// pogoRegistry é um descriptor set carregado em runtime com o mapa método→tipo.
+type pogoRegistry struct {
+	files   *protoregistry.Files
+	types   *dynamicpb.Types
+	methods map[int32]PogoMethodTypes
+}
+
+// pogoDescriptors é o registro em uso (nil = nada carregado).
+var pogoDescriptors atomic.Pointer[pogoRegistry]
+
+// LoadPogoDescriptors carrega pogo.descriptor_set (FileDescriptorSet
+// serializado, ex: gerado com protoc --descriptor_set_out --include_imports)
+// e pogo.method_map. Sem descriptor_set configurado, não faz nada. Pode ser
+// chamado de novo (comando "reload_pogo") para trocar os protos sem reiniciar.
+func LoadPogoDescriptors(cfg Config) error {
+	if cfg.Pogo.DescriptorSet == "" {
+		return nil
+	}
+	b, err := os.ReadFile(cfg.Pogo.DescriptorSet)
+	if err != nil {
+		return err
+	}
+	var fds descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(b, &fds); err != nil {
+		return fmt.Errorf("%s: %w", cfg.Pogo.DescriptorSet, err)
+	}
+	files, err := protodesc.NewFiles(&fds)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cfg.Pogo.DescriptorSet, err)
+	}
+	reg := &pogoRegistry{files: files, types: dynamicpb.NewTypes(files), methods: map[int32]PogoMethodTypes{}}
+
+	if cfg.Pogo.MethodMap != "" {
+		b, err := os.ReadFile(cfg.Pogo.MethodMap)
+		if err != nil {
+			return err
+		}
+		var raw map[string]PogoMethodTypes
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return fmt.Errorf("%s: %w", cfg.Pogo.MethodMap, err)
+		}
+		for k, t := range raw {
+			method, err := strconv.ParseInt(k, 10, 32)
+			if err != nil {
+				return fmt.Errorf("%s: method %q is not a number", cfg.Pogo.MethodMap, k)
+			}
+			for _, name := range []string{t.Request, t.Response} {
+				if name == "" {
+					continue
+				}
+				if _, err := reg.message(name); err != nil {
+					return fmt.Errorf("%s: method %d: %w", cfg.Pogo.MethodMap, method, err)
+				}
+			}
+			reg.methods[int32(method)] = t
+		}
+	}
+
+	pogoDescriptors.Store(reg)
+	NewLogger().Infof("[pogo] loaded %d file(s) from %s; %d method(s) mapped", files.NumFiles(), cfg.Pogo.DescriptorSet, len(reg.methods))
+	return nil
+}
+
+// message resolve o descriptor do tipo name.
+func (r *pogoRegistry) message(name string) (protoreflect.MessageDescriptor, error) {
+	d, err := r.files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", name)
+	}
+	return md, nil
+}
+
+// DecodePogoPayload interpreta o payload (descomprimido) de um método RPC com
+// os protos carregados em runtime e o devolve como JSON. response escolhe o
+// tipo da resposta em vez do request. false = sem protos, método sem tipo
+// mapeado ou payload inválido.
+func DecodePogoPayload(method int32, raw []byte, response bool) (string, bool) {
+	reg := pogoDescriptors.Load()
+	if reg == nil {
+		return "", false
+	}
+	t, ok := reg.methods[method]
+	if !ok {
+		return "", false
+	}
+	name := t.Request
+	if response {
+		name = t.Response
+	}
+	if name == "" {
+		return "", false
+	}
+	md, err := reg.message(name)
+	if err != nil {
+		return "", false
+	}
+	msg := dynamicpb.NewMessage(md)
+	if err := (proto.UnmarshalOptions{Resolver: reg.types}).Unmarshal(raw, msg); err != nil {
+		return "", false
+	}
+	out, err := protojson.MarshalOptions{Resolver: reg.types}.Marshal(msg)
+	if err != nil {
+		return "", false
+	}
+	return string(out), true
+}
+
+// inspectInboundStage loga (debug) os payloads RPC do frame como JSON, quando
+// pogo.log_payloads está ligado. Nunca trata o frame.
+func inspectInboundStage(job *InboundJob) (bool, error) {
+	w := job.worker
+	if !w.logger.IsLevelEnabled(logrus.DebugLevel) {
+		return false, nil
+	}
+	switch {
+	case job.Request != nil:
+		req := proto.Clone(job.Request).(*rotompb.MitmRequest)
+		if _, err := decompressRpcRequest(req); err != nil {
+			return false, err
+		}
+		for i, single := range req.GetRpcRequest().GetRequest() {
+			if js, ok := DecodePogoPayload(single.GetMethod(), single.GetPayload(), false); ok {
+				w.logger.Debugf("[%s] MitmRequest id=%d rpc[%d] method=%d: %s", w.ID, req.GetId(), i, single.GetMethod(), js)
+			}
+		}
+	case job.Response != nil:
+		resp := proto.Clone(job.Response).(*rotompb.MitmResponse)
+		if _, err := decompressRpcResponse(resp); err != nil {
+			return false, err
+		}
+		for i, single := range resp.GetRpcResponse().GetResponse() {
+			if js, ok := DecodePogoPayload(single.GetMethod(), single.GetPayload(), true); ok {
+				w.logger.Debugf("[%s] MitmResponse id=%d rpc[%d] method=%d: %s", w.ID, resp.GetId(), i, single.GetMethod(), js)
+			}
+		}
+	}
+	return false, nil
+}
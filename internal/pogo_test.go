@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// withPogoRegistry devolve o registro de protos ao estado anterior no fim do
+// teste.
+func withPogoRegistry(t *testing.T) {
+	t.Helper()
+	old := pogoDescriptors.Load()
+	t.Cleanup(func() { pogoDescriptors.Store(old) })
+}
+
+// writeDescriptorSet grava um FileDescriptorSet com o pacote pkg e uma
+// mensagem por nome, cada uma com um único campo string "name" (tag 1).
+func writeDescriptorSet(t *testing.T, pkg string, messages ...string) string {
+	t.Helper()
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(pkg + ".proto"),
+		Package: proto.String(pkg),
+		Syntax:  proto.String("proto3"),
+	}
+	for _, m := range messages {
+		file.MessageType = append(file.MessageType, &descriptorpb.DescriptorProto{
+			Name: proto.String(m),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("name"),
+				JsonName: proto.String("name"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			}},
+		})
+	}
+	b, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), pkg+".pb")
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeMethodMap grava o mapa método→tipo de pogo.method_map.
+func writeMethodMap(t *testing.T, methods map[string]PogoMethodTypes) string {
+	t.Helper()
+	b, err := json.Marshal(methods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "methods.json")
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// nameField codifica uma mensagem com o campo 1 (string) = name.
+func nameField(name string) []byte {
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendString(b, name)
+}
+
+func TestDecodePogoPayload(t *testing.T) {
+	withPogoRegistry(t)
+	cfg := testConfig(t)
+	cfg.Pogo.DescriptorSet = writeDescriptorSet(t, "pogotest", "GetThingProto", "GetThingOutProto")
+	cfg.Pogo.MethodMap = writeMethodMap(t, map[string]PogoMethodTypes{
+		"106": {Request: "pogotest.GetThingProto", Response: "pogotest.GetThingOutProto"},
+		"107": {Request: "pogotest.GetThingProto"},
+	})
+	if err := LoadPogoDescriptors(cfg); err != nil {
+		t.Fatalf("LoadPogoDescriptors: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		method   int32
+		raw      []byte
+		response bool
+		want     string
+		ok       bool
+	}{
+		{"request", 106, nameField("pikachu"), false, `"name":"pikachu"`, true},
+		{"response", 106, nameField("out"), true, `"name":"out"`, true},
+		{"unmapped method", 999, nameField("x"), false, "", false},
+		{"response not mapped", 107, nameField("x"), true, "", false},
+		{"invalid payload", 106, []byte{0x0a, 0x05, 'a'}, false, "", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := DecodePogoPayload(tc.method, tc.raw, tc.response)
+			if ok != tc.ok {
+				t.Fatalf("ok=%v, want %v (json %q)", ok, tc.ok, got)
+			}
+			if !strings.Contains(strings.ReplaceAll(got, " ", ""), tc.want) {
+				t.Fatalf("json %q, want it to contain %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadPogoDescriptorsRejectsBadMethodMap(t *testing.T) {
+	withPogoRegistry(t)
+	pogoDescriptors.Store(nil)
+	set := writeDescriptorSet(t, "pogotest", "GetThingProto")
+
+	cases := []struct {
+		name    string
+		methods map[string]PogoMethodTypes
+		wantErr string
+	}{
+		{"key not a number", map[string]PogoMethodTypes{"get_thing": {Request: "pogotest.GetThingProto"}}, `method "get_thing" is not a number`},
+		{"unknown request type", map[string]PogoMethodTypes{"106": {Request: "pogotest.Missing"}}, "pogotest.Missing"},
+		{"unknown response type", map[string]PogoMethodTypes{"106": {Request: "pogotest.GetThingProto", Response: "other.Missing"}}, "other.Missing"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testConfig(t)
+			cfg.Pogo.DescriptorSet = set
+			cfg.Pogo.MethodMap = writeMethodMap(t, tc.methods)
+			err := LoadPogoDescriptors(cfg)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("error %v, want it to mention %q", err, tc.wantErr)
+			}
+			// um mapa inválido não instala um registro pela metade
+			if pogoDescriptors.Load() != nil {
+				t.Fatal("registry stored despite the error")
+			}
+		})
+	}
+}
+
+func TestLoadPogoDescriptorsReload(t *testing.T) {
+	withPogoRegistry(t)
+	cfg := testConfig(t)
+	cfg.Pogo.DescriptorSet = writeDescriptorSet(t, "pogov1", "ThingProto")
+	cfg.Pogo.MethodMap = writeMethodMap(t, map[string]PogoMethodTypes{"106": {Request: "pogov1.ThingProto"}})
+	if err := LoadPogoDescriptors(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := DecodePogoPayload(106, nameField("a"), false); !ok {
+		t.Fatal("method 106 not decoded after the first load")
+	}
+
+	// o reload troca os protos e o mapa inteiros
+	cfg.Pogo.DescriptorSet = writeDescriptorSet(t, "pogov2", "OtherProto")
+	cfg.Pogo.MethodMap = writeMethodMap(t, map[string]PogoMethodTypes{"107": {Request: "pogov2.OtherProto"}})
+	if err := LoadPogoDescriptors(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := DecodePogoPayload(106, nameField("a"), false); ok {
+		t.Fatal("method 106 still decoded after reload")
+	}
+	if _, ok := DecodePogoPayload(107, nameField("b"), false); !ok {
+		t.Fatal("method 107 not decoded after reload")
+	}
+
+	// um reload que falha mantém o registro anterior
+	cfg.Pogo.MethodMap = writeMethodMap(t, map[string]PogoMethodTypes{"107": {Request: "pogov2.Missing"}})
+	if err := LoadPogoDescriptors(cfg); err == nil {
+		t.Fatal("reload with an unknown type succeeded")
+	}
+	if _, ok := DecodePogoPayload(107, nameField("b"), false); !ok {
+		t.Fatal("failed reload dropped the previous registry")
+	}
+}
@@ -1,8 +1,120 @@
 package internal
 
+import "time"
+
 type SendItem struct {
     Path    string
     Payload []byte
+
+    // Tag identifica a origem do payload (ex: a fonte do scanner que o leu).
+    Tag string
+
+    // Origin é quem produziu o item (OriginTCP, OriginScanner, OriginControl).
+    Origin string
+    // Priority fixa a faixa no spool; PriorityAuto usa a origem e o método.
+    Priority Priority
+
+    // Part/Parts: posição do registro (1-based) num arquivo com vários
+    // registros; 0 quando o arquivo é um payload só.
+    Part  int
+    Parts int
+
+    // WorkerID fixa o item na partição de um worker (vazio = roteamento automático).
+    WorkerID string
+
+    // OnResult, se definido, recebe a MitmResponse correspondente (ou o erro de
+    // timeout/queda) quando Payload é um MitmRequest com id.
+    OnResult PendingCallback
+
+    // Attempts conta os envios que falharam; LastError é o motivo do último.
+    Attempts  int
+    LastError string
+
+    retryLater int    // quantas vezes o Rotom respondeu ERROR_RETRY_LATER
+    spoolSeq   uint64 // registro no spool (0 = item só em memória)
 }
 
-var SendQueue = make(chan SendItem, 256)
+// sendSpool é a fila persistente de envio; substitui o antigo canal em memória.
+var sendSpool *Spool
+
+// OpenSendSpool abre o spool configurado em cfg.Spool e recupera o que ficou
+// pendente da execução anterior. Deve ser chamado antes de StartDataWs, do
+// scanner e do receptor TCP.
+func OpenSendSpool(cfg Config) (*Spool, error) {
+    s, err := OpenSpool(SpoolOptions{
+        Dir:           cfg.Spool.Dir,
+        MaxBytes:      int64(cfg.Spool.MaxSizeMb) << 20,
+        SegmentBytes:  int64(cfg.Spool.SegmentSizeMb) << 20,
+        Fsync:         FsyncPolicy(cfg.Spool.Fsync),
+        FsyncInterval: time.Duration(cfg.Spool.FsyncIntervalMs) * time.Millisecond,
+        LaneWeights:   cfg.Spool.LaneWeights,
+    })
+    if err != nil {
+        return nil, err
+    }
+    sendSpool = s
+    SetDedupWindow(cfg)
+    // arquivos recuperados já estão no spool: o scanner não deve relê-los, e
+    // os de vários registros só podem ser removidos depois das partes restantes
+    parts := map[string][]int{}
+    for _, m := range s.Metas() {
+        if m.Path == "" {
+            continue
+        }
+        scanClaims.Claim(m.Path)
+        if m.Parts > 1 {
+            parts[m.Path] = append(parts[m.Path], m.Part)
+        }
+    }
+    for path, pending := range parts {
+        captureGroups.Start(path, pending)
+    }
+    return s, nil
+}
+
+// Enqueue grava item no spool de envio. Só depois de um retorno nil o item é
+// responsabilidade do worker; com ErrSpoolFull o produtor deve manter o dado,
+// com ErrDuplicate o mesmo conteúdo já entrou há pouco e com ErrSpoolTooLarge
+// o item nunca vai caber.
+func Enqueue(item SendItem) error {
+    return EnqueueAll([]SendItem{item})
+}
+
+// EnqueueAll grava items no spool de uma vez: ou todos entram, ou nenhum. O
+// conteúdo passa antes pela janela de dedup.
+func EnqueueAll(items []SendItem) error {
+    if sendSpool == nil {
+        return ErrSpoolClosed
+    }
+    if len(items) == 0 {
+        return nil
+    }
+    key := dedupKey(items)
+    if sendDedup.admit(key) {
+        origin := items[0].Origin
+        if origin == "" {
+            origin = "unknown"
+        }
+        metrics.Inc("dedup.duplicates")
+        metrics.Inc("dedup." + origin)
+        return ErrDuplicate
+    }
+    if err := enqueueAgain(items); err != nil {
+        sendDedup.forget(key)
+        return err
+    }
+    return nil
+}
+
+// enqueueAgain grava items sem passar pelo dedup: é para conteúdo que já
+// entrou uma vez (requeue de item confirmado, replay do dead-letter).
+func enqueueAgain(items []SendItem) error {
+    if sendSpool == nil {
+        return ErrSpoolClosed
+    }
+    if len(items) == 1 {
+        _, err := sendSpool.Put(items[0])
+        return err
+    }
+    return sendSpool.PutAll(items)
+}
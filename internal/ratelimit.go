@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimits são os limites do tráfego de saída no /data, somando todos os
+// workers. Zero desliga o limite correspondente.
+type RateLimits struct {
+	MsgsPerSec  float64 `json:"msgs_per_sec"`
+	BytesPerSec float64 `json:"bytes_per_sec"`
+	BurstMsgs   int     `json:"burst_msgs"`
+	BurstBytes  int     `json:"burst_bytes"`
+}
+
+// tokenBucket acumula rate tokens por segundo até burst. Um pedido maior que o
+// saldo deixa o balde negativo: quem pediu espera a dívida ser paga, e os
+// próximos esperam atrás dele.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func (b *tokenBucket) set(rate float64, burst int, now time.Time) {
+	b.rate = rate
+	b.burst = float64(burst)
+	if b.burst <= 0 {
+		// sem burst explícito: um segundo de tráfego
+		b.burst = rate
+	}
+	b.tokens = b.burst
+	b.last = now
+}
+
+// reserve desconta n tokens e devolve quanto esperar antes de usá-los.
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refund devolve n tokens de uma reserva que não foi usada.
+func (b *tokenBucket) refund(n float64) {
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens += n
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// RateLimiter aplica um balde de mensagens e um de bytes.
+type RateLimiter struct {
+	mu      sync.Mutex
+	limits  RateLimits
+	msgs    tokenBucket
+	bytes   tokenBucket
+	changed chan struct{} // fechado (e trocado) a cada Set
+}
+
+// NewRateLimiter cria um limitador com os limites dados.
+func NewRateLimiter(l RateLimits) *RateLimiter {
+	r := &RateLimiter{}
+	r.Set(l)
+	return r
+}
+
+// sendLimiter vale para os envios do pipeline no /data (itens do spool e
+// SendRequest); respostas e rejeições do worker não passam por ele.
+// StartDataWs aplica a configuração e o comando de controle "set_rate" a
+// altera em runtime.
+var sendLimiter = NewRateLimiter(RateLimits{})
+
+// Set troca os limites; os baldes recomeçam cheios e quem estava em Wait
+// reserva de novo com os limites novos.
+func (r *RateLimiter) Set(l RateLimits) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits = l
+	r.msgs.set(l.MsgsPerSec, l.BurstMsgs, now)
+	r.bytes.set(l.BytesPerSec, l.BurstBytes, now)
+	if r.changed != nil {
+		close(r.changed)
+	}
+	r.changed = make(chan struct{})
+}
+
+// Limits devolve os limites atuais.
+func (r *RateLimiter) Limits() RateLimits {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.limits
+}
+
+// Wait bloqueia até uma mensagem de n bytes caber nos limites. Retorna o erro
+// de ctx se ele for cancelado antes, devolvendo o que tinha reservado.
+func (r *RateLimiter) Wait(ctx context.Context, n int) error {
+	throttled := false
+	for {
+		now := time.Now()
+		r.mu.Lock()
+		d := r.msgs.reserve(1, now)
+		if bd := r.bytes.reserve(float64(n), now); bd > d {
+			d = bd
+		}
+		changed := r.changed
+		r.mu.Unlock()
+		if d <= 0 {
+			return nil
+		}
+		if !throttled {
+			throttled = true
+			metrics.Inc("rate.throttled")
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+			return nil
+		case <-changed:
+			// limites novos: a reserva antiga não vale mais
+			t.Stop()
+		case <-ctx.Done():
+			t.Stop()
+			// o envio desistiu: a reserva volta para os próximos (depois de
+			// um Set os baldes já recomeçaram e não há o que devolver)
+			r.mu.Lock()
+			if r.changed == changed {
+				r.msgs.refund(1)
+				r.bytes.refund(float64(n))
+			}
+			r.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// rateLimitsFromConfig lê tuning.rate_*.
+func rateLimitsFromConfig(cfg Config) RateLimits {
+	return RateLimits{
+		MsgsPerSec:  cfg.Tuning.RateMsgsPerSec,
+		BytesPerSec: cfg.Tuning.RateBytesPerSec,
+		BurstMsgs:   cfg.Tuning.RateBurstMsgs,
+		BurstBytes:  cfg.Tuning.RateBurstBytes,
+	}
+}
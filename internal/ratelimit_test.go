@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWaitHonorsContext(t *testing.T) {
+	r := NewRateLimiter(RateLimits{MsgsPerSec: 0.1, BurstMsgs: 1})
+	if err := r.Wait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// a próxima mensagem só caberia em 10s
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := r.Wait(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait returned %v, want the context error", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Wait took %s after the context expired", d)
+	}
+}
+
+func TestRateLimiterSetWakesWaiters(t *testing.T) {
+	r := NewRateLimiter(RateLimits{MsgsPerSec: 0.1, BurstMsgs: 1})
+	if err := r.Wait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- r.Wait(context.Background(), 1) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// tirar o limite libera quem reservou com o limite antigo
+	r.Set(RateLimits{})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter not woken after the limit was removed")
+	}
+}
+
+func TestRateLimiterCancelledWaitRefunds(t *testing.T) {
+	// taxa baixa o bastante para o balde quase não encher durante o teste
+	r := NewRateLimiter(RateLimits{MsgsPerSec: 0.01, BurstMsgs: 1, BytesPerSec: 1, BurstBytes: 100})
+	if err := r.Wait(context.Background(), 100); err != nil {
+		t.Fatal(err)
+	}
+	r.mu.Lock()
+	msgs, bytes := r.msgs.tokens, r.bytes.tokens
+	r.mu.Unlock()
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := r.Wait(ctx, 50)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Wait %d returned %v, want the context error", i, err)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// só o que o tempo encheu pode ter mudado o saldo
+	if d := r.msgs.tokens - msgs; d < 0 || d > 0.01 {
+		t.Fatalf("msg bucket moved by %.3f after cancelled waits", d)
+	}
+	if d := r.bytes.tokens - bytes; d < 0 || d > 1 {
+		t.Fatalf("byte bucket moved by %.3f after cancelled waits", d)
+	}
+}
+
+func TestRateLimiterCancelledWaitDoesNotDelayNextSend(t *testing.T) {
+	r := NewRateLimiter(RateLimits{MsgsPerSec: 10, BurstMsgs: 1})
+	if err := r.Wait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := r.Wait(ctx, 1); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Wait %d returned %v, want context.Canceled", i, err)
+		}
+	}
+
+	// sem devolução seriam 6 mensagens na frente (~600ms); com ela só a primeira
+	start := time.Now()
+	if err := r.Wait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 300*time.Millisecond {
+		t.Fatalf("next send waited %s behind cancelled reservations", d)
+	}
+}
@@ -0,0 +1,506 @@
+// Package rotomtest implementa um Rotom falso para testes de integração do
+// rotom-worker: serve /control e /data por websocket, valida o secret (Bearer),
+// registra WelcomeMessages, frames de controle e MitmRequests, e responde de
+// acordo com um script (status escolhido, atraso, queda da conexão).
+//
+// Uso típico num teste:
+//
+//	srv := rotomtest.Start("secret")
+//	defer srv.Close()
+//	cfg := internal.ReadConfig("")
+//	srv.ApplyTo(&cfg)
+//	srv.Respond(func(req *rotompb.MitmRequest) rotomtest.Action {
+//		return rotomtest.Action{Status: rotompb.MitmResponse_ERROR_RETRY_LATER}
+//	})
+//	internal.StartDataWs(ctx, cfg)
+//	w, err := srv.WaitWelcome("", 5*time.Second)
+package rotomtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+
+	"rotomworker/internal"
+	rotompb "rotomworker/proto_gen"
+)
+
+// Action é o que o servidor faz com um MitmRequest recebido em /data.
+type Action struct {
+	// Status da MitmResponse enviada (UNSET = não responde).
+	Status rotompb.MitmResponse_Status
+	// Response substitui a resposta montada a partir de Status (o id é
+	// sempre o do request).
+	Response *rotompb.MitmResponse
+	// Delay atrasa a resposta (e o ack).
+	Delay time.Duration
+	// Drop derruba a conexão /data em vez de responder.
+	Drop bool
+	// Ack envia um frame de ack em texto ({"ack": id}), para rotom.ack_mode "frame".
+	Ack bool
+}
+
+// Responder decide a Action de cada request. Roda no reader da conexão.
+type Responder func(req *rotompb.MitmRequest) Action
+
+// Reply responde tudo com status (LOGIN com SUCCESS leva um LoginResponse).
+func Reply(status rotompb.MitmResponse_Status) Responder {
+	return func(*rotompb.MitmRequest) Action { return Action{Status: status} }
+}
+
+// Server é o Rotom falso. Ele implementa http.Handler; Start sobe um listener
+// local, ou use o Server direto em http.ListenAndServe.
+type Server struct {
+	// Secret esperado no header Authorization ("Bearer <secret>"); vazio aceita tudo.
+	Secret string
+	// Logf, se definido, recebe o log do servidor.
+	Logf func(format string, args ...any)
+
+	mux      *http.ServeMux
+	upgrader websocket.Upgrader
+	httpSrv  *httptest.Server
+
+	mu        sync.Mutex
+	changed   chan struct{}
+	responder Responder
+	welcomes  []*rotompb.WelcomeMessage
+	requests  []*rotompb.MitmRequest
+	responses []*rotompb.MitmResponse
+	control   []map[string]any
+	rejected  int
+	data      map[string]*peer // por worker_id (do WelcomeMessage)
+	controls  map[*peer]struct{}
+}
+
+// peer é uma conexão websocket com escrita serializada.
+type peer struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+}
+
+func (p *peer) write(mt int, b []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	_ = p.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	return p.conn.WriteMessage(mt, b)
+}
+
+// New cria o servidor sem abrir porta. Por padrão responde SUCCESS a tudo.
+func New(secret string) *Server {
+	s := &Server{
+		Secret:    secret,
+		mux:       http.NewServeMux(),
+		changed:   make(chan struct{}),
+		responder: Reply(rotompb.MitmResponse_SUCCESS),
+		data:      map[string]*peer{},
+		controls:  map[*peer]struct{}{},
+	}
+	s.mux.HandleFunc("/control", s.serveControl)
+	s.mux.HandleFunc("/data", s.serveData)
+	return s
+}
+
+// Start cria o servidor e o sobe num listener local (127.0.0.1, porta livre).
+func Start(secret string) *Server {
+	s := New(secret)
+	s.httpSrv = httptest.NewServer(s)
+	return s
+}
+
+// Close derruba as conexões e o listener de Start.
+func (s *Server) Close() {
+	s.mu.Lock()
+	var peers []*peer
+	for _, p := range s.data {
+		peers = append(peers, p)
+	}
+	for p := range s.controls {
+		peers = append(peers, p)
+	}
+	s.mu.Unlock()
+	for _, p := range peers {
+		_ = p.conn.Close()
+	}
+	if s.httpSrv != nil {
+		s.httpSrv.Close()
+	}
+}
+
+// ServeHTTP implementa http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// URL é o endereço base (ws://host:port) do listener de Start.
+func (s *Server) URL() string {
+	if s.httpSrv == nil {
+		return ""
+	}
+	return "ws" + strings.TrimPrefix(s.httpSrv.URL, "http")
+}
+
+// ApplyTo aponta cfg para este servidor (worker_endpoint, device_endpoint e secret).
+func (s *Server) ApplyTo(cfg *internal.Config) {
+	cfg.Rotom.WorkerEndpoint = s.URL()
+	cfg.Rotom.DeviceEndpoint = s.URL() + "/control"
+	cfg.Rotom.Secret = s.Secret
+}
+
+// Respond troca o script de respostas.
+func (s *Server) Respond(r Responder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responder = r
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// notifyLocked acorda quem está em Wait*. Chamar com s.mu.
+func (s *Server) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// waitFor espera cond (avaliada com s.mu) ficar verdadeira.
+func (s *Server) waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.mu.Lock()
+		ok := cond()
+		changed := s.changed
+		s.mu.Unlock()
+		if ok {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return false
+		}
+	}
+}
+
+// authorized valida o header Authorization.
+func (s *Server) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if s.Secret == "" || r.Header.Get("Authorization") == "Bearer "+s.Secret {
+		return true
+	}
+	s.mu.Lock()
+	s.rejected++
+	s.notifyLocked()
+	s.mu.Unlock()
+	s.logf("[mockrotom] %s %s: bad secret", r.RemoteAddr, r.URL.Path)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+func (s *Server) serveControl(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	p := &peer{conn: conn}
+	s.mu.Lock()
+	s.controls[p] = struct{}{}
+	s.mu.Unlock()
+	s.logf("[mockrotom] control connected from %s", r.RemoteAddr)
+	defer func() {
+		s.mu.Lock()
+		delete(s.controls, p)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var m map[string]any
+		if err := json.Unmarshal(msg, &m); err != nil {
+			m = map[string]any{"raw": string(msg)}
+		}
+		s.mu.Lock()
+		s.control = append(s.control, m)
+		s.notifyLocked()
+		s.mu.Unlock()
+	}
+}
+
+func (s *Server) serveData(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(w, r) {
+		return
+	}
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	p := &peer{conn: conn}
+	defer conn.Close()
+
+	// o primeiro frame é sempre o WelcomeMessage
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		return
+	}
+	var welcome rotompb.WelcomeMessage
+	if err := proto.Unmarshal(msg, &welcome); err != nil || welcome.GetWorkerId() == "" {
+		s.logf("[mockrotom] data %s: first frame is not a WelcomeMessage", r.RemoteAddr)
+		return
+	}
+	id := welcome.GetWorkerId()
+	s.mu.Lock()
+	s.welcomes = append(s.welcomes, &welcome)
+	s.data[id] = p
+	s.notifyLocked()
+	s.mu.Unlock()
+	s.logf("[mockrotom] data connected: worker=%s device=%s", id, welcome.GetDeviceId())
+	defer func() {
+		s.mu.Lock()
+		if s.data[id] == p {
+			delete(s.data, id)
+		}
+		s.mu.Unlock()
+	}()
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if resp, ok := decodeResponse(msg); ok {
+			s.mu.Lock()
+			s.responses = append(s.responses, resp)
+			s.notifyLocked()
+			s.mu.Unlock()
+			continue
+		}
+		var req rotompb.MitmRequest
+		if err := proto.Unmarshal(msg, &req); err != nil {
+			s.logf("[mockrotom] worker=%s: undecodable frame (%d bytes)", id, len(msg))
+			continue
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, &req)
+		responder := s.responder
+		s.notifyLocked()
+		s.mu.Unlock()
+
+		act := responder(&req)
+		if act.Drop {
+			s.logf("[mockrotom] worker=%s: dropping connection on request id=%d", id, req.GetId())
+			return
+		}
+		if act.Delay > 0 {
+			// atrasa só esta resposta; o reader segue recebendo
+			go s.reply(p, id, &req, act)
+			continue
+		}
+		if err := s.reply(p, id, &req, act); err != nil {
+			return
+		}
+	}
+}
+
+// reply executa act (atraso, ack, resposta) para req na conexão p.
+func (s *Server) reply(p *peer, workerID string, req *rotompb.MitmRequest, act Action) error {
+	if act.Delay > 0 {
+		time.Sleep(act.Delay)
+	}
+	if act.Ack {
+		_ = p.write(websocket.TextMessage, []byte(fmt.Sprintf(`{"ack":%d}`, req.GetId())))
+	}
+	out := buildResponse(req, act)
+	if out == nil {
+		return nil
+	}
+	b, err := proto.Marshal(out)
+	if err == nil {
+		err = p.write(websocket.BinaryMessage, b)
+	}
+	if err != nil {
+		s.logf("[mockrotom] worker=%s: write response id=%d: %v", workerID, req.GetId(), err)
+	}
+	return err
+}
+
+// decodeResponse reconhece uma MitmResponse (resposta do worker a um request
+// nosso) pelo status conhecido no campo 2.
+func decodeResponse(msg []byte) (*rotompb.MitmResponse, bool) {
+	var resp rotompb.MitmResponse
+	if err := proto.Unmarshal(msg, &resp); err != nil || resp.GetStatus() == rotompb.MitmResponse_UNSET {
+		return nil, false
+	}
+	if _, known := rotompb.MitmResponse_Status_name[int32(resp.GetStatus())]; !known {
+		return nil, false
+	}
+	return &resp, true
+}
+
+// buildResponse monta a resposta de act para req (nil = não responde).
+func buildResponse(req *rotompb.MitmRequest, act Action) *rotompb.MitmResponse {
+	if act.Response != nil {
+		out := proto.Clone(act.Response).(*rotompb.MitmResponse)
+		out.Id = req.GetId()
+		return out
+	}
+	if act.Status == rotompb.MitmResponse_UNSET {
+		return nil
+	}
+	out := &rotompb.MitmResponse{Id: req.GetId(), Status: act.Status}
+	if req.GetMethod() == rotompb.MitmRequest_LOGIN && act.Status == rotompb.MitmResponse_SUCCESS {
+		out.Payload = &rotompb.MitmResponse_LoginResponse_{LoginResponse: &rotompb.MitmResponse_LoginResponse{
+			WorkerId:  req.GetLoginRequest().GetWorkerId(),
+			Status:    rotompb.AuthStatus_AUTH_STATUS_GOT_AUTH_TOKEN,
+			Useragent: "mockrotom",
+		}}
+	}
+	return out
+}
+
+// Welcomes devolve os WelcomeMessages recebidos, em ordem.
+func (s *Server) Welcomes() []*rotompb.WelcomeMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*rotompb.WelcomeMessage(nil), s.welcomes...)
+}
+
+// Requests devolve os MitmRequests recebidos em /data, em ordem.
+func (s *Server) Requests() []*rotompb.MitmRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*rotompb.MitmRequest(nil), s.requests...)
+}
+
+// Responses devolve as MitmResponses que os workers mandaram (respostas a SendRequest).
+func (s *Server) Responses() []*rotompb.MitmResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*rotompb.MitmResponse(nil), s.responses...)
+}
+
+// ControlMessages devolve os frames JSON recebidos em /control (intro, heartbeats, eventos).
+func (s *Server) ControlMessages() []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]any(nil), s.control...)
+}
+
+// Rejected conta as conexões recusadas por secret inválido.
+func (s *Server) Rejected() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rejected
+}
+
+// WaitWelcome espera o WelcomeMessage de workerID ("" = qualquer worker).
+func (s *Server) WaitWelcome(workerID string, timeout time.Duration) (*rotompb.WelcomeMessage, error) {
+	var found *rotompb.WelcomeMessage
+	ok := s.waitFor(timeout, func() bool {
+		for _, w := range s.welcomes {
+			if workerID == "" || w.GetWorkerId() == workerID {
+				found = w
+				return true
+			}
+		}
+		return false
+	})
+	if !ok {
+		return nil, fmt.Errorf("no WelcomeMessage from %q after %s", workerID, timeout)
+	}
+	return found, nil
+}
+
+// WaitRequests espera até n MitmRequests terem chegado e os devolve.
+func (s *Server) WaitRequests(n int, timeout time.Duration) ([]*rotompb.MitmRequest, error) {
+	if !s.waitFor(timeout, func() bool { return len(s.requests) >= n }) {
+		got := s.Requests()
+		return got, fmt.Errorf("got %d of %d request(s) after %s", len(got), n, timeout)
+	}
+	return s.Requests(), nil
+}
+
+// WaitControl espera um frame de controle com "type" igual a typ.
+func (s *Server) WaitControl(typ string, timeout time.Duration) (map[string]any, error) {
+	var found map[string]any
+	ok := s.waitFor(timeout, func() bool {
+		for _, m := range s.control {
+			if m["type"] == typ {
+				found = m
+				return true
+			}
+		}
+		return false
+	})
+	if !ok {
+		return nil, fmt.Errorf("no %q control message after %s", typ, timeout)
+	}
+	return found, nil
+}
+
+// SendRequest manda req (do "Rotom") ao worker workerID pelo /data.
+func (s *Server) SendRequest(workerID string, req *rotompb.MitmRequest) error {
+	s.mu.Lock()
+	p := s.data[workerID]
+	s.mu.Unlock()
+	if p == nil {
+		return fmt.Errorf("worker %q is not connected", workerID)
+	}
+	b, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return p.write(websocket.BinaryMessage, b)
+}
+
+// SendControl manda um comando JSON (ex: {"cmd": "status"}) a todas as
+// conexões /control.
+func (s *Server) SendControl(cmd map[string]any) error {
+	b, err := json.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	var peers []*peer
+	for p := range s.controls {
+		peers = append(peers, p)
+	}
+	s.mu.Unlock()
+	if len(peers) == 0 {
+		return errors.New("no control connection")
+	}
+	for _, p := range peers {
+		if err := p.write(websocket.TextMessage, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropData derruba a conexão /data de workerID (o worker deve reconectar).
+func (s *Server) DropData(workerID string) bool {
+	s.mu.Lock()
+	p := s.data[workerID]
+	s.mu.Unlock()
+	if p == nil {
+		return false
+	}
+	_ = p.conn.Close()
+	return true
+}
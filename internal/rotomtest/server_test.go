@@ -0,0 +1,254 @@
+package rotomtest
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"rotomworker/internal"
+	rotompb "rotomworker/proto_gen"
+)
+
+const waitTimeout = 10 * time.Second
+
+// testConfig monta a config de um worker apontado para srv, com spool, scan e
+// dead-letter em diretórios temporários. Passa por ReadConfig para receber o
+// mesmo saneamento de um arquivo de verdade.
+func testConfig(t *testing.T, srv *Server, tuning map[string]any) internal.Config {
+	t.Helper()
+	raw := map[string]any{
+		"rotom": map[string]any{
+			"worker_endpoint": srv.URL(),
+			"device_endpoint": srv.URL() + "/control",
+			"secret":          srv.Secret,
+			"ack_mode":        "response",
+		},
+		"general": map[string]any{
+			"device_name":     "e2e",
+			"workers":         1,
+			"scan_dir":        t.TempDir(),
+			"quarantine_dir":  t.TempDir(),
+			"dead_letter_dir": t.TempDir(),
+		},
+		"spool": map[string]any{"dir": t.TempDir(), "fsync": "never"},
+		"tuning": map[string]any{
+			"worker_spawn_delay_ms": 1,
+			"retry_backoff_ms":      []int{50},
+		},
+	}
+	for k, v := range tuning {
+		raw["tuning"].(map[string]any)[k] = v
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return internal.ReadConfig(path)
+}
+
+// startWorkers abre o spool e sobe os workers /data de cfg até o fim do teste.
+func startWorkers(t *testing.T, cfg internal.Config) (context.Context, *internal.Spool) {
+	t.Helper()
+	spool, err := internal.OpenSendSpool(cfg)
+	if err != nil {
+		t.Fatalf("OpenSendSpool: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		_ = spool.Close()
+	})
+	internal.StartDataWs(ctx, cfg)
+	return ctx, spool
+}
+
+// rpcRequest é um MitmRequest RPC_REQUEST com id e um payload qualquer.
+func rpcRequest(t *testing.T, id uint32) []byte {
+	t.Helper()
+	b, err := proto.Marshal(&rotompb.MitmRequest{
+		Id:     id,
+		Method: rotompb.MitmRequest_RPC_REQUEST,
+		Payload: &rotompb.MitmRequest_RpcRequest_{RpcRequest: &rotompb.MitmRequest_RpcRequest{
+			Request: []*rotompb.MitmRequest_RpcRequest_SingleRpcRequest{{Method: 106, Payload: []byte("e2e")}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// waitDrained espera o spool ficar vazio (tudo confirmado).
+func waitDrained(t *testing.T, spool *internal.Spool) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for time.Now().Before(deadline) {
+		if st := spool.Stats(); st.Ready+st.InFlight == 0 {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("spool not drained: %+v", spool.Stats())
+}
+
+// waitWelcomes espera n WelcomeMessages.
+func waitWelcomes(t *testing.T, srv *Server, n int) {
+	t.Helper()
+	if !srv.waitFor(waitTimeout, func() bool { return len(srv.welcomes) >= n }) {
+		t.Fatalf("got %d of %d WelcomeMessage(s)", len(srv.Welcomes()), n)
+	}
+}
+
+// sameID confirma que todos os requests são retransmissões do mesmo id.
+func sameID(t *testing.T, reqs []*rotompb.MitmRequest, id uint32) {
+	t.Helper()
+	for i, r := range reqs {
+		if r.GetId() != id {
+			t.Fatalf("request %d has id=%d, want %d", i, r.GetId(), id)
+		}
+	}
+}
+
+func TestWelcomeAndSecret(t *testing.T) {
+	srv := Start("s3cret")
+	defer srv.Close()
+
+	// secret errado: o upgrade é recusado e nenhum Welcome chega
+	bad := testConfig(t, srv, nil)
+	bad.Rotom.Secret = "wrong"
+	bad.General.DeviceName = "intruder"
+	startWorkers(t, bad)
+	if !srv.waitFor(waitTimeout, func() bool { return srv.rejected > 0 }) {
+		t.Fatal("connection with a bad secret was not rejected")
+	}
+	if n := len(srv.Welcomes()); n != 0 {
+		t.Fatalf("got %d WelcomeMessage(s) with a bad secret", n)
+	}
+
+	good := testConfig(t, srv, nil)
+	startWorkers(t, good)
+	id := internal.WorkerID(good, 1)
+	w, err := srv.WaitWelcome(id, waitTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.GetWorkerId() != id {
+		t.Fatalf("welcome worker_id %q, want %q", w.GetWorkerId(), id)
+	}
+	for _, w := range srv.Welcomes() {
+		if w.GetWorkerId() == internal.WorkerID(bad, 1) {
+			t.Fatal("worker with a bad secret got through")
+		}
+	}
+}
+
+func TestRetryLaterResends(t *testing.T) {
+	srv := Start("s3cret")
+	defer srv.Close()
+	var n atomic.Int32
+	srv.Respond(func(req *rotompb.MitmRequest) Action {
+		if n.Add(1) == 1 {
+			return Action{Status: rotompb.MitmResponse_ERROR_RETRY_LATER}
+		}
+		return Action{Status: rotompb.MitmResponse_SUCCESS}
+	})
+	cfg := testConfig(t, srv, nil)
+	_, spool := startWorkers(t, cfg)
+
+	if err := internal.Enqueue(internal.SendItem{Payload: rpcRequest(t, 7001)}); err != nil {
+		t.Fatal(err)
+	}
+	reqs, err := srv.WaitRequests(2, waitTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameID(t, reqs, 7001)
+	waitDrained(t, spool)
+
+	// RETRY_LATER não conta como falha: o item não foi para o dead-letter
+	if entries, _ := os.ReadDir(cfg.General.DeadLetterDir); len(entries) != 0 {
+		t.Fatalf("dead-letter has %d file(s) after RETRY_LATER", len(entries))
+	}
+}
+
+func TestReconnectAndDropRedial(t *testing.T) {
+	srv := Start("s3cret")
+	defer srv.Close()
+	var n atomic.Int32
+	srv.Respond(func(req *rotompb.MitmRequest) Action {
+		switch n.Add(1) {
+		case 1:
+			return Action{Drop: true}
+		case 2:
+			return Action{Status: rotompb.MitmResponse_ERROR_RECONNECT}
+		}
+		return Action{Status: rotompb.MitmResponse_SUCCESS}
+	})
+	cfg := testConfig(t, srv, nil)
+	_, spool := startWorkers(t, cfg)
+
+	if err := internal.Enqueue(internal.SendItem{Payload: rpcRequest(t, 7002)}); err != nil {
+		t.Fatal(err)
+	}
+	// queda e ERROR_RECONNECT: cada um gera uma conexão (e um Welcome) nova, e
+	// o item é reenviado nela
+	reqs, err := srv.WaitRequests(3, waitTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameID(t, reqs, 7002)
+	waitWelcomes(t, srv, 3)
+	waitDrained(t, spool)
+}
+
+func TestWorkerStoppedWaitsForResume(t *testing.T) {
+	srv := Start("s3cret")
+	defer srv.Close()
+	var n atomic.Int32
+	srv.Respond(func(req *rotompb.MitmRequest) Action {
+		if n.Add(1) == 1 {
+			return Action{Status: rotompb.MitmResponse_ERROR_WORKER_STOPPED}
+		}
+		return Action{Status: rotompb.MitmResponse_SUCCESS}
+	})
+	cfg := testConfig(t, srv, nil)
+	ctx, spool := startWorkers(t, cfg)
+	go internal.ControlLoop(ctx, cfg)
+
+	if err := internal.Enqueue(internal.SendItem{Payload: rpcRequest(t, 7003)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.WaitRequests(1, waitTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// pausado: nada é reenviado até o resume
+	if reqs, err := srv.WaitRequests(2, time.Second); err == nil {
+		t.Fatalf("worker sent %d request(s) while stopped", len(reqs))
+	}
+	if !srv.waitFor(waitTimeout, func() bool { return len(srv.controls) > 0 }) {
+		t.Fatal("control channel did not connect")
+	}
+	if err := srv.SendControl(map[string]any{"cmd": "resume"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// o resume refaz a sessão (Welcome novo) e o item sai de novo
+	waitWelcomes(t, srv, 2)
+	reqs, err := srv.WaitRequests(2, waitTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameID(t, reqs, 7003)
+	waitDrained(t, spool)
+}
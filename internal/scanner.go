@@ -5,48 +5,336 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
-func ScannerLoop(ctxCtx context.Context, scanDir string) {
-    logger := NewLogger()
-    if scanDir == "" {
-        scanDir = "/data/local/tmp/rotom_inbox"
-    }
-    if _, err := os.Stat(scanDir); os.IsNotExist(err) {
-        _ = os.MkdirAll(scanDir, 0755)
-    }
-
-    ticker := time.NewTicker(3 * time.Second)
-    defer ticker.Stop()
-
-    for {
-        select {
-        case <-ctxCtx.Done():
-            logger.Info("[scanner] exiting")
-            return
-        case <-ticker.C:
-            files, err := ioutil.ReadDir(scanDir)
-            if err != nil {
-                logger.Errorf("[scanner] readdir: %v", err)
-                continue
-            }
-            for _, f := range files {
-                if !f.Mode().IsRegular() {
-                    continue
-                }
-                if f.Size() < 16 {
-                    continue
-                }
-                path := filepath.Join(scanDir, f.Name())
-                b, err := ioutil.ReadFile(path)
-                if err != nil {
-                    logger.Errorf("[scanner] read %s: %v", path, err)
-                    continue
-                }
-                SendQueue <- SendItem{Path: path, Payload: b}
-                logger.Infof("[scanner] enqueued %s", path)
-            }
-        }
-    }
+// Modos do scanner (general.scan_mode).
+const (
+	ScanModeAuto    = "auto"    // inotify quando disponível, senão polling
+	ScanModeInotify = "inotify" // inotify; se falhar, avisa e cai para polling
+	ScanModePoll    = "poll"    // só polling
+)
+
+// inotifyResync é o intervalo da varredura de segurança no modo inotify.
+const inotifyResync = 60 * time.Second
+
+// watchEvent é um aviso do dirWatcher. Path vazio pede uma varredura
+// completa; Moved indica IN_MOVED_TO, quando o arquivo chegou inteiro por
+// rename. Um IN_CLOSE_WRITE não garante isso (o produtor pode reabrir o
+// arquivo), então passa pela checagem de estabilidade.
+type watchEvent struct {
+	Path  string
+	Moved bool
+}
+
+var (
+	scannersMu sync.Mutex
+	scanners   []*dirScanner
+)
+
+// kickScanner pede uma varredura imediata de todas as fontes, sem bloquear
+// (ex: um claim foi liberado e o arquivo não vai gerar evento novo).
+func kickScanner() {
+	scannersMu.Lock()
+	defer scannersMu.Unlock()
+	for _, sc := range scanners {
+		select {
+		case sc.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ScannerLoop varre cada fonte de general.scan_sources (ou o scan_dir) até
+// ctx acabar.
+func ScannerLoop(ctxCtx context.Context, cfg Config) {
+	sources := cfg.General.ScanSources
+	if len(sources) == 0 {
+		// config sem sanitize (defaults): só o scan_dir, como antes
+		sources = []ScanSource{{Dir: cfg.General.ScanDir, MinSize: 16}}
+	}
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		sc := newDirScanner(cfg, src)
+		if _, err := os.Stat(sc.dir); os.IsNotExist(err) {
+			_ = os.MkdirAll(sc.dir, 0755)
+		}
+		scannersMu.Lock()
+		scanners = append(scanners, sc)
+		scannersMu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sc.run(ctxCtx, cfg)
+		}()
+	}
+	wg.Wait()
+	NewLogger().Info("[scanner] exiting")
+}
+
+// dirScanner lê uma fonte e oferece ao spool os arquivos estáveis que passam
+// pelos filtros dela.
+type dirScanner struct {
+	dir        string
+	src        ScanSource
+	stable     *stabilityCheck
+	quarantine string
+	kick       chan struct{}
+}
+
+func newDirScanner(cfg Config, src ScanSource) *dirScanner {
+	return &dirScanner{
+		dir:        filepath.Clean(src.Dir),
+		src:        src,
+		stable:     newStabilityCheck(cfg),
+		quarantine: cfg.General.QuarantineDir,
+		kick:       make(chan struct{}, 1),
+	}
+}
+
+func (sc *dirScanner) run(ctx context.Context, cfg Config) {
+	logger := NewLogger()
+	interval := time.Duration(cfg.General.ScanIntervalMs) * time.Millisecond
+
+	if cfg.General.ScanMode != ScanModePoll {
+		w, err := newDirWatcher(sc.dir)
+		if err == nil {
+			logger.Infof("[scanner] watching %s with inotify", sc.dir)
+			if !sc.watchLoop(ctx, w) {
+				return
+			}
+		} else if cfg.General.ScanMode == ScanModeInotify {
+			logger.Warnf("[scanner] inotify unavailable for %s: %v; falling back to polling", sc.dir, err)
+		} else {
+			logger.Infof("[scanner] inotify unavailable for %s (%v); polling", sc.dir, err)
+		}
+		metrics.Inc("scanner.poll_fallback")
+	}
+
+	logger.Infof("[scanner] polling %s every %s", sc.dir, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sc.scanPass(nil)
+		case <-sc.kick:
+			sc.scanPass(nil)
+		}
+	}
+}
+
+// watchLoop consome os eventos do inotify até ctx acabar (retorna false) ou o
+// watch falhar (retorna true: o chamador continua com polling).
+func (sc *dirScanner) watchLoop(ctx context.Context, w *dirWatcher) bool {
+	logger := NewLogger()
+	defer w.Close()
+
+	// arquivos que já estavam lá antes do watch não geram evento; a varredura
+	// também coloca watch nos subdiretórios dentro de depth
+	sc.scanPass(w)
+	resync := time.NewTicker(inotifyResync)
+	defer resync.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case ev, ok := <-w.Events:
+			if !ok {
+				logger.Warnf("[scanner] inotify watch on %s stopped: %v; falling back to polling", sc.dir, <-w.Err)
+				return true
+			}
+			metrics.Inc("scanner.inotify_events")
+			if ev.Path == "" {
+				sc.scanPass(w)
+				continue
+			}
+			info, err := os.Stat(ev.Path)
+			if err != nil {
+				continue
+			}
+			if info.IsDir() {
+				// subdiretório novo (ou movido para cá): watch + varredura
+				sc.scanPass(w)
+				continue
+			}
+			// só o rename garante que o produtor terminou; depois de um
+			// IN_CLOSE_WRITE o arquivo ainda precisa ficar estável
+			sc.offerFile(ev.Path, info, ev.Moved)
+		case <-resync.C:
+			sc.scanPass(w)
+		case <-sc.kick:
+			sc.scanPass(w)
+		}
+	}
+}
+
+// scanPass percorre a fonte até src.depth e oferece cada arquivo ao spool.
+// Com w != nil, os subdiretórios visitados passam a ser observados.
+func (sc *dirScanner) scanPass(w *dirWatcher) {
+	present := map[string]bool{}
+	sc.walk(sc.dir, 0, w, present)
+	sc.stable.Prune(present)
+}
+
+// walk retorna false quando o spool não aceita mais itens.
+func (sc *dirScanner) walk(dir string, depth int, w *dirWatcher, present map[string]bool) bool {
+	logger := NewLogger()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logger.Errorf("[scanner] readdir: %v", err)
+		return true
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.Name())
+		if f.IsDir() {
+			if sc.src.Depth >= 0 && depth >= sc.src.Depth {
+				continue
+			}
+			if w != nil && dir != path {
+				if err := w.Add(path); err != nil {
+					logger.Warnf("[scanner] cannot watch %s: %v", path, err)
+				}
+			}
+			if !sc.walk(path, depth+1, w, present) {
+				return false
+			}
+			continue
+		}
+		present[path] = true
+		if !sc.offerFile(path, f, false) {
+			return false
+		}
+	}
+	return true
+}
+
+// accepts aplica os filtros da fonte (tamanho, include e exclude) a path.
+func (sc *dirScanner) accepts(path string, f os.FileInfo) bool {
+	if f.Size() < sc.src.MinSize {
+		return false
+	}
+	if sc.src.MaxSize > 0 && f.Size() > sc.src.MaxSize {
+		return false
+	}
+	rel, err := filepath.Rel(sc.dir, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if len(sc.src.Include) > 0 && !matchAny(sc.src.Include, rel) {
+		return false
+	}
+	return !matchAny(sc.src.Exclude, rel)
+}
+
+// matchAny compara rel (caminho relativo à fonte, com "/") com os globs. Um
+// glob sem "/" vale para o nome do arquivo em qualquer nível.
+func matchAny(globs []string, rel string) bool {
+	base := rel[strings.LastIndex(rel, "/")+1:]
+	for _, g := range globs {
+		target := rel
+		if !strings.Contains(g, "/") {
+			target = base
+		}
+		if ok, _ := filepath.Match(g, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// offerFile toma posse de path e o grava no spool. complete indica que o
+// arquivo com certeza já foi fechado pelo produtor. Retorna false quando o
+// spool não aceita mais itens e a passada deve parar.
+func (sc *dirScanner) offerFile(path string, f os.FileInfo, complete bool) bool {
+	logger := NewLogger()
+	if !f.Mode().IsRegular() {
+		return true
+	}
+	if !sc.accepts(path, f) {
+		return true
+	}
+	if IsInFlight(path) || scanClaims.Owned(path) {
+		// já está na fila, em envio ou aguardando ack do Rotom
+		return true
+	}
+	if !sc.stable.Ready(path, f, complete) {
+		// ainda sendo escrito
+		return true
+	}
+	history := SendItem{Path: path}
+	if restoreAttempts(&history) {
+		// falhou há pouco: espera o backoff (failItem agenda a próxima passada)
+		return true
+	}
+	if !scanClaims.Claim(path) {
+		return true
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		logger.Errorf("[scanner] read %s: %v", path, err)
+		scanClaims.Release(path)
+		return true
+	}
+	if int64(len(b)) != f.Size() {
+		// mudou entre o stat e a leitura: espera estabilizar de novo
+		scanClaims.Release(path)
+		kickScanner()
+		return true
+	}
+	capture, err := sniffCapture(b)
+	if err != nil {
+		if quarantineFile(sc.quarantine, path, err) {
+			scanClaims.Release(path)
+		}
+		// se não deu para mover, o claim fica: o arquivo não é relido até o restart
+		return true
+	}
+	metrics.Inc("capture.format." + string(capture.Format))
+
+	items := captureItems(path, sc.src.Tag, capture)
+	for i := range items {
+		items[i].Attempts, items[i].LastError = history.Attempts, history.LastError
+		items[i].Origin = OriginScanner
+	}
+	if len(items) > 1 {
+		// registrado antes do spool: uma parte pode ser entregue logo
+		parts := make([]int, len(items))
+		for i := range items {
+			parts[i] = items[i].Part
+		}
+		captureGroups.Start(path, parts)
+	}
+	if err := EnqueueAll(items); err != nil {
+		captureGroups.Drop(path)
+		if err == ErrDuplicate {
+			// mesma captura já está na fila ou foi enviada há pouco
+			logger.Infof("[scanner] %s is a duplicate; removing", path)
+			_ = os.Remove(path)
+			scanClaims.Done(path)
+			forgetAttempts(path)
+			return true
+		}
+		if err == ErrSpoolTooLarge {
+			// nunca vai caber num registro do spool: não adianta tentar de novo
+			if quarantineFile(sc.quarantine, path, err) {
+				scanClaims.Release(path)
+			}
+			return true
+		}
+		// spool cheio: o arquivo fica no disco para a próxima passada
+		logger.Warnf("[scanner] cannot enqueue %s: %v", path, err)
+		scanClaims.Release(path)
+		return false
+	}
+	logger.Infof("[scanner] enqueued %s (%s, %d record(s))", path, capture.Format, len(items))
+	return true
 }
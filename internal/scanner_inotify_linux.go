@@ -0,0 +1,135 @@
+//go:build linux
+
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// dirWatcher observa diretórios com inotify e avisa dos arquivos que foram
+// fechados depois de escritos (IN_CLOSE_WRITE) ou movidos para eles
+// (IN_MOVED_TO), e dos subdiretórios criados. Um evento sem caminho pede uma
+// varredura completa (fila do kernel estourou).
+type dirWatcher struct {
+	f      *os.File
+	fd     int
+	Events chan watchEvent
+	// Err recebe o motivo quando o watch deixa de funcionar; Events é fechado.
+	Err chan error
+
+	mu   sync.Mutex
+	dirs map[int32]string
+	root int32
+}
+
+const watchMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// newDirWatcher cria o watch em dir. Falha quando o kernel não tem inotify ou
+// o limite de instâncias/watches foi atingido (EMFILE/ENOSPC); nesses casos o
+// scanner volta para o polling.
+func newDirWatcher(dir string) (*dirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify_init: %w", err)
+	}
+	// fd não bloqueante: os.File usa o poller do runtime e Close desbloqueia Read
+	w := &dirWatcher{
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		fd:     fd,
+		Events: make(chan watchEvent, 256),
+		Err:    make(chan error, 1),
+		dirs:   map[int32]string{},
+	}
+	wd, err := w.add(dir)
+	if err != nil {
+		w.f.Close()
+		return nil, err
+	}
+	w.root = wd
+	go w.readLoop()
+	return w, nil
+}
+
+// Add observa mais um diretório (subdiretório de uma fonte recursiva).
+func (w *dirWatcher) Add(dir string) error {
+	_, err := w.add(dir)
+	return err
+}
+
+func (w *dirWatcher) add(dir string) (int32, error) {
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, watchMask)
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			return 0, fmt.Errorf("inotify watch limit reached: %w", err)
+		}
+		return 0, fmt.Errorf("inotify_add_watch %s: %w", dir, err)
+	}
+	w.mu.Lock()
+	w.dirs[int32(wd)] = dir
+	w.mu.Unlock()
+	return int32(wd), nil
+}
+
+func (w *dirWatcher) readLoop() {
+	defer close(w.Events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			w.Err <- err
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameStart := off + syscall.SizeofInotifyEvent
+			nameEnd := nameStart + int(ev.Len)
+			if nameEnd > n {
+				break
+			}
+			off = nameEnd
+
+			w.mu.Lock()
+			dir, known := w.dirs[ev.Wd]
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, ev.Wd)
+			}
+			w.mu.Unlock()
+
+			switch {
+			case ev.Mask&syscall.IN_Q_OVERFLOW != 0:
+				metrics.Inc("scanner.inotify_overflow")
+				w.Events <- watchEvent{}
+			case ev.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_IGNORED) != 0:
+				if ev.Wd == w.root {
+					w.Err <- errors.New("watched directory was removed or moved")
+					return
+				}
+				// subdiretório sumiu: o kernel já removeu o watch
+			case !known:
+			case ev.Mask&syscall.IN_CREATE != 0 && ev.Mask&syscall.IN_ISDIR == 0:
+				// arquivo novo ainda vazio; espera o IN_CLOSE_WRITE
+			case ev.Mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_CREATE) != 0:
+				name := string(bytes.TrimRight(buf[nameStart:nameEnd], "\x00"))
+				if name != "" {
+					w.Events <- watchEvent{
+						Path:  filepath.Join(dir, name),
+						Moved: ev.Mask&syscall.IN_MOVED_TO != 0,
+					}
+				}
+			}
+		}
+	}
+}
+
+// Close encerra o watch.
+func (w *dirWatcher) Close() error {
+	return w.f.Close()
+}
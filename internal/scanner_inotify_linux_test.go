@@ -0,0 +1,109 @@
+//go:build linux
+
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// nextWatchEvent espera o evento de path, ignorando os de outros arquivos.
+func nextWatchEvent(t *testing.T, w *dirWatcher, path string) watchEvent {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev := <-w.Events:
+			if ev.Path == path {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("no inotify event for %s", path)
+		}
+	}
+}
+
+func TestDirWatcherMarksOnlyRenamesComplete(t *testing.T) {
+	dir := t.TempDir()
+	w, err := newDirWatcher(dir)
+	if err != nil {
+		t.Skipf("inotify unavailable: %v", err)
+	}
+	defer w.Close()
+
+	written := filepath.Join(dir, "written.bin")
+	if err := os.WriteFile(written, []byte("close write"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextWatchEvent(t, w, written); ev.Moved {
+		t.Fatal("IN_CLOSE_WRITE reported as a completed rename")
+	}
+
+	staged := filepath.Join(t.TempDir(), "moved.bin")
+	if err := os.WriteFile(staged, []byte("moved in"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(dir, "moved.bin")
+	if err := os.Rename(staged, moved); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextWatchEvent(t, w, moved); !ev.Moved {
+		t.Fatal("IN_MOVED_TO not reported as a completed rename")
+	}
+}
+
+func TestDirWatcherFailsWhenDirIsRemoved(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "scan")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	w, err := newDirWatcher(dir)
+	if err != nil {
+		t.Skipf("inotify unavailable: %v", err)
+	}
+	defer w.Close()
+
+	// o scanner volta para o polling quando Err recebe algo
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-w.Err:
+		if err == nil {
+			t.Fatal("nil error after the watched dir was removed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("watch did not fail after the watched dir was removed")
+	}
+}
+
+func TestOfferFileWaitsForStabilityAfterCloseWrite(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.General.StableMs = 60_000
+	s := openTestSpool(t, cfg)
+	sc := newDirScanner(cfg, ScanSource{Dir: cfg.General.ScanDir, MinSize: 16})
+
+	path := filepath.Join(cfg.General.ScanDir, "capture.bin")
+	if err := os.WriteFile(path, rpcRequestPayload(t, 1, []byte("payload")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// IN_CLOSE_WRITE: o produtor pode reabrir o arquivo
+	sc.offerFile(path, info, false)
+	if scanClaims.Owned(path) {
+		t.Fatal("file claimed before it was stable")
+	}
+	expectNoItem(t, s)
+
+	// IN_MOVED_TO: chegou inteiro
+	sc.offerFile(path, info, true)
+	if it := nextItem(t, s); it.Path != path {
+		t.Fatalf("item path %q, want %q", it.Path, path)
+	}
+}
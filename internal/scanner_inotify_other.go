@@ -0,0 +1,19 @@
+//go:build !linux
+
+package internal
+
+import "errors"
+
+// dirWatcher não existe fora do Linux; o scanner usa polling.
+type dirWatcher struct {
+	Events chan watchEvent
+	Err    chan error
+}
+
+func newDirWatcher(dir string) (*dirWatcher, error) {
+	return nil, errors.New("inotify is not supported on this platform")
+}
+
+func (w *dirWatcher) Add(dir string) error { return nil }
+
+func (w *dirWatcher) Close() error { return nil }
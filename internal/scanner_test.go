@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// runScanner roda sc até o fim do teste.
+func runScanner(t *testing.T, sc *dirScanner, cfg Config) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sc.run(ctx, cfg)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestScannerPollMode(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.General.ScanMode = ScanModePoll
+	cfg.General.ScanIntervalMs = 20
+	cfg.General.StableMs = 0
+	s := openTestSpool(t, cfg)
+	sc := newDirScanner(cfg, ScanSource{Dir: cfg.General.ScanDir, MinSize: 16})
+
+	fallbacks, events := metrics.Get("scanner.poll_fallback"), metrics.Get("scanner.inotify_events")
+	runScanner(t, sc, cfg)
+
+	path := filepath.Join(cfg.General.ScanDir, "polled.bin")
+	if err := os.WriteFile(path, rpcRequestPayload(t, 1, []byte("polled payload")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if it := nextItem(t, s); it.Path != path {
+		t.Fatalf("item path %q, want %q", it.Path, path)
+	}
+	// poll é escolha da config, não fallback, e não abre watch
+	if metrics.Get("scanner.poll_fallback") != fallbacks {
+		t.Fatal("poll mode counted as an inotify fallback")
+	}
+	if metrics.Get("scanner.inotify_events") != events {
+		t.Fatal("poll mode consumed inotify events")
+	}
+}
+
+func TestScannerInotifyFallsBackToPolling(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.General.ScanMode = ScanModeInotify
+	cfg.General.ScanIntervalMs = 20
+	cfg.General.StableMs = 0
+	s := openTestSpool(t, cfg)
+
+	// o diretório ainda não existe: o watch falha e o scanner cai para polling
+	dir := filepath.Join(t.TempDir(), "late")
+	sc := newDirScanner(cfg, ScanSource{Dir: dir, MinSize: 16})
+	fallbacks := metrics.Get("scanner.poll_fallback")
+	runScanner(t, sc, cfg)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for metrics.Get("scanner.poll_fallback") == fallbacks {
+		if time.Now().After(deadline) {
+			t.Fatal("failed inotify watch not counted in scanner.poll_fallback")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "polled.bin")
+	if err := os.WriteFile(path, rpcRequestPayload(t, 2, []byte("found by polling")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if it := nextItem(t, s); it.Path != path {
+		t.Fatalf("item path %q, want %q", it.Path, path)
+	}
+}
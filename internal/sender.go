@@ -1,82 +1,49 @@
 package internal
 
 import (
-	"bytes"
-	"compress/gzip"
-	"context"
-	"os"
-	"path/filepath"
 	"time"
 )
 
-// SenderWorker consome itens da SendQueue e tenta enviá-los ao socket /data.
-// Faz compressão opcional e retry, similar ao comportamento Cosmog-style.
-func SenderWorker(ctx context.Context, idx int) {
-	logger := NewLogger()
-	logger.Infof("[worker%d] started", idx)
-
-	for {
-		select {
-		case <-ctx.Done():
-			logger.Infof("[worker%d] stopping", idx)
+// requeueAfter devolve o item para a fila depois de delay. Itens do spool
+// voltam para o spool (continuam em disco); se o registro já foi confirmado,
+// o item é gravado de novo. Itens só em memória, como os de SendRequest,
+// voltam para a partição de um worker por routeMemory.
+func requeueAfter(it SendItem, delay time.Duration) {
+	if it.spoolSeq != 0 && sendSpool != nil {
+		if sendSpool.Requeue(it, delay) {
 			return
-		case item := <-SendQueue:
-			if len(item.Payload) == 0 {
-				logger.Warnf("[worker%d] empty payload for %s", idx, item.Path)
-				_ = os.Remove(item.Path)
-				continue
-			}
-
-			// Step 1: compress payload if enabled
-			payload := item.Payload
-			if cfgUseCompression() {
-				var buf bytes.Buffer
-				gz := gzip.NewWriter(&buf)
-				if _, err := gz.Write(payload); err == nil {
-					_ = gz.Close()
-					payload = buf.Bytes()
-				} else {
-					_ = gz.Close()
-					logger.Warnf("[worker%d] compression failed: %v", idx, err)
-				}
-			}
-
-			// Step 2: send via current websocket connection (dataConn)
-			conn := GetDataConn()
-			if conn == nil {
-				logger.Warnf("[worker%d] no active data connection; requeueing %s", idx, item.Path)
-				requeue(item)
-				time.Sleep(2 * time.Second)
-				continue
-			}
-
-			conn.SetWriteDeadline(time.Now().Add(12 * time.Second))
-			if err := SafeWriteMessage(conn, 2, payload); err != nil {
-				logger.Warnf("[worker%d] write error: %v; requeueing %s", idx, err, filepath.Base(item.Path))
-				requeue(item)
-			} else {
-				logger.Infof("[worker%d] sent %s (%d bytes)", idx, filepath.Base(item.Path), len(payload))
-				_ = os.Remove(item.Path)
-			}
-
-			time.Sleep(120 * time.Millisecond)
 		}
+		it.spoolSeq = 0
+		time.AfterFunc(delay, func() {
+			if err := enqueueAgain([]SendItem{it}); err != nil {
+				NewLogger().Errorf("[send] cannot requeue %s: %v", describeItem(it), err)
+			}
+		})
+		return
 	}
+	time.AfterFunc(delay, func() { routeMemory(it) })
 }
 
-// requeue tenta recolocar o item na fila sem travar o worker.
-func requeue(it SendItem) {
-	go func() {
-		select {
-		case SendQueue <- it:
-		case <-time.After(5 * time.Second):
-			// fila cheia, deixa arquivo local para scanner reprocessar
-		}
-	}()
+// releaseItem trata um envio que falhou. Um item lido do scan_dir sai do
+// spool e tem o claim liberado: o arquivo continua no disco e o scanner o
+// pega de novo. Os demais, e as partes de arquivos com vários registros (para
+// não reenviar as que já foram entregues), voltam para a fila com requeueAfter.
+func releaseItem(it SendItem, delay time.Duration) {
+	if it.Path == "" || it.Parts > 1 || !scanClaims.Owned(it.Path) {
+		requeueAfter(it, delay)
+		return
+	}
+	ackItem(it)
+	clearInFlight(it.Path)
+	// a releitura do arquivo não pode ser tomada por cópia
+	sendDedup.forget(dedupKey([]SendItem{it}))
+	scanClaims.Release(it.Path)
+	kickScanner()
 }
 
-// cfgUseCompression lê variável de ambiente ou config.
-func cfgUseCompression() bool {
-	val := GetEnv("ROTOM_USE_COMPRESSION", "")
-	return val == "1" || val == "true" || val == "True"
+// ackItem confirma um item entregue (ou descartado) para o spool.
+func ackItem(it SendItem) {
+	if it.spoolSeq != 0 && sendSpool != nil {
+		sendSpool.Ack(it.spoolSeq)
+	}
 }
@@ -0,0 +1,261 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	rotompb "rotomworker/proto_gen"
+)
+
+// SessionState é o estágio de uma conexão /data com o Rotom.
+//
+//	connecting -> welcomed (WelcomeMessage enviado)
+//	welcomed   -> logged-in (LOGIN respondido com SUCCESS)
+//	logged-in  -> serving (primeiro RPC_REQUEST)
+//	*          -> draining (shutdown / worker parado)
+type SessionState int32
+
+const (
+	SessionConnecting SessionState = iota
+	SessionWelcomed
+	SessionLoggedIn
+	SessionServing
+	SessionDraining
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case SessionConnecting:
+		return "connecting"
+	case SessionWelcomed:
+		return "welcomed"
+	case SessionLoggedIn:
+		return "logged-in"
+	case SessionServing:
+		return "serving"
+	case SessionDraining:
+		return "draining"
+	}
+	return fmt.Sprintf("state(%d)", int32(s))
+}
+
+// DataSession guarda o estado da sessão de dados de um worker.
+type DataSession struct {
+	mu        sync.Mutex
+	workerID  string
+	state     SessionState
+	since     time.Time
+	loginID   uint32
+	loginSeen bool
+	lastError string
+}
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = map[string]*DataSession{}
+)
+
+// RegisterSession cria (ou devolve) a sessão de workerID no registro global,
+// que é exposto pelo canal de controle.
+func RegisterSession(workerID string) *DataSession {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if s, ok := sessions[workerID]; ok {
+		return s
+	}
+	s := &DataSession{workerID: workerID, state: SessionConnecting, since: time.Now()}
+	sessions[workerID] = s
+	return s
+}
+
+// SessionSnapshot lista o estado de todas as sessões, ordenado por worker id.
+func SessionSnapshot() []map[string]any {
+	sessionsMu.Lock()
+	list := make([]*DataSession, 0, len(sessions))
+	for _, s := range sessions {
+		list = append(list, s)
+	}
+	sessionsMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].workerID < list[j].workerID })
+
+	out := make([]map[string]any, 0, len(list))
+	for _, s := range list {
+		s.mu.Lock()
+		m := map[string]any{
+			"workerId": s.workerID,
+			"state":    s.state.String(),
+			"since":    s.since.Unix(),
+		}
+		if s.lastError != "" {
+			m["lastError"] = s.lastError
+		}
+		s.mu.Unlock()
+		out = append(out, m)
+	}
+	return out
+}
+
+// State retorna o estado atual.
+func (s *DataSession) State() SessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// setLocked troca o estado; s.mu deve estar travado.
+func (s *DataSession) setLocked(to SessionState, reason string) {
+	if s.state == to {
+		return
+	}
+	NewLogger().Infof("[session %s] %s -> %s (%s)", s.workerID, s.state, to, reason)
+	metrics.Inc("session." + to.String())
+	s.state = to
+	s.since = time.Now()
+}
+
+// Reset volta para connecting (nova conexão /data).
+func (s *DataSession) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loginSeen = false
+	s.setLocked(SessionConnecting, "dialing")
+}
+
+// Welcomed marca que o WelcomeMessage foi enviado.
+func (s *DataSession) Welcomed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == SessionConnecting {
+		s.setLocked(SessionWelcomed, "WelcomeMessage sent")
+	}
+}
+
+// Drain coloca a sessão em draining; nenhum request novo é aceito.
+func (s *DataSession) Drain(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setLocked(SessionDraining, reason)
+}
+
+// AcceptRequest valida um MitmRequest recebido contra o estado atual. Retorna nil
+// se o frame é legal; caso contrário, a MitmResponse de erro a devolver.
+func (s *DataSession) AcceptRequest(req *rotompb.MitmRequest) *rotompb.MitmResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reject string
+	status := rotompb.MitmResponse_ERROR_UNKNOWN
+	switch {
+	case s.state == SessionDraining:
+		status = rotompb.MitmResponse_ERROR_WORKER_STOPPED
+		reject = "worker is draining"
+	case s.state == SessionConnecting:
+		status = rotompb.MitmResponse_ERROR_RETRY_LATER
+		reject = "welcome not sent yet"
+	case req.GetMethod() == rotompb.MitmRequest_LOGIN:
+		s.loginID = req.GetId()
+		s.loginSeen = true
+	case req.GetMethod() == rotompb.MitmRequest_RPC_REQUEST:
+		if s.state == SessionWelcomed {
+			reject = "rpc request before login"
+			break
+		}
+		s.setLocked(SessionServing, "first rpc request")
+	default:
+		reject = fmt.Sprintf("unsupported method %d", int32(req.GetMethod()))
+	}
+
+	if reject == "" {
+		return nil
+	}
+	s.lastError = reject
+	metrics.Inc("session.rejected")
+	NewLogger().Warnf("[session %s] rejecting %s id=%d in state %s: %s", s.workerID, req.GetMethod(), req.GetId(), s.state, reject)
+	return &rotompb.MitmResponse{
+		Id:        req.GetId(),
+		Status:    status,
+		MitmError: fmt.Sprintf("%s (state=%s)", reject, s.state),
+	}
+}
+
+// ObserveOutboundRequest registra um LOGIN enviado por nós, para que a resposta
+// do Rotom conduza a transição para logged-in.
+func (s *DataSession) ObserveOutboundRequest(req *rotompb.MitmRequest) {
+	if req.GetMethod() != rotompb.MitmRequest_LOGIN {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loginID = req.GetId()
+	s.loginSeen = true
+}
+
+// ObserveResponse aplica uma MitmResponse (em qualquer direção) ao estado: a
+// resposta do LOGIN corrente decide entre logged-in e welcomed.
+func (s *DataSession) ObserveResponse(resp *rotompb.MitmResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.loginSeen || resp.GetId() != s.loginID {
+		return
+	}
+	s.loginSeen = false
+
+	ok := resp.GetStatus() == rotompb.MitmResponse_SUCCESS
+	if lr := resp.GetLoginResponse(); lr != nil && lr.GetStatus() != rotompb.AuthStatus_AUTH_STATUS_GOT_AUTH_TOKEN {
+		ok = false
+	}
+	if ok {
+		if s.state == SessionWelcomed {
+			s.setLocked(SessionLoggedIn, "login succeeded")
+		}
+		s.lastError = ""
+		return
+	}
+	s.lastError = fmt.Sprintf("login failed: %s %s", resp.GetStatus(), resp.GetMitmError())
+	if s.state == SessionLoggedIn || s.state == SessionServing {
+		s.setLocked(SessionWelcomed, "login failed")
+	}
+}
+
+// ObserveHookedRequest aplica ao estado um request consumido por um hook C.
+// Se out (a saída do hook) for uma MitmResponse, ela decide o LOGIN como em
+// ObserveResponse; senão o resultado não é visível para nós e o LOGIN conta
+// como aceito, para não barrar os RPC_REQUEST seguintes.
+func (s *DataSession) ObserveHookedRequest(req *rotompb.MitmRequest, out []byte) {
+	if resp, ok := decodeMitmResponse(out); ok {
+		if resp.GetId() == 0 {
+			resp.Id = req.GetId()
+		}
+		s.ObserveResponse(resp)
+		return
+	}
+	if req.GetMethod() != rotompb.MitmRequest_LOGIN {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.loginSeen || req.GetId() != s.loginID {
+		return
+	}
+	s.loginSeen = false
+	if s.state == SessionWelcomed {
+		s.setLocked(SessionLoggedIn, "login handled by hook")
+	}
+	s.lastError = ""
+}
+
+// decodeMitmRequest tenta interpretar msg como MitmRequest com método conhecido.
+func decodeMitmRequest(msg []byte) (*rotompb.MitmRequest, bool) {
+	var req rotompb.MitmRequest
+	if err := proto.Unmarshal(msg, &req); err != nil {
+		return nil, false
+	}
+	switch req.GetMethod() {
+	case rotompb.MitmRequest_LOGIN, rotompb.MitmRequest_RPC_REQUEST:
+		return &req, true
+	}
+	return nil, false
+}
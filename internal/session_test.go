@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+// welcomedSession devolve uma sessão nova já em welcomed.
+func welcomedSession(t *testing.T) *DataSession {
+	t.Helper()
+	s := &DataSession{workerID: t.Name()}
+	s.Welcomed()
+	if s.State() != SessionWelcomed {
+		t.Fatalf("state %s after Welcomed, want welcomed", s.State())
+	}
+	return s
+}
+
+func loginRequest(id uint32) *rotompb.MitmRequest {
+	return &rotompb.MitmRequest{Id: id, Method: rotompb.MitmRequest_LOGIN}
+}
+
+func rpcRequestMsg(id uint32) *rotompb.MitmRequest {
+	return &rotompb.MitmRequest{Id: id, Method: rotompb.MitmRequest_RPC_REQUEST}
+}
+
+func marshalResponse(t *testing.T, resp *rotompb.MitmResponse) []byte {
+	t.Helper()
+	b, err := proto.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSessionTransitions(t *testing.T) {
+	s := &DataSession{workerID: t.Name()}
+	if rej := s.AcceptRequest(loginRequest(1)); rej.GetStatus() != rotompb.MitmResponse_ERROR_RETRY_LATER {
+		t.Fatalf("LOGIN before welcome: got %v, want ERROR_RETRY_LATER", rej)
+	}
+
+	s.Welcomed()
+	if rej := s.AcceptRequest(rpcRequestMsg(2)); rej == nil {
+		t.Fatal("RPC_REQUEST before login accepted")
+	}
+	if rej := s.AcceptRequest(loginRequest(3)); rej != nil {
+		t.Fatalf("LOGIN rejected in welcomed: %v", rej)
+	}
+	s.ObserveResponse(&rotompb.MitmResponse{Id: 3, Status: rotompb.MitmResponse_SUCCESS})
+	if s.State() != SessionLoggedIn {
+		t.Fatalf("state %s after login SUCCESS, want logged-in", s.State())
+	}
+	if rej := s.AcceptRequest(rpcRequestMsg(4)); rej != nil {
+		t.Fatalf("RPC_REQUEST rejected after login: %v", rej)
+	}
+	if s.State() != SessionServing {
+		t.Fatalf("state %s after first rpc, want serving", s.State())
+	}
+
+	s.Drain("test")
+	if rej := s.AcceptRequest(rpcRequestMsg(5)); rej.GetStatus() != rotompb.MitmResponse_ERROR_WORKER_STOPPED {
+		t.Fatalf("RPC_REQUEST while draining: got %v, want ERROR_WORKER_STOPPED", rej)
+	}
+}
+
+func TestSessionLoginHandledByHook(t *testing.T) {
+	failed := marshalResponse(t, &rotompb.MitmResponse{Id: 7, Status: rotompb.MitmResponse_ERROR_UNKNOWN, MitmError: "bad token"})
+	cases := []struct {
+		name string
+		out  []byte
+		want SessionState
+	}{
+		// a saída do hook não é uma MitmResponse: o LOGIN conta como aceito
+		{"opaque output", []byte("hook did it"), SessionLoggedIn},
+		{"no output", nil, SessionLoggedIn},
+		{"success response", marshalResponse(t, &rotompb.MitmResponse{Id: 7, Status: rotompb.MitmResponse_SUCCESS}), SessionLoggedIn},
+		{"success response without id", marshalResponse(t, &rotompb.MitmResponse{Status: rotompb.MitmResponse_SUCCESS}), SessionLoggedIn},
+		{"failed response", failed, SessionWelcomed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := welcomedSession(t)
+			login := loginRequest(7)
+			if rej := s.AcceptRequest(login); rej != nil {
+				t.Fatalf("LOGIN rejected: %v", rej)
+			}
+			s.ObserveHookedRequest(login, tc.out)
+			if s.State() != tc.want {
+				t.Fatalf("state %s, want %s", s.State(), tc.want)
+			}
+			rej := s.AcceptRequest(rpcRequestMsg(8))
+			if tc.want == SessionLoggedIn && rej != nil {
+				t.Fatalf("RPC_REQUEST rejected after hooked login: %v", rej)
+			}
+			if tc.want == SessionWelcomed && rej == nil {
+				t.Fatal("RPC_REQUEST accepted after a failed hooked login")
+			}
+		})
+	}
+}
+
+func TestHooksInboundStageDrivesSession(t *testing.T) {
+	old := handleRequestHook
+	handleRequestHook = func([]byte) (bool, []byte, error) { return true, nil, nil }
+	defer func() { handleRequestHook = old }()
+
+	cfg := testConfig(t)
+	w := NewDataWorker(cfg, 1, nil, nil)
+	w.session.Reset()
+	w.session.Welcomed()
+
+	login := loginRequest(9)
+	msg, err := proto.Marshal(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rej := w.session.AcceptRequest(login); rej != nil {
+		t.Fatalf("LOGIN rejected: %v", rej)
+	}
+	handled, err := hooksInboundStage(&InboundJob{Msg: msg, Request: login, worker: w})
+	if err != nil || !handled {
+		t.Fatalf("hooks stage: handled=%v err=%v", handled, err)
+	}
+	if rej := w.session.AcceptRequest(rpcRequestMsg(10)); rej != nil {
+		t.Fatalf("RPC_REQUEST rejected after a LOGIN handled by HandleRequest: %v", rej)
+	}
+}
@@ -0,0 +1,719 @@
+package internal
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formato de cada registro num segmento (big-endian, igual ao framing TCP):
+//
+//	[1] kind (put/ack/retry) [8] seq [4] bodyLen [4] crc32(body) [bodyLen] body
+//
+// O body de um put é [4] metaLen + meta JSON + payload; o de um ack é vazio; o
+// de um retry é um meta JSON só com as tentativas, que substituem as do put.
+const (
+	spoolKindPut   byte = 1
+	spoolKindAck   byte = 2
+	spoolKindRetry byte = 3
+
+	spoolHeaderSize = 1 + 8 + 4 + 4
+	spoolSegmentExt = ".seg"
+
+	// spoolMaxBody limita o body de um registro: o payload tem o mesmo teto do
+	// receptor TCP e das capturas, mais folga para o meta. Um header que passa
+	// disso está corrompido.
+	spoolMaxBody = maxCaptureRecord + 1<<20
+)
+
+var (
+	// ErrSpoolFull indica que o spool atingiu spool.max_size_mb; o produtor
+	// deve manter o dado na origem e tentar de novo mais tarde.
+	ErrSpoolFull = errors.New("spool is full")
+	// ErrSpoolClosed é devolvido depois de Close.
+	ErrSpoolClosed = errors.New("spool is closed")
+	// ErrSpoolTooLarge recusa um item maior que um registro pode guardar.
+	ErrSpoolTooLarge = errors.New("item is too large for the spool")
+)
+
+// FsyncPolicy define quando o spool chama fsync no segmento ativo.
+type FsyncPolicy string
+
+const (
+	FsyncAlways   FsyncPolicy = "always"
+	FsyncInterval FsyncPolicy = "interval"
+	FsyncNever    FsyncPolicy = "never"
+)
+
+// SpoolOptions configura um Spool.
+type SpoolOptions struct {
+	Dir           string
+	MaxBytes      int64
+	SegmentBytes  int64
+	Fsync         FsyncPolicy
+	FsyncInterval time.Duration
+	LaneWeights   LaneWeights
+}
+
+// spoolMeta é a parte do SendItem que sobrevive a um restart.
+type spoolMeta struct {
+	Path     string `json:"path,omitempty"`
+	WorkerID string `json:"worker,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	Part     int    `json:"part,omitempty"`
+	Parts    int    `json:"parts,omitempty"`
+	Origin   string `json:"origin,omitempty"`
+	Priority int    `json:"prio,omitempty"`
+	// contadores do failItem; um retry grava os novos valores
+	Attempts  int    `json:"attempts,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// metaOf monta os metadados de item, já com a faixa resolvida.
+func metaOf(item SendItem) spoolMeta {
+	return spoolMeta{
+		Path:      item.Path,
+		WorkerID:  item.WorkerID,
+		Tag:       item.Tag,
+		Part:      item.Part,
+		Parts:     item.Parts,
+		Origin:    item.Origin,
+		Priority:  int(laneFor(item)),
+		Attempts:  item.Attempts,
+		LastError: item.LastError,
+	}
+}
+
+type spoolSegment struct {
+	id   uint64
+	path string
+	f    *os.File
+	size int64
+	live int
+	// liveBytes soma o tamanho em disco dos registros vivos
+	liveBytes int64
+}
+
+type spoolRecord struct {
+	seq      uint64
+	meta     spoolMeta
+	seg      *spoolSegment
+	off      int64 // offset do payload dentro do segmento
+	n        int   // tamanho do payload
+	disk     int64 // tamanho do registro put inteiro em disco
+	inflight bool
+	elem     *list.Element // posição na faixa ready (nil se em voo)
+
+	retryLater int
+}
+
+// Spool é uma fila persistente append-only em segmentos. Tudo que é aceito
+// por Put fica em disco até Ack, sobrevivendo a crash, fila cheia e reconexão.
+type Spool struct {
+	opts SpoolOptions
+
+	mu       sync.Mutex
+	closed   bool
+	segs     []*spoolSegment // ordenados do mais antigo ao ativo
+	recs     map[uint64]*spoolRecord
+	ready    *laneScheduler
+	nextSeq  uint64
+	bytes    int64
+	dirty    bool
+	notify   chan struct{}
+	stopSync chan struct{}
+}
+
+// OpenSpool abre (ou cria) o spool em opts.Dir e recupera os registros não
+// confirmados de uma execução anterior.
+func OpenSpool(opts SpoolOptions) (*Spool, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("spool dir is empty")
+	}
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = 8 << 20
+	}
+	if opts.Fsync == "" {
+		opts.Fsync = FsyncInterval
+	}
+	if opts.FsyncInterval <= 0 {
+		opts.FsyncInterval = time.Second
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		opts:     opts,
+		recs:     map[uint64]*spoolRecord{},
+		ready:    newLaneScheduler(opts.LaneWeights),
+		nextSeq:  1,
+		notify:   make(chan struct{}, 1),
+		stopSync: make(chan struct{}),
+	}
+	if err := s.recover(); err != nil {
+		s.closeFiles()
+		return nil, err
+	}
+	if len(s.segs) == 0 {
+		if err := s.rotateLocked(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Fsync == FsyncInterval {
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+// recover lê os segmentos existentes em ordem e reconstrói a fila.
+func (s *Spool) recover() error {
+	logger := NewLogger()
+	entries, err := os.ReadDir(s.opts.Dir)
+	if err != nil {
+		return err
+	}
+	var ids []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+		var id uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, spoolSegmentExt), "%d", &id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		path := filepath.Join(s.opts.Dir, fmt.Sprintf("%016d%s", id, spoolSegmentExt))
+		f, err := os.OpenFile(path, os.O_RDWR, 0644)
+		if err != nil {
+			return err
+		}
+		seg := &spoolSegment{id: id, path: path, f: f}
+		s.segs = append(s.segs, seg)
+		if err := s.replay(seg); err != nil {
+			return err
+		}
+		s.bytes += seg.size
+	}
+
+	// registros sem ack voltam para a fila, em ordem de seq
+	var pending []*spoolRecord
+	for _, r := range s.recs {
+		pending = append(pending, r)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].seq < pending[j].seq })
+	for _, r := range pending {
+		r.elem = s.ready.lane(Priority(r.meta.Priority)).PushBack(r)
+	}
+	if len(pending) > 0 {
+		logger.Infof("[spool] recovered %d pending item(s) from %d segment(s)", len(pending), len(s.segs))
+		metrics.Add("spool.recovered", uint64(len(pending)))
+	}
+	s.compactLocked()
+	return nil
+}
+
+// replay aplica os registros de seg; uma cauda corrompida (escrita interrompida
+// por crash) é truncada.
+func (s *Spool) replay(seg *spoolSegment) error {
+	st, err := seg.f.Stat()
+	if err != nil {
+		return err
+	}
+	size := st.Size()
+	var off int64
+	hdr := make([]byte, spoolHeaderSize)
+	for {
+		if _, err := seg.f.ReadAt(hdr, off); err != nil {
+			if err != io.EOF {
+				s.truncateTail(seg, off, err)
+			} else if size > off {
+				s.truncateTail(seg, off, io.ErrUnexpectedEOF)
+			}
+			break
+		}
+		kind := hdr[0]
+		seq := binary.BigEndian.Uint64(hdr[1:9])
+		n := int64(binary.BigEndian.Uint32(hdr[9:13]))
+		sum := binary.BigEndian.Uint32(hdr[13:17])
+		// o tamanho vem de um header ainda não verificado: confere antes de alocar
+		if n > spoolMaxBody || off+spoolHeaderSize+n > size {
+			s.truncateTail(seg, off, fmt.Errorf("bad record length %d seq=%d", n, seq))
+			break
+		}
+		body := make([]byte, n)
+		if _, err := seg.f.ReadAt(body, off+spoolHeaderSize); err != nil || crc32.ChecksumIEEE(body) != sum {
+			s.truncateTail(seg, off, fmt.Errorf("bad record seq=%d", seq))
+			break
+		}
+
+		switch kind {
+		case spoolKindPut:
+			if len(body) < 4 {
+				s.truncateTail(seg, off, fmt.Errorf("short put seq=%d", seq))
+				return nil
+			}
+			ml := int64(binary.BigEndian.Uint32(body[:4]))
+			if 4+ml > n {
+				s.truncateTail(seg, off, fmt.Errorf("bad meta seq=%d", seq))
+				return nil
+			}
+			var meta spoolMeta
+			_ = json.Unmarshal(body[4:4+ml], &meta)
+			if old, ok := s.recs[seq]; ok {
+				// reescrito pela compactação: a cópia mais nova vale
+				old.seg.live--
+				old.seg.liveBytes -= old.disk
+			}
+			s.recs[seq] = &spoolRecord{
+				seq:  seq,
+				meta: meta,
+				seg:  seg,
+				off:  off + spoolHeaderSize + 4 + ml,
+				n:    int(n - 4 - ml),
+				disk: spoolHeaderSize + n,
+			}
+			seg.live++
+			seg.liveBytes += spoolHeaderSize + n
+		case spoolKindRetry:
+			var m spoolMeta
+			if r, ok := s.recs[seq]; ok && json.Unmarshal(body, &m) == nil {
+				r.meta.Attempts, r.meta.LastError = m.Attempts, m.LastError
+			}
+		case spoolKindAck:
+			if r, ok := s.recs[seq]; ok {
+				r.seg.live--
+				r.seg.liveBytes -= r.disk
+				delete(s.recs, seq)
+			}
+		}
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+		off += spoolHeaderSize + n
+	}
+	seg.size = off
+	return nil
+}
+
+func (s *Spool) truncateTail(seg *spoolSegment, off int64, cause error) {
+	NewLogger().Warnf("[spool] truncating %s at %d: %v", filepath.Base(seg.path), off, cause)
+	metrics.Inc("spool.truncated")
+	_ = seg.f.Truncate(off)
+}
+
+// Put grava item no spool e o coloca no fim da fila. Devolve o seq atribuído.
+func (s *Spool) Put(item SendItem) (uint64, error) {
+	n := spoolPutSize(item)
+	if n-spoolHeaderSize > spoolMaxBody {
+		return 0, ErrSpoolTooLarge
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reserveLocked(n); err != nil {
+		return 0, err
+	}
+	return s.putLocked(item)
+}
+
+// PutAll grava items de uma vez: se não houver espaço para todos, nenhum é
+// gravado (ErrSpoolFull).
+func (s *Spool) PutAll(items []SendItem) error {
+	var total int64
+	for _, it := range items {
+		n := spoolPutSize(it)
+		if n-spoolHeaderSize > spoolMaxBody {
+			return ErrSpoolTooLarge
+		}
+		total += n
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reserveLocked(total); err != nil {
+		return err
+	}
+	for _, it := range items {
+		if _, err := s.putLocked(it); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spoolPutSize é o tamanho aproximado do registro put de item em disco.
+func spoolPutSize(item SendItem) int64 {
+	meta, _ := json.Marshal(metaOf(item))
+	return int64(spoolHeaderSize + 4 + len(meta) + len(item.Payload))
+}
+
+func (s *Spool) reserveLocked(n int64) error {
+	if s.closed {
+		return ErrSpoolClosed
+	}
+	if s.opts.MaxBytes > 0 && s.bytes+n > s.opts.MaxBytes {
+		metrics.Inc("spool.rejected_full")
+		return ErrSpoolFull
+	}
+	return nil
+}
+
+func (s *Spool) putLocked(item SendItem) (uint64, error) {
+	m := metaOf(item)
+	meta, err := json.Marshal(m)
+	if err != nil {
+		return 0, err
+	}
+	body := make([]byte, 4+len(meta)+len(item.Payload))
+	binary.BigEndian.PutUint32(body[:4], uint32(len(meta)))
+	copy(body[4:], meta)
+	copy(body[4+len(meta):], item.Payload)
+
+	seq := s.nextSeq
+	seg, off, err := s.appendLocked(spoolKindPut, seq, body)
+	if err != nil {
+		return 0, err
+	}
+	s.nextSeq++
+	r := &spoolRecord{
+		seq:  seq,
+		meta: m,
+		seg:  seg,
+		off:  off + spoolHeaderSize + 4 + int64(len(meta)),
+		n:    len(item.Payload),
+		disk: int64(spoolHeaderSize + len(body)),
+	}
+	seg.live++
+	seg.liveBytes += r.disk
+	s.recs[seq] = r
+	r.elem = s.ready.lane(Priority(r.meta.Priority)).PushBack(r)
+	metrics.Inc("spool.put")
+	s.signal()
+	return seq, nil
+}
+
+// Next bloqueia até haver um item pronto e o marca como em voo. O item deve
+// voltar ao spool com Ack (entregue) ou Requeue (tentar de novo).
+func (s *Spool) Next(ctx context.Context) (SendItem, error) {
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return SendItem{}, ErrSpoolClosed
+		}
+		if next := s.ready.next(); next != nil {
+			r := next.(*spoolRecord)
+			r.elem = nil
+			r.inflight = true
+			payload := make([]byte, r.n)
+			_, err := r.seg.f.ReadAt(payload, r.off)
+			s.mu.Unlock()
+			if err != nil {
+				NewLogger().Errorf("[spool] read seq=%d from %s: %v; dropping", r.seq, filepath.Base(r.seg.path), err)
+				s.Ack(r.seq)
+				continue
+			}
+			return SendItem{
+				Path:       r.meta.Path,
+				Payload:    payload,
+				WorkerID:   r.meta.WorkerID,
+				Tag:        r.meta.Tag,
+				Part:       r.meta.Part,
+				Parts:      r.meta.Parts,
+				Origin:     r.meta.Origin,
+				Priority:   Priority(r.meta.Priority),
+				spoolSeq:   r.seq,
+				retryLater: r.retryLater,
+				Attempts:   r.meta.Attempts,
+				LastError:  r.meta.LastError,
+			}, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.notify:
+		case <-ctx.Done():
+			return SendItem{}, ctx.Err()
+		}
+	}
+}
+
+// Ack confirma a entrega de seq; o registro deixa de existir no spool.
+func (s *Spool) Ack(seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.recs[seq]
+	if !ok || s.closed {
+		return
+	}
+	if r.elem != nil {
+		s.ready.lane(Priority(r.meta.Priority)).Remove(r.elem)
+	}
+	delete(s.recs, seq)
+	r.seg.live--
+	r.seg.liveBytes -= r.disk
+	if _, _, err := s.appendLocked(spoolKindAck, seq, nil); err != nil {
+		NewLogger().Errorf("[spool] ack seq=%d: %v", seq, err)
+	}
+	metrics.Inc("spool.acked")
+	s.compactLocked()
+}
+
+// Requeue devolve um item em voo para o fim da fila depois de delay, com os
+// contadores de tentativa de item. Attempts e LastError vão para o disco num
+// registro retry e sobrevivem a um restart. Retorna false se o registro não
+// existe mais (já confirmado com Ack).
+func (s *Spool) Requeue(item SendItem, delay time.Duration) bool {
+	seq := item.spoolSeq
+	s.mu.Lock()
+	_, exists := s.recs[seq]
+	s.mu.Unlock()
+	if !exists {
+		return false
+	}
+	release := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		r, ok := s.recs[seq]
+		if !ok || !r.inflight || s.closed {
+			return
+		}
+		r.inflight = false
+		r.retryLater = item.retryLater
+		if item.Attempts != r.meta.Attempts || item.LastError != r.meta.LastError {
+			r.meta.Attempts, r.meta.LastError = item.Attempts, item.LastError
+			body, _ := json.Marshal(spoolMeta{Attempts: item.Attempts, LastError: item.LastError})
+			if _, _, err := s.appendLocked(spoolKindRetry, seq, body); err != nil {
+				NewLogger().Errorf("[spool] retry seq=%d: %v", seq, err)
+			}
+		}
+		r.elem = s.ready.lane(Priority(r.meta.Priority)).PushBack(r)
+		s.signal()
+	}
+	metrics.Inc("spool.requeued")
+	if delay <= 0 {
+		release()
+		return true
+	}
+	time.AfterFunc(delay, release)
+	return true
+}
+
+// Metas lista os metadados dos registros vivos.
+func (s *Spool) Metas() []spoolMeta {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]spoolMeta, 0, len(s.recs))
+	for _, r := range s.recs {
+		out = append(out, r.meta)
+	}
+	return out
+}
+
+// SpoolStats resume o estado do spool.
+type SpoolStats struct {
+	Ready    int            `json:"ready"`
+	Lanes    map[string]int `json:"lanes"`
+	InFlight int            `json:"inflight"`
+	Bytes    int64          `json:"bytes"`
+	Segments int            `json:"segments"`
+}
+
+// Stats devolve contadores do spool.
+func (s *Spool) Stats() SpoolStats {
+	if s == nil {
+		return SpoolStats{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SpoolStats{
+		Ready:    s.ready.Len(),
+		Lanes:    s.ready.Depths(),
+		InFlight: len(s.recs) - s.ready.Len(),
+		Bytes:    s.bytes,
+		Segments: len(s.segs),
+	}
+}
+
+// Close faz o fsync final e fecha os segmentos.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.stopSync)
+	var err error
+	if a := s.activeLocked(); a != nil {
+		err = a.f.Sync()
+	}
+	s.closeFiles()
+	return err
+}
+
+func (s *Spool) closeFiles() {
+	for _, seg := range s.segs {
+		_ = seg.f.Close()
+	}
+}
+
+func (s *Spool) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Spool) activeLocked() *spoolSegment {
+	if len(s.segs) == 0 {
+		return nil
+	}
+	return s.segs[len(s.segs)-1]
+}
+
+// appendLocked grava um registro no segmento ativo e devolve o offset do header.
+func (s *Spool) appendLocked(kind byte, seq uint64, body []byte) (*spoolSegment, int64, error) {
+	seg := s.activeLocked()
+	if seg == nil || seg.size >= s.opts.SegmentBytes {
+		if err := s.rotateLocked(); err != nil {
+			return nil, 0, err
+		}
+		seg = s.activeLocked()
+	}
+
+	rec := make([]byte, spoolHeaderSize+len(body))
+	rec[0] = kind
+	binary.BigEndian.PutUint64(rec[1:9], seq)
+	binary.BigEndian.PutUint32(rec[9:13], uint32(len(body)))
+	binary.BigEndian.PutUint32(rec[13:17], crc32.ChecksumIEEE(body))
+	copy(rec[spoolHeaderSize:], body)
+
+	off := seg.size
+	if _, err := seg.f.WriteAt(rec, off); err != nil {
+		// não deixa lixo parcial no fim do segmento
+		_ = seg.f.Truncate(off)
+		return nil, 0, err
+	}
+	seg.size += int64(len(rec))
+	s.bytes += int64(len(rec))
+
+	switch s.opts.Fsync {
+	case FsyncAlways:
+		if err := seg.f.Sync(); err != nil {
+			return nil, 0, err
+		}
+	case FsyncInterval:
+		s.dirty = true
+	}
+	return seg, off, nil
+}
+
+// rotateLocked fecha o segmento ativo (fsync) e abre um novo.
+func (s *Spool) rotateLocked() error {
+	var id uint64 = 1
+	if a := s.activeLocked(); a != nil {
+		if s.opts.Fsync != FsyncNever {
+			_ = a.f.Sync()
+		}
+		id = a.id + 1
+	}
+	path := filepath.Join(s.opts.Dir, fmt.Sprintf("%016d%s", id, spoolSegmentExt))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	s.segs = append(s.segs, &spoolSegment{id: id, path: path, f: f})
+	return nil
+}
+
+// compactLocked apaga segmentos antigos sem registros vivos. Só o prefixo mais
+// antigo é apagado, para que acks em segmentos novos nunca "ressuscitem"
+// registros. Acima de metade do limite, um segmento antigo que é quase todo
+// lixo tem seus registros vivos reescritos no ativo para liberar espaço.
+func (s *Spool) compactLocked() {
+	for len(s.segs) > 1 {
+		oldest := s.segs[0]
+		if oldest.live > 0 {
+			underLimit := s.opts.MaxBytes <= 0 || s.bytes < s.opts.MaxBytes/2
+			if underLimit || oldest.liveBytes*4 > oldest.size || !s.rewriteLocked(oldest) {
+				return
+			}
+		}
+		_ = oldest.f.Close()
+		if err := os.Remove(oldest.path); err != nil {
+			NewLogger().Warnf("[spool] remove %s: %v", filepath.Base(oldest.path), err)
+		}
+		s.bytes -= oldest.size
+		s.segs = s.segs[1:]
+		metrics.Inc("spool.segments_removed")
+	}
+}
+
+// rewriteLocked copia os registros vivos de seg para o segmento ativo.
+func (s *Spool) rewriteLocked(seg *spoolSegment) bool {
+	for _, r := range s.recs {
+		if r.seg != seg {
+			continue
+		}
+		payload := make([]byte, r.n)
+		if _, err := seg.f.ReadAt(payload, r.off); err != nil {
+			NewLogger().Errorf("[spool] compaction read seq=%d: %v", r.seq, err)
+			return false
+		}
+		meta, _ := json.Marshal(r.meta)
+		body := make([]byte, 4+len(meta)+len(payload))
+		binary.BigEndian.PutUint32(body[:4], uint32(len(meta)))
+		copy(body[4:], meta)
+		copy(body[4+len(meta):], payload)
+		dst, off, err := s.appendLocked(spoolKindPut, r.seq, body)
+		if err != nil {
+			NewLogger().Errorf("[spool] compaction write seq=%d: %v", r.seq, err)
+			return false
+		}
+		seg.live--
+		seg.liveBytes -= r.disk
+		r.disk = int64(spoolHeaderSize + len(body))
+		dst.live++
+		dst.liveBytes += r.disk
+		r.seg = dst
+		r.off = off + spoolHeaderSize + 4 + int64(len(meta))
+	}
+	metrics.Inc("spool.compactions")
+	return true
+}
+
+func (s *Spool) syncLoop() {
+	t := time.NewTicker(s.opts.FsyncInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stopSync:
+			return
+		case <-t.C:
+			s.mu.Lock()
+			if s.dirty && !s.closed {
+				if a := s.activeLocked(); a != nil {
+					_ = a.f.Sync()
+				}
+				s.dirty = false
+			}
+			s.mu.Unlock()
+		}
+	}
+}
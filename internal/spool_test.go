@@ -0,0 +1,310 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSpoolRequeueKeepsAttempts(t *testing.T) {
+	opts := SpoolOptions{Dir: t.TempDir(), Fsync: FsyncNever}
+	s, err := OpenSpool(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put(SendItem{Payload: []byte("payload")}); err != nil {
+		t.Fatal(err)
+	}
+
+	it := nextItem(t, s)
+	it.Attempts, it.LastError = 2, "write: broken pipe"
+	if !s.Requeue(it, 0) {
+		t.Fatal("Requeue refused an in-flight item")
+	}
+	it = nextItem(t, s)
+	if it.Attempts != 2 || it.LastError != "write: broken pipe" {
+		t.Fatalf("after Requeue: attempts %d, last error %q", it.Attempts, it.LastError)
+	}
+
+	// o contador também volta depois de um restart
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = OpenSpool(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	it = nextItem(t, s)
+	if it.Attempts != 2 || it.LastError != "write: broken pipe" {
+		t.Fatalf("after restart: attempts %d, last error %q", it.Attempts, it.LastError)
+	}
+}
+
+// spoolPayloads esvazia o spool e devolve os payloads, na ordem de entrega.
+func spoolPayloads(t *testing.T, s *Spool) []string {
+	t.Helper()
+	var out []string
+	for s.Stats().Ready > 0 {
+		out = append(out, string(nextItem(t, s).Payload))
+	}
+	return out
+}
+
+// putPayloads grava um item por payload e devolve os seqs.
+func putPayloads(t *testing.T, s *Spool, payloads ...string) []uint64 {
+	t.Helper()
+	var seqs []uint64
+	for _, p := range payloads {
+		seq, err := s.Put(SendItem{Payload: []byte(p)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+// lastSegment devolve o caminho do segmento mais novo em dir.
+func lastSegment(t *testing.T, dir string) string {
+	t.Helper()
+	segs, _ := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	if len(segs) == 0 {
+		t.Fatal("no segment on disk")
+	}
+	sort.Strings(segs)
+	return segs[len(segs)-1]
+}
+
+func TestSpoolRecoversAfterRestart(t *testing.T) {
+	opts := SpoolOptions{Dir: t.TempDir(), Fsync: FsyncNever}
+	s, err := OpenSpool(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seqs := putPayloads(t, s, "a", "b", "c", "d")
+	s.Ack(seqs[1])
+	// "a" sai em voo e não é confirmado: volta como pendente
+	if it := nextItem(t, s); string(it.Payload) != "a" {
+		t.Fatalf("first item %q, want a", it.Payload)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenSpool(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := spoolPayloads(t, s); strings.Join(got, ",") != "a,c,d" {
+		t.Fatalf("recovered %v, want [a c d]", got)
+	}
+	// o próximo seq não reaproveita os antigos
+	if seq := putPayloads(t, s, "e")[0]; seq <= seqs[3] {
+		t.Fatalf("new seq %d reuses a recovered one (last %d)", seq, seqs[3])
+	}
+}
+
+// appendHeader devolve um dano que grava, depois do último registro, um
+// header put com bodyLen n seguido de body.
+func appendHeader(n uint32, body []byte) func(t *testing.T, path string) {
+	return func(t *testing.T, path string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		hdr := make([]byte, spoolHeaderSize)
+		hdr[0] = spoolKindPut
+		binary.BigEndian.PutUint64(hdr[1:9], 99)
+		binary.BigEndian.PutUint32(hdr[9:13], n)
+		if _, err := f.Write(append(hdr, body...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSpoolTruncatesDamagedTail(t *testing.T) {
+	cases := []struct {
+		name   string
+		want   string // payloads que sobrevivem
+		damage func(t *testing.T, path string)
+	}{
+		{"torn record", "first,second", func(t *testing.T, path string) {
+			st, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// o último registro fica pela metade
+			if err := os.Truncate(path, st.Size()-3); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"torn header", "first,second,third", func(t *testing.T, path string) {
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			// metade de um header depois do último registro
+			if _, err := f.Write([]byte{spoolKindPut, 0, 0, 0}); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"bad crc", "first,second", func(t *testing.T, path string) {
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// último byte do payload do último registro
+			b[len(b)-1] ^= 0xff
+			if err := os.WriteFile(path, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		// header inteiro com um tamanho que não cabe no segmento: não pode virar
+		// uma alocação do tamanho do header
+		{"huge length", "first,second,third", appendHeader(0xffffffff, []byte("tail"))},
+		{"length past segment end", "first,second,third", appendHeader(1000, []byte("only a few bytes"))},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := SpoolOptions{Dir: t.TempDir(), Fsync: FsyncNever}
+			s, err := OpenSpool(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			putPayloads(t, s, "first", "second", "third")
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			tc.damage(t, lastSegment(t, opts.Dir))
+
+			s, err = OpenSpool(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(spoolPayloads(t, s), ","); got != tc.want {
+				t.Fatalf("recovered %s, want %s", got, tc.want)
+			}
+			// o que vem depois do corte é lido normalmente no próximo restart
+			putPayloads(t, s, "fourth")
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			s, err = OpenSpool(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if got, want := strings.Join(spoolPayloads(t, s), ","), tc.want+",fourth"; got != want {
+				t.Fatalf("after a second restart %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSpoolRemovesAckedSegments(t *testing.T) {
+	// cada registro fecha o segmento em que foi gravado
+	opts := SpoolOptions{Dir: t.TempDir(), Fsync: FsyncNever, SegmentBytes: 1}
+	s, err := OpenSpool(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	seqs := putPayloads(t, s, "a", "b", "c", "d")
+	if n := s.Stats().Segments; n < len(seqs) {
+		t.Fatalf("%d segment(s) for %d records", n, len(seqs))
+	}
+
+	// só o prefixo mais antigo sem registros vivos é apagado
+	s.Ack(seqs[1])
+	if n := s.Stats().Segments; n < len(seqs) {
+		t.Fatalf("acking b removed a segment while a is live (%d left)", n)
+	}
+	s.Ack(seqs[0])
+	s.Ack(seqs[2])
+	// os segmentos de a, b e c saem; o de d (ainda vivo) e os dos acks ficam
+	for id := 1; id <= 4; id++ {
+		_, err := os.Stat(filepath.Join(opts.Dir, fmt.Sprintf("%016d%s", id, spoolSegmentExt)))
+		if id < 4 && !os.IsNotExist(err) {
+			t.Fatalf("segment %d still on disk: %v", id, err)
+		}
+		if id == 4 && err != nil {
+			t.Fatalf("segment with a live record removed: %v", err)
+		}
+	}
+	segs, _ := filepath.Glob(filepath.Join(opts.Dir, "*"+spoolSegmentExt))
+	if st := s.Stats(); st.Segments != len(segs) {
+		t.Fatalf("stats report %d segment(s), %d on disk", st.Segments, len(segs))
+	}
+	if got := spoolPayloads(t, s); strings.Join(got, ",") != "d" {
+		t.Fatalf("left %v, want [d]", got)
+	}
+}
+
+func TestSpoolCompactionRewritesLiveRecords(t *testing.T) {
+	opts := SpoolOptions{Dir: t.TempDir(), Fsync: FsyncNever, SegmentBytes: 1000, MaxBytes: 4000}
+	s, err := OpenSpool(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keep := putPayloads(t, s, "keep me")[0]
+	filler := strings.Repeat("x", 300)
+	var fillers []string
+	for i := 0; i < 9; i++ {
+		fillers = append(fillers, filler)
+	}
+	first := filepath.Join(opts.Dir, fmt.Sprintf("%016d%s", 1, spoolSegmentExt))
+	for _, seq := range putPayloads(t, s, fillers...) {
+		s.Ack(seq)
+	}
+
+	// o primeiro segmento só tinha "keep me" vivo: foi reescrito e apagado
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Fatalf("first segment still on disk: %v", err)
+	}
+	if st := s.Stats(); st.Bytes >= opts.MaxBytes/2 {
+		t.Fatalf("spool still holds %d bytes after compaction", st.Bytes)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenSpool(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	it := nextItem(t, s)
+	if string(it.Payload) != "keep me" || it.spoolSeq != keep {
+		t.Fatalf("after compaction and restart got %q (seq %d), want %q (seq %d)", it.Payload, it.spoolSeq, "keep me", keep)
+	}
+	expectNoItem(t, s)
+}
+
+func TestSpoolRejectsOversizedItem(t *testing.T) {
+	s, err := OpenSpool(SpoolOptions{Dir: t.TempDir(), Fsync: FsyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// um registro maior que spoolMaxBody seria descartado como lixo no replay
+	big := SendItem{Payload: make([]byte, spoolMaxBody)}
+	if _, err := s.Put(big); !errors.Is(err, ErrSpoolTooLarge) {
+		t.Fatalf("Put: %v, want ErrSpoolTooLarge", err)
+	}
+	if err := s.PutAll([]SendItem{{Payload: []byte("small")}, big}); !errors.Is(err, ErrSpoolTooLarge) {
+		t.Fatalf("PutAll: %v, want ErrSpoolTooLarge", err)
+	}
+	if st := s.Stats(); st.Ready != 0 {
+		t.Fatalf("%d item(s) stored after the refused puts", st.Ready)
+	}
+}
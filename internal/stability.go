@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stabilityCheck decide se um arquivo do scan_dir já terminou de ser escrito:
+// tamanho e mtime precisam ficar iguais por stable_ms. Arquivos com o sufixo
+// temporário (ex: "*.tmp") nunca são lidos; o produtor renomeia para o nome
+// final quando termina.
+type stabilityCheck struct {
+	stableFor  time.Duration
+	tempSuffix string
+
+	mu   sync.Mutex
+	seen map[string]fileObservation
+}
+
+type fileObservation struct {
+	size  int64
+	mtime time.Time
+	since time.Time
+}
+
+func newStabilityCheck(cfg Config) *stabilityCheck {
+	return &stabilityCheck{
+		stableFor:  time.Duration(cfg.General.StableMs) * time.Millisecond,
+		tempSuffix: cfg.General.TempSuffix,
+		seen:       map[string]fileObservation{},
+	}
+}
+
+// Ready informa se path pode ser lido. Quando o arquivo ainda está mudando,
+// agenda uma nova varredura para depois do prazo (inotify não repete evento).
+// complete indica que o arquivo chegou por rename atômico e não precisa esperar.
+func (s *stabilityCheck) Ready(path string, info os.FileInfo, complete bool) bool {
+	if s.tempSuffix != "" && strings.HasSuffix(info.Name(), s.tempSuffix) {
+		return false
+	}
+	if complete || s.stableFor <= 0 {
+		s.Forget(path)
+		return true
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obs, ok := s.seen[path]
+	if !ok || obs.size != info.Size() || !obs.mtime.Equal(info.ModTime()) {
+		s.seen[path] = fileObservation{size: info.Size(), mtime: info.ModTime(), since: now}
+		time.AfterFunc(s.stableFor, kickScanner)
+		return false
+	}
+	// mtime recente também conta como "ainda escrevendo"
+	if now.Sub(obs.since) < s.stableFor || now.Sub(info.ModTime()) < s.stableFor {
+		return false
+	}
+	delete(s.seen, path)
+	return true
+}
+
+// Forget descarta a observação de path.
+func (s *stabilityCheck) Forget(path string) {
+	s.mu.Lock()
+	delete(s.seen, path)
+	s.mu.Unlock()
+}
+
+// Prune remove observações de arquivos que sumiram do diretório.
+func (s *stabilityCheck) Prune(present map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := range s.seen {
+		if !present[p] {
+			delete(s.seen, p)
+		}
+	}
+}
+
+// errCorruptCapture marca captura truncada/corrompida (vai para quarentena).
+var errCorruptCapture = errors.New("corrupt capture")
+
+// quarantineFile move path para dir (general.quarantine_dir) em vez de enviá-lo.
+// Retorna false se o arquivo ficou no lugar.
+func quarantineFile(dir, path string, cause error) bool {
+	logger := NewLogger()
+	metrics.Inc("scanner.quarantined")
+	if dir == "" {
+		logger.Warnf("[scanner] %s is corrupt (%v) and no quarantine_dir is set; leaving it", basename(path), cause)
+		return false
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Errorf("[scanner] quarantine dir %s: %v", dir, err)
+		return false
+	}
+	dst := filepath.Join(dir, fmt.Sprintf("%d-%s", time.Now().UnixNano(), filepath.Base(path)))
+	if err := moveFile(path, dst); err != nil {
+		logger.Errorf("[scanner] quarantine %s: %v", path, err)
+		return false
+	}
+	logger.Warnf("[scanner] quarantined %s -> %s: %v", basename(path), dst, cause)
+	return true
+}
+
+// moveFile renomeia src para dst, copiando quando estão em filesystems diferentes.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, b, 0644); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	rotompb "rotomworker/proto_gen"
+)
+
+const (
+	retryLaterBase = 1 * time.Second
+	retryLaterMax  = 60 * time.Second
+)
+
+// PauseGate pausa o consumo da fila até o canal de controle mandar "resume".
+// É acionado quando o Rotom responde ERROR_WORKER_STOPPED.
+type PauseGate struct {
+	mu      sync.Mutex
+	paused  bool
+	reason  string
+	resumed chan struct{}
+	stopped chan struct{}
+}
+
+// NewPauseGate cria um gate liberado.
+func NewPauseGate() *PauseGate {
+	ch := make(chan struct{})
+	close(ch)
+	return &PauseGate{resumed: ch, stopped: make(chan struct{})}
+}
+
+// Pause fecha o gate. Chamadas repetidas só atualizam o motivo.
+func (g *PauseGate) Pause(reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.reason = reason
+	if g.paused {
+		return
+	}
+	g.paused = true
+	g.resumed = make(chan struct{})
+	close(g.stopped)
+}
+
+// Resume libera o gate. Retorna false se não estava pausado.
+func (g *PauseGate) Resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		return false
+	}
+	g.paused = false
+	g.reason = ""
+	close(g.resumed)
+	g.stopped = make(chan struct{})
+	return true
+}
+
+// Paused informa se o gate está fechado e por quê.
+func (g *PauseGate) Paused() (bool, string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused, g.reason
+}
+
+// Resumed devolve um canal fechado quando o gate estiver liberado.
+func (g *PauseGate) Resumed() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumed
+}
+
+// Stopped devolve um canal fechado quando o gate for pausado.
+func (g *PauseGate) Stopped() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stopped
+}
+
+// Wait bloqueia enquanto o gate estiver pausado.
+func (g *PauseGate) Wait(ctx context.Context) error {
+	select {
+	case <-g.Resumed():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// statusAction é o que a conexão /data deve fazer depois de uma MitmResponse.
+type statusAction int
+
+const (
+	statusNone statusAction = iota
+	statusReconnect
+	statusStopped
+)
+
+// applyMitmStatus trata os status de erro com comportamento embutido:
+// ERROR_RECONNECT derruba o socket, ERROR_WORKER_STOPPED pausa o worker.
+// ERROR_RETRY_LATER é tratado em retryLater, pois precisa do item original.
+func applyMitmStatus(resp *rotompb.MitmResponse, workerID string, pause *PauseGate) statusAction {
+	logger := NewLogger()
+	switch resp.GetStatus() {
+	case rotompb.MitmResponse_ERROR_RECONNECT:
+		metrics.Inc("status.reconnect")
+		logger.Warnf("[data] ERROR_RECONNECT id=%d: %s; redialing /data", resp.GetId(), resp.GetMitmError())
+		return statusReconnect
+	case rotompb.MitmResponse_ERROR_WORKER_STOPPED:
+		metrics.Inc("status.worker_stopped")
+		logger.Warnf("[data] ERROR_WORKER_STOPPED id=%d: %s; pausing %s until resume", resp.GetId(), resp.GetMitmError(), workerID)
+		pause.Pause("ERROR_WORKER_STOPPED: " + resp.GetMitmError())
+		return statusStopped
+	case rotompb.MitmResponse_ERROR_RETRY_LATER:
+		metrics.Inc("status.retry_later")
+	case rotompb.MitmResponse_ERROR_UNKNOWN:
+		metrics.Inc("status.error_unknown")
+	}
+	return statusNone
+}
+
+// retryLater reenfileira item com backoff exponencial após ERROR_RETRY_LATER.
+func retryLater(item SendItem) {
+	delay := retryLaterBase << uint(item.retryLater)
+	if delay > retryLaterMax || delay <= 0 {
+		delay = retryLaterMax
+	}
+	item.retryLater++
+	NewLogger().Warnf("[data] ERROR_RETRY_LATER for %s; requeueing in %s (retry %d)", describeItem(item), delay, item.retryLater)
+	requeueAfter(item, delay)
+}
+
+func describeItem(item SendItem) string {
+	name := "payload"
+	if item.Path != "" {
+		name = basename(item.Path)
+	}
+	if item.Parts > 1 {
+		name = fmt.Sprintf("%s#%d/%d", name, item.Part, item.Parts)
+	}
+	if item.Tag != "" {
+		return item.Tag + ":" + name
+	}
+	return name
+}
@@ -0,0 +1,28 @@
+package internal
+
+import "testing"
+
+func TestPauseGateStoppedSignal(t *testing.T) {
+	g := NewPauseGate()
+	stopped := g.Stopped()
+	select {
+	case <-stopped:
+		t.Fatal("Stopped closed on a gate that was never paused")
+	default:
+	}
+
+	// quem já esperava no canal antigo é acordado pela pausa
+	g.Pause("ERROR_WORKER_STOPPED")
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("Pause did not close Stopped")
+	}
+
+	g.Resume()
+	select {
+	case <-g.Stopped():
+		t.Fatal("Stopped still closed after Resume")
+	default:
+	}
+}
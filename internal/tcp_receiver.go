@@ -5,13 +5,12 @@ import (
 	"encoding/binary"
 	"io"
 	"net"
-	"os"
 	"time"
 )
 
 // StartTCPReceiver abre um listener em addr (ex: "127.0.0.1:7707").
 // Formato esperado por cliente: 4 bytes big-endian length, seguido por payload bytes.
-// Cada payload é enfileirado em SendQueue como SendItem (Path empty).
+// Cada payload é gravado no spool de envio como SendItem (Path empty).
 func StartTCPReceiver(ctx context.Context, addr string) error {
 	logger := NewLogger()
 	ln, err := net.Listen("tcp", addr)
@@ -72,16 +71,32 @@ func handleTCPConn(ctx context.Context, conn net.Conn) {
 			return
 		}
 
-		// build SendItem and enqueue (path empty)
-		it := SendItem{Path: "", Payload: buf}
-		select {
-		case SendQueue <- it:
-			logger.Debugf("[tcp] enqueued payload %d bytes", len(buf))
-		case <-time.After(3 * time.Second):
-			// fallback: write to disk if unable to enqueue
-			tmp := "/data/local/tmp/rotom_tcp_pending.bin"
-			_ = os.WriteFile(tmp, buf, 0644)
-			logger.Warnf("[tcp] queue full; wrote payload to %s", tmp)
+		// build SendItem and persist it in the spool (path empty). With the
+		// spool full we stop reading from this conn until there is room again.
+		it := SendItem{Path: "", Payload: buf, Origin: OriginTCP}
+		for warned := false; ; {
+			err := Enqueue(it)
+			if err == nil {
+				logger.Debugf("[tcp] enqueued payload %d bytes", len(buf))
+				break
+			}
+			if err == ErrDuplicate {
+				logger.Debugf("[tcp] dropped duplicate payload %d bytes", len(buf))
+				break
+			}
+			if err != ErrSpoolFull {
+				logger.Errorf("[tcp] cannot spool payload: %v; closing conn", err)
+				return
+			}
+			if !warned {
+				logger.Warnf("[tcp] spool full; holding %d bytes until there is room", len(buf))
+				warned = true
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(1 * time.Second):
+			}
 		}
 	}
 }
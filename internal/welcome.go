@@ -12,9 +12,11 @@ type WebSocketSender interface {
 }
 
 
-func SendWelcome(conn WebSocketSender, cfg Config, logger *logrus.Logger) error {
+// SendWelcome envia o WelcomeMessage do worker workerID. Todos os workers do
+// mesmo aparelho compartilham o DeviceId.
+func SendWelcome(conn WebSocketSender, cfg Config, workerID string, logger *logrus.Logger) error {
 	w := &pb.WelcomeMessage{
-		WorkerId:    cfg.General.DeviceName,
+		WorkerId:    workerID,
 		Origin:      "lab",
 		VersionCode: 2,
 		VersionName: "rotom-worker-go-hybrid",
@@ -28,6 +30,6 @@ func SendWelcome(conn WebSocketSender, cfg Config, logger *logrus.Logger) error
 	if err := conn.WriteBinary(data); err != nil {
 		return err
 	}
-	logger.Infof("[data] sent WelcomeMessage (%d bytes)", len(data))
+	logger.Infof("[%s] sent WelcomeMessage (%d bytes)", workerID, len(data))
 	return nil
 }
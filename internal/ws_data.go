@@ -1,75 +1,432 @@
 package internal
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
+	"fmt"
+	"hash/fnv"
 	"net/http"
-	"os"
-	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+	rotompb "rotomworker/proto_gen"
 )
 
+// workerQueueSize é a capacidade da partição de fila de cada worker. É pequena
+// de propósito: a ordem de envio (faixas de prioridade) é decidida no spool, e
+// um buffer grande deixaria itens bulk na frente de um LOGIN recém-chegado.
+const workerQueueSize = 4
+
 var (
-	dataConn     *websocket.Conn
-	dataConnLock = make(chan struct{}, 1)
-	writeLock    sync.Mutex // 🔒 protege todas as escritas simultâneas
+	lastRequestID uint32
+
+	dataWorkersMu sync.RWMutex
+	dataWorkers   []*DataWorker
+	dataCtx       = context.Background() // contexto de StartDataWs, para o roteamento
+	routeCounter  uint64
 )
 
-// wsConnAdapter adapta *websocket.Conn para ser compatível com SendWelcome
-type wsConnAdapter struct {
-	c *websocket.Conn
+// DataWorker é um worker Rotom lógico: cada um tem seu worker_id, sua conexão
+// /data, seu WelcomeMessage, sua sessão, sua tabela de pendentes e sua partição
+// da fila de envio. Vários workers permitem servir várias contas no mesmo device.
+type DataWorker struct {
+	Index int
+	ID    string
+
+	cfg      Config
+	pipeline *SendPipeline
+	inbound  *InboundPipeline
+	queue    chan SendItem
+	ackMode  AckMode
+	acks     *PendingTable // confirmações por frame (rotom.ack_mode = "frame")
+	seqAcks  *PendingTable // confirmações de frames sem id, pela sequência
+	pending  *PendingTable
+	session  *DataSession
+	location *WorkerLocation
+	pause    *PauseGate
+	logger   *logrus.Logger
+
+	peerCompress atomic.Int32 // peerCompression*: o que o Rotom anunciou no login
+
+	connMu   sync.RWMutex
+	conn     *websocket.Conn
+	writeMu  sync.Mutex // 🔒 protege todas as escritas simultâneas
+	frameSeq uint32     // frames binários escritos na conexão atual (sob writeMu)
 }
 
+// WorkerID deriva o worker_id do índice (1-based): "<device_name>-<idx>".
+func WorkerID(cfg Config, idx int) string {
+	return fmt.Sprintf("%s-%d", cfg.General.DeviceName, idx)
+}
 
-// GetDataConn retorna a conexão WebSocket de dados atual (thread-safe).
-func GetDataConn() *websocket.Conn {
-	select {
-	case dataConnLock <- struct{}{}:
-		defer func() { <-dataConnLock }()
-		return dataConn
-	default:
-		return dataConn
+// NewDataWorker cria o worker idx (1-based) e registra sua sessão. Todos os
+// workers compartilham o mesmo pipeline de envio e a mesma entrada.
+func NewDataWorker(cfg Config, idx int, pipeline *SendPipeline, inbound *InboundPipeline) *DataWorker {
+	id := WorkerID(cfg, idx)
+	return &DataWorker{
+		Index:    idx,
+		ID:       id,
+		cfg:      cfg,
+		pipeline: pipeline,
+		inbound:  inbound,
+		queue:    make(chan SendItem, workerQueueSize),
+		ackMode:  AckMode(cfg.Rotom.AckMode),
+		acks:     NewPendingTable("ack"),
+		seqAcks:  NewPendingTable("ack_seq"),
+		pending:  NewPendingTable("pending"),
+		session:  RegisterSession(id),
+		location: RegisterLocation(id),
+		pause:    NewPauseGate(),
+		logger:   NewLogger(),
 	}
 }
 
-// Define a conexão global atual.
-func setDataConn(c *websocket.Conn) {
-	select {
-	case dataConnLock <- struct{}{}:
-		dataConn = c
-		<-dataConnLock
-	default:
-		dataConn = c
+// DataWorkers retorna os workers ativos.
+func DataWorkers() []*DataWorker {
+	dataWorkersMu.RLock()
+	defer dataWorkersMu.RUnlock()
+	return append([]*DataWorker(nil), dataWorkers...)
+}
+
+// setDataWorkers registra workers como os workers ativos, servidos até ctx
+// ser cancelado.
+func setDataWorkers(ctx context.Context, workers []*DataWorker) {
+	dataWorkersMu.Lock()
+	defer dataWorkersMu.Unlock()
+	dataWorkers = workers
+	dataCtx = ctx
+}
+
+// DataWorkerByID procura um worker pelo worker_id.
+func DataWorkerByID(id string) *DataWorker {
+	for _, w := range DataWorkers() {
+		if w.ID == id {
+			return w
+		}
+	}
+	return nil
+}
+
+// DataWorkersSnapshot resume o estado de cada worker para o canal de controle.
+func DataWorkersSnapshot() []map[string]any {
+	var out []map[string]any
+	for _, w := range DataWorkers() {
+		paused, reason := w.pause.Paused()
+		m := map[string]any{
+			"workerId":  w.ID,
+			"state":     w.session.State().String(),
+			"connected": w.Conn() != nil,
+			"pending":   w.pending.Len(),
+			"queued":    len(w.queue),
+			"paused":    paused,
+		}
+		if paused {
+			m["pausedBy"] = reason
+		}
+		out = append(out, m)
 	}
+	return out
 }
 
-// 🔒 Função de escrita segura (adicione AQUI, logo após os getters)
-func SafeWriteMessage(conn *websocket.Conn, messageType int, data []byte) error {
-	writeLock.Lock()
-	defer writeLock.Unlock()
-	return conn.WriteMessage(messageType, data)
+// Conn retorna a conexão WebSocket de dados atual do worker (thread-safe).
+func (w *DataWorker) Conn() *websocket.Conn {
+	w.connMu.RLock()
+	defer w.connMu.RUnlock()
+	return w.conn
 }
 
+func (w *DataWorker) setConn(c *websocket.Conn) {
+	w.connMu.Lock()
+	w.conn = c
+	w.connMu.Unlock()
+	if c != nil {
+		w.writeMu.Lock()
+		w.frameSeq = 0
+		w.writeMu.Unlock()
+	}
+}
+
+// writeMessage é a escrita segura na conexão c do worker. Não passa pelo
+// sendLimiter: quem envia itens do spool espera por ele antes (writeStage,
+// SendBatch), e as respostas do worker ao Rotom não podem ficar atrás deles.
+func (w *DataWorker) writeMessage(c *websocket.Conn, messageType int, data []byte, timeout time.Duration) error {
+	return w.writeFrame(c, messageType, data, timeout, nil)
+}
+
+// writeFrame é writeMessage com before, chamado antes da escrita com o número
+// do frame binário na conexão (1 = o WelcomeMessage). É por esse número que o
+// Rotom confirma frames sem MitmRequest.id no modo "frame".
+func (w *DataWorker) writeFrame(c *websocket.Conn, messageType int, data []byte, timeout time.Duration, before func(seq uint32)) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	if messageType == websocket.BinaryMessage {
+		w.frameSeq++
+	}
+	if before != nil {
+		before(w.frameSeq)
+	}
+	c.SetWriteDeadline(time.Now().Add(timeout))
+	return c.WriteMessage(messageType, data)
+}
+
+// wsConnAdapter adapta a conexão do worker para ser compatível com SendWelcome
+type wsConnAdapter struct {
+	w *DataWorker
+	c *websocket.Conn
+}
 
 func (a *wsConnAdapter) WriteBinary(b []byte) error {
-	a.c.SetWriteDeadline(time.Now().Add(10 * time.Second))
-	return SafeWriteMessage(a.c, websocket.BinaryMessage, b)
+	return a.w.writeMessage(a.c, websocket.BinaryMessage, b, 10*time.Second)
+}
+
+// trackItem registra item na tabela de pendentes se payload (o que foi de fato
+// enviado, antes da compressão) for um MitmRequest com id. Deve ser chamado
+// antes da escrita, para não perder respostas rápidas. Com awaitAck no modo
+// "response", a resposta também conclui (ou devolve) o item.
+func (w *DataWorker) trackItem(item SendItem, payload []byte, awaitAck bool) (uint32, bool) {
+	req, ok := decodeMitmRequest(payload)
+	if !ok || req.GetId() == 0 {
+		return 0, false
+	}
+	id := req.GetId()
+	timeout := time.Duration(w.cfg.Tuning.RequestTimeoutMs) * time.Millisecond
+	ackByResponse := awaitAck && w.ackMode == AckResponse
+	if ackByResponse {
+		timeout = w.ackTimeout()
+	}
+	w.session.ObserveOutboundRequest(req)
+	w.observeLocation(req)
+	w.pending.Track(id, timeout, item, func(r PendingResult) {
+		if r.Resp != nil {
+			w.session.ObserveResponse(r.Resp)
+			if lr := r.Resp.GetLoginResponse(); lr != nil {
+				w.setPeerCompression(lr.GetSupportsCompression())
+			}
+		}
+		if item.OnResult != nil {
+			item.OnResult(r)
+			return
+		}
+		if ackByResponse {
+			w.onAckResult(r)
+			return
+		}
+		if r.Resp.GetStatus() == rotompb.MitmResponse_ERROR_RETRY_LATER {
+			// o ack por frame deste envio não vale mais
+			w.acks.Forget(r.ID)
+			clearInFlight(r.Item.Path)
+			it := r.Item
+			it.WorkerID = w.ID
+			retryLater(it)
+			return
+		}
+		if r.Err == nil {
+			w.logger.Debugf("[%s] MitmRequest id=%d answered: %s", w.ID, r.ID, r.Resp.GetStatus())
+		}
+	})
+	return id, true
+}
+
+// untrackItem descarta o registro de um item cuja escrita falhou (ele será reenfileirado).
+func (w *DataWorker) untrackItem(id uint32, ok bool) {
+	if ok {
+		w.pending.Forget(id)
+	}
+}
+
+// SendRequest enfileira req no socket /data deste worker e espera pela
+// MitmResponse de mesmo id. Se req.Id for zero, um id novo é alocado.
+func (w *DataWorker) SendRequest(ctx context.Context, req *rotompb.MitmRequest) (*rotompb.MitmResponse, error) {
+	if req.GetId() == 0 {
+		req.Id = atomic.AddUint32(&lastRequestID, 1)
+	}
+	b, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan PendingResult, 1)
+	item := SendItem{Payload: b, WorkerID: w.ID, OnResult: func(r PendingResult) { ch <- r }}
+
+	select {
+	case w.queue <- item:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case r := <-ch:
+		return r.Resp, r.Err
+	case <-ctx.Done():
+		w.pending.Forget(req.GetId())
+		return nil, ctx.Err()
+	}
 }
 
-// StartDataWs abre/gerencia a conexão websocket de dados (/data).
-// Ele consome SendQueue e envia cada item como mensagem binária.
+// StartDataWs cria um DataWorker por general.workers, abre a conexão /data de
+// cada um e distribui o spool de envio entre as partições dos workers.
 // ctx: cancelation contexto do programa.
 // cfg: configuração (usa cfg.DataEndpoint() e cfg.Rotom.Secret).
-func StartDataWs(ctx context.Context, cfg Config) {
-	logger := NewLogger()
-	
+func StartDataWs(ctx context.Context, cfg Config) []*DataWorker {
+	SetRetryPolicy(cfg)
+	sendLimiter.Set(rateLimitsFromConfig(cfg))
+	n := cfg.General.Workers
+	if n < 1 {
+		n = 1
+	}
+	pipeline := NewSendPipeline(cfg)
+	activeCompression.Store(pipeline.Compression())
+	inbound := NewInboundPipeline(cfg)
+	workers := make([]*DataWorker, 0, n)
+	for i := 1; i <= n; i++ {
+		workers = append(workers, NewDataWorker(cfg, i, pipeline, inbound))
+	}
+	setDataWorkers(ctx, workers)
+
+	go routeSpool(ctx, sendSpool, workers)
+	go func() {
+		for _, w := range workers {
+			go w.Run(ctx)
+			time.Sleep(time.Duration(cfg.Tuning.WorkerSpawnDelayMs) * time.Millisecond)
+		}
+	}()
+	return workers
+}
+
+// Limites do roteamento: quanto esperar por espaço na partição de um worker
+// e em quanto tempo um item que nenhum worker pode receber volta a ser servido.
+const (
+	routeWait  = 200 * time.Millisecond
+	routeRetry = time.Second
+)
+
+// routeSpool reparte spool entre as partições dos workers: SendItem.WorkerID
+// explícito, senão hash do Path (o mesmo arquivo sempre vai para o mesmo
+// worker), senão round-robin. Um worker pausado, desconectado ou com a
+// partição cheia não segura os demais: o item vai para outro worker (se não
+// tiver WorkerID explícito) ou volta ao spool por routeRetry. O spool vem de
+// quem chama: um OpenSendSpool posterior não corre com esta goroutine.
+func routeSpool(ctx context.Context, spool *Spool, workers []*DataWorker) {
+	for {
+		item, err := spool.Next(ctx)
+		if err != nil {
+			return
+		}
+		if routeItem(ctx, workers, item) {
+			continue
+		}
+		if ctx.Err() != nil {
+			// continua em voo só em memória; volta como pendente no próximo start
+			return
+		}
+		metrics.Inc("route.deferred")
+		requeueAfter(item, routeRetry)
+	}
+}
+
+// routeItem entrega item à partição de um worker sem nunca bloquear por mais
+// de routeWait em cada um. Primeiro tenta os workers conectados (o preferido
+// antes), depois qualquer um que não esteja pausado, se houver espaço.
+func routeItem(ctx context.Context, workers []*DataWorker, item SendItem) bool {
+	candidates := routeCandidates(workers, item)
+	for _, w := range candidates {
+		if paused, _ := w.pause.Paused(); paused || w.Conn() == nil {
+			continue
+		}
+		t := time.NewTimer(routeWait)
+		select {
+		case w.queue <- item:
+			t.Stop()
+			return true
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return false
+		}
+	}
+	for _, w := range candidates {
+		if paused, _ := w.pause.Paused(); paused {
+			continue
+		}
+		select {
+		case w.queue <- item:
+			return true
+		default:
+		}
+	}
+	return false
+}
+
+// routeMemory entrega um item só em memória (ex: de SendRequest) com
+// routeItem, tentando de novo a cada routeRetry enquanto nenhum worker pode
+// recebê-lo. No shutdown o item é descartado e OnResult recebe o erro.
+func routeMemory(it SendItem) {
+	dataWorkersMu.RLock()
+	ctx, workers := dataCtx, dataWorkers
+	dataWorkersMu.RUnlock()
+	if len(workers) > 0 && routeItem(ctx, workers, it) {
+		return
+	}
+	if err := ctx.Err(); err != nil || len(workers) == 0 {
+		if err == nil {
+			err = ErrConnectionLost
+		}
+		metrics.Inc("route.dropped")
+		NewLogger().Warnf("[send] dropping in-memory %s: %v", describeItem(it), err)
+		if it.OnResult != nil {
+			id, _ := requestID(it.Payload)
+			it.OnResult(PendingResult{ID: id, Err: err, Item: it})
+		}
+		return
+	}
+	metrics.Inc("route.deferred")
+	time.AfterFunc(routeRetry, func() { routeMemory(it) })
+}
+
+// routeCandidates lista os workers que podem receber item: o escolhido por
+// pickWorker e, se o item não for de um worker fixo, os demais em seguida.
+func routeCandidates(workers []*DataWorker, item SendItem) []*DataWorker {
+	first := pickWorker(workers, item)
+	if item.WorkerID != "" && first.ID == item.WorkerID {
+		return []*DataWorker{first}
+	}
+	out := make([]*DataWorker, 0, len(workers))
+	out = append(out, first)
+	for _, w := range workers {
+		if w != first {
+			out = append(out, w)
+		}
+	}
+	return out
+}
+
+func pickWorker(workers []*DataWorker, item SendItem) *DataWorker {
+	if item.WorkerID != "" {
+		for _, w := range workers {
+			if w.ID == item.WorkerID {
+				return w
+			}
+		}
+	}
+	if item.Path != "" {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(item.Path))
+		return workers[int(h.Sum32()%uint32(len(workers)))]
+	}
+	return workers[int(atomic.AddUint64(&routeCounter, 1)%uint64(len(workers)))]
+}
+
+// Run abre/gerencia a conexão websocket de dados (/data) do worker.
+// Ele consome a partição do worker e envia cada item como mensagem binária.
+func (w *DataWorker) Run(ctx context.Context) {
+	logger := w.logger
+	cfg := w.cfg
+
 	dataURL := cfg.DataEndpoint()
 	if dataURL == "" {
-		logger.Error("[data] data endpoint vazio, abortando StartDataWs")
+		logger.Errorf("[%s] data endpoint vazio, abortando", w.ID)
 		return
 	}
 
@@ -91,19 +448,21 @@ func StartDataWs(ctx context.Context, cfg Config) {
 		// check exit
 		select {
 		case <-ctx.Done():
-			logger.Info("[data] context canceled, exiting StartDataWs")
+			logger.Infof("[%s] context canceled, exiting", w.ID)
 			return
 		default:
 		}
 
-		logger.Infof("[data] connecting to %s ...", dataURL)
+		w.session.Reset()
+		w.peerCompress.Store(peerCompressionUnknown)
+		logger.Infof("[%s] connecting to %s ...", w.ID, dataURL)
 		conn, resp, err := dialer.Dial(dataURL, headers)
 		if err != nil {
 			// show http response if available (helpful)
 			if resp != nil {
-				logger.Errorf("[data] dial failed: %v (http status: %s)", err, resp.Status)
+				logger.Errorf("[%s] dial failed: %v (http status: %s)", w.ID, err, resp.Status)
 			} else {
-				logger.Errorf("[data] dial failed: %v", err)
+				logger.Errorf("[%s] dial failed: %v", w.ID, err)
 			}
 			time.Sleep(backoff)
 			backoff *= 2
@@ -113,163 +472,151 @@ func StartDataWs(ctx context.Context, cfg Config) {
 			continue
 		}
 
-		setDataConn(conn)
-		logger.Info("[data] connected")
+		w.setConn(conn)
+		logger.Infof("[%s] connected", w.ID)
 
-        // Envia WelcomeMessage protobuf assim que conectar
-        _ = SendWelcome(&wsConnAdapter{c: conn}, cfg, logger)
+		// Envia WelcomeMessage protobuf assim que conectar
+		if err := SendWelcome(&wsConnAdapter{w: w, c: conn}, cfg, w.ID, logger); err == nil {
+			w.session.Welcomed()
+		} else {
+			logger.Warnf("[%s] WelcomeMessage failed: %v", w.ID, err)
+		}
 
 		backoff = 1 * time.Second // reset on success
 
 		// channel to signal reader goroutine exit
 		msgReadStop := make(chan struct{})
-		// reader goroutine
-		go func(c *websocket.Conn) {
-			defer close(msgReadStop)
-			for {
-				_, msg, err := c.ReadMessage()
-				if err != nil {
-					logger.Warnf("[data] read error: %v", err)
-					return
-				}
+		go w.readLoop(conn, msgReadStop)
 
-				// 1️⃣ Primeiro, deixe os hooks ELF de resposta tentarem processar
-				if out, err := TryProcessResponse(msg); err == nil && len(out) > 0 {
-					logger.Debug("[data] HandleResponse produced output; forwarding to data WS")
-					conn2 := GetDataConn()
-					if conn2 != nil {
-						conn2.SetWriteDeadline(time.Now().Add(10 * time.Second))
-						_ = SafeWriteMessage(conn2, websocket.BinaryMessage, out)
-					}
-					continue
-				}
+		if !w.writeLoop(ctx, conn, msgReadStop) {
+			return
+		}
 
-				// 2️⃣ Depois tente os hooks Go internos
-				handledReq, _, errReq := TryHandleRequest(msg)
-				if errReq == nil && handledReq {
-					logger.Debug("[data] incoming message handled by HandleRequest hook")
-					continue
-				}
+		// short sleep before reconnect to avoid busy-loop
+		time.Sleep(800 * time.Millisecond)
+	}
+}
 
-				handledResp, outResp, errResp := TryHandleResponse(msg)
-				if errResp == nil && handledResp {
-					logger.Debug("[data] incoming message handled by HandleResponse hook")
-					_ = outResp
-					continue
-				}
+// closeConn derruba a conexão atual e falha os requests em voo.
+func (w *DataWorker) closeConn(conn *websocket.Conn, msgReadStop <-chan struct{}) {
+	w.setConn(nil)
+	conn.Close()
+	<-msgReadStop
+	w.failInFlight()
+}
 
-				// 3️⃣ Caso nada trate, apenas loga
-				logger.Debugf("[data] incoming message (len=%d)", len(msg))
-			}
-		}(conn)
-
-
-		// writer loop: consume SendQueue and write to socket
-		// note: read goroutine will close msgReadStop if conn dies
-		writerLoop:
-		for {
-			select {
-			case <-ctx.Done():
-				logger.Info("[data] context canceled -> close connection and exit")
-				setDataConn(nil)
-				conn.Close()
-				<-msgReadStop
+// failInFlight falha requests e acks em voo: os itens voltam para pendente.
+func (w *DataWorker) failInFlight() {
+	w.pending.FailAll(ErrConnectionLost)
+	w.acks.FailAll(ErrConnectionLost)
+	w.seqAcks.FailAll(ErrConnectionLost)
+}
+
+// readLoop processa os frames recebidos em c até a conexão cair.
+func (w *DataWorker) readLoop(c *websocket.Conn, msgReadStop chan struct{}) {
+	logger := w.logger
+	defer close(msgReadStop)
+	for {
+		mt, msg, err := c.ReadMessage()
+		if err != nil {
+			logger.Warnf("[%s] read error: %v", w.ID, err)
+			return
+		}
+
+		// frames de ack (modo "frame") confirmam envios pelo id
+		if mt == websocket.TextMessage && w.ackMode == AckFrame && w.handleAckFrame(msg) {
+			continue
+		}
+
+		job := &InboundJob{MessageType: mt, Msg: msg, worker: w, conn: c}
+
+		// 0️⃣ Respostas a requests que enviamos voltam para quem enviou
+		if resp, ok := decodeMitmResponse(msg); ok {
+			job.Response = resp
+			w.pending.Resolve(resp)
+			switch applyMitmStatus(resp, w.ID, w.pause) {
+			case statusReconnect:
+				c.Close()
 				return
-			case <-msgReadStop:
-				logger.Warn("[data] reader goroutine ended; will reconnect")
-				setDataConn(nil)
-				conn.Close()
-				break writerLoop
-			case item := <-SendQueue:
-
-				// First offer the raw payload to ELF hooks (HandleRequest). If a hook
-				// returns a buffer, send that instead and skip the normal compression step.
-				if out, err := TryProcessRequest(item.Payload); err == nil && len(out) > 0 {
-					logger.Debugf("[data] hook processed SendItem %s -> %d bytes; sending hook output", filepath.Base(item.Path), len(out))
-					// write hook output using the safe writer
-					conn.SetWriteDeadline(time.Now().Add(15 * time.Second))
-					if err := SafeWriteMessage(conn, websocket.BinaryMessage, out); err != nil {
-						logger.Warnf("[data] write hook output failed: %v; requeueing %s", err, item.Path)
-						requeue(item) // use your existing requeue helper (non-blocking)
-						// close & reconnect to force fresh connection
-						conn.Close()
-						setDataConn(nil)
-						<-msgReadStop
-						break writerLoop
-					} else {
-						// success: remove the file and continue to next item
-						if item.Path != "" {
-							_ = os.Remove(item.Path)
-							logger.Infof("[data] sent hook output and removed %s (%d bytes)", filepath.Base(item.Path), len(out))
-						} else {
-							logger.Infof("[data] sent hook output (%d bytes)", len(out))
-						}
-					}
-					// skip normal sending for this item
-					continue
+			case statusStopped:
+				w.session.Drain("ERROR_WORKER_STOPPED")
+			}
+		} else if req, ok := decodeMitmRequest(msg); ok {
+			// requests do Rotom precisam respeitar o estado da sessão
+			if rej := w.session.AcceptRequest(req); rej != nil {
+				if b, err := proto.Marshal(rej); err == nil {
+					_ = w.writeMessage(c, websocket.BinaryMessage, b, 10*time.Second)
 				}
+				continue
+			}
+			if lr := req.GetLoginRequest(); lr != nil {
+				w.setPeerCompression(lr.GetEnableCompression())
+			}
+			w.observeLocation(req)
+			job.Request = req
+		}
 
-				// If queue delivered a zero-value item (shouldn't happen) skip
-				if len(item.Payload) == 0 {
-					logger.Warn("[data] got empty SendItem payload; skipping")
-					// remove file to avoid infinite loop? keep original behaviour: try remove if exists
-					_ = os.Remove(item.Path)
-					continue
-				}
+		// 1️⃣ Hooks ELF e handlers Go, na ordem de rotom.dispatch_order
+		if w.inbound.Handle(job) {
+			continue
+		}
 
-				// prepare payload (compress if requested)
-				payload := item.Payload
-				if cfg.Rotom.UseCompression {
-					var buf bytes.Buffer
-					gw := gzip.NewWriter(&buf)
-					_, err := gw.Write(payload)
-					_ = gw.Close()
-					if err == nil {
-						payload = buf.Bytes()
-					} else {
-						logger.Warnf("[data] gzip compress failed: %v (sending uncompressed)", err)
-					}
-				}
+		// 2️⃣ Caso nada trate, apenas loga
+		logger.Debugf("[%s] incoming message (len=%d)", w.ID, len(msg))
+	}
+}
 
-				// try to write; set a write deadline
-				conn.SetWriteDeadline(time.Now().Add(15 * time.Second))
-				err := SafeWriteMessage(conn, websocket.BinaryMessage, payload)
-				if err != nil {
-					logger.Errorf("[data] write message failed: %v; requeueing %s", err, item.Path)
-					// requeue non-blocking: spawn goroutine to push back after small delay (avoid deadlock)
-					go func(it SendItem) {
-						time.Sleep(1 * time.Second)
-						// attempt to requeue within bounded time
-						select {
-						case SendQueue <- it:
-							// requeued
-						case <-time.After(5 * time.Second):
-							// if channel full, leave file on disk (scanner will keep it)
-							logger.Warnf("[data] could not requeue item (channel full), leaving file: %s", it.Path)
-						}
-					}(item)
-					// close conn and reconnect (reader goroutine will notice closure/err)
-					conn.Close()
-					setDataConn(nil)
-					<-msgReadStop
-					break writerLoop
-				} else {
-					// success: remove local file
-					if item.Path != "" {
-						if err := os.Remove(item.Path); err != nil {
-							logger.Warnf("[data] sent but failed to remove file %s: %v", item.Path, err)
-						} else {
-							logger.Infof("[data] sent and removed %s (%d bytes)", filepath.Base(item.Path), len(item.Payload))
-						}
-					} else {
-						logger.Infof("[data] sent payload (%d bytes) (no file path)", len(item.Payload))
-					}
-				}
+// writeLoop consome a partição do worker e escreve no socket. Retorna false
+// quando o contexto foi cancelado (o worker deve sair), true para reconectar.
+// note: read goroutine will close msgReadStop if conn dies
+func (w *DataWorker) writeLoop(ctx context.Context, conn *websocket.Conn, msgReadStop chan struct{}) bool {
+	logger := w.logger
 
-			}
+	for {
+		// ERROR_WORKER_STOPPED pausa o consumo; no resume a sessão é refeita do zero.
+		// stopped acorda o loop quando a pausa chega com ele esperando a fila.
+		var queue <-chan SendItem = w.queue
+		var resumed <-chan struct{}
+		stopped := w.pause.Stopped()
+		if paused, _ := w.pause.Paused(); paused {
+			queue, stopped = nil, nil
+			resumed = w.pause.Resumed()
 		}
 
-		// short sleep before reconnect to avoid busy-loop
-		time.Sleep(800 * time.Millisecond)
+		select {
+		case <-stopped:
+			continue
+		case <-resumed:
+			if w.session.State() != SessionDraining {
+				logger.Infof("[%s] worker resumed", w.ID)
+				continue
+			}
+			logger.Infof("[%s] worker resumed; reconnecting to restart session", w.ID)
+			w.closeConn(conn, msgReadStop)
+			return true
+		case <-ctx.Done():
+			logger.Infof("[%s] context canceled -> close connection and exit", w.ID)
+			w.session.Drain("shutdown")
+			w.closeConn(conn, msgReadStop)
+			return false
+		case <-msgReadStop:
+			logger.Warnf("[%s] reader goroutine ended; will reconnect", w.ID)
+			w.setConn(nil)
+			conn.Close()
+			w.failInFlight()
+			return true
+		case item := <-queue:
+			var err error
+			if w.pipeline.Batching() {
+				err = w.pipeline.SendBatch(ctx, w, conn, w.pipeline.collectBatch(w.queue, item))
+			} else {
+				err = w.pipeline.Send(ctx, w, conn, item)
+			}
+			if err != nil {
+				// item já foi reenfileirado pelo pipeline; refaz a conexão
+				w.closeConn(conn, msgReadStop)
+				return true
+			}
+		}
 	}
 }
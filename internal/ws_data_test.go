@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRouteSpoolSkipsPausedWorker(t *testing.T) {
+	cfg := testConfig(t)
+	s := openTestSpool(t, cfg)
+	pipeline := NewSendPipeline(cfg)
+	w1 := NewDataWorker(cfg, 1, pipeline, nil)
+	w2 := NewDataWorker(cfg, 2, pipeline, nil)
+	w1.pause.Pause("test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go routeSpool(ctx, s, []*DataWorker{w1, w2})
+
+	// um item preso ao worker pausado não pode travar os outros
+	if err := Enqueue(SendItem{Payload: []byte("pinned"), WorkerID: w1.ID}); err != nil {
+		t.Fatal(err)
+	}
+	const n = 20
+	for i := 0; i < n; i++ {
+		it := SendItem{Payload: []byte(fmt.Sprintf("payload-%d", i)), Path: fmt.Sprintf("/scan/file-%d.bin", i)}
+		if err := Enqueue(it); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.After(5 * time.Second)
+	for got := 0; got < n; got++ {
+		select {
+		case it := <-w2.queue:
+			if it.WorkerID == w1.ID {
+				t.Fatalf("item pinned to %s routed to %s", w1.ID, w2.ID)
+			}
+			ackItem(it)
+		case <-deadline:
+			t.Fatalf("%s got %d of %d items while %s was paused", w2.ID, got, n, w1.ID)
+		}
+	}
+	if len(w1.queue) != 0 {
+		t.Fatalf("paused worker queue has %d item(s)", len(w1.queue))
+	}
+
+	// no resume o item preso volta a ser entregue ao próprio worker
+	w1.pause.Resume()
+	select {
+	case it := <-w1.queue:
+		if string(it.Payload) != "pinned" {
+			t.Fatalf("unexpected item %q", it.Payload)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("pinned item not delivered after resume")
+	}
+}
+
+func TestWriteMessageSkipsSendLimiter(t *testing.T) {
+	old := sendLimiter.Limits()
+	sendLimiter.Set(RateLimits{MsgsPerSec: 1, BurstMsgs: 1})
+	defer sendLimiter.Set(old)
+
+	cfg := testConfig(t)
+	w := NewDataWorker(cfg, 1, NewSendPipeline(cfg), nil)
+	conn, frames := wsPair(t)
+
+	// respostas do worker (welcome, rejeições, replies) não esperam o balde
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := w.writeMessage(conn, websocket.BinaryMessage, []byte("reply"), time.Second); err != nil {
+			t.Fatal(err)
+		}
+		<-frames
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("three replies took %s at 1 msg/s", d)
+	}
+
+	// um envio do pipeline continua limitado
+	job := &SendJob{
+		Item:        SendItem{Payload: []byte("item")},
+		Payload:     []byte("item"),
+		MessageType: websocket.BinaryMessage,
+		Frame:       []byte("item"),
+		ctx:         context.Background(),
+		worker:      w,
+		conn:        conn,
+	}
+	start = time.Now()
+	for i := 0; i < 2; i++ {
+		if err := writeStage(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 500*time.Millisecond {
+		t.Fatalf("two pipeline writes took only %s at 1 msg/s", d)
+	}
+}
+
+func TestRequeueInMemoryItemRespectsPauseAndShutdown(t *testing.T) {
+	cfg := testConfig(t)
+	pipeline := NewSendPipeline(cfg)
+	w := NewDataWorker(cfg, 1, pipeline, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	setDataWorkers(ctx, []*DataWorker{w})
+	defer setDataWorkers(context.Background(), nil)
+
+	// pausado, o worker não recebe o item; no resume ele chega
+	w.pause.Pause("test")
+	requeueAfter(SendItem{Payload: []byte("first"), WorkerID: w.ID}, 0)
+	time.Sleep(300 * time.Millisecond)
+	if len(w.queue) != 0 {
+		t.Fatal("item routed to a paused worker")
+	}
+	w.pause.Resume()
+	select {
+	case it := <-w.queue:
+		if string(it.Payload) != "first" {
+			t.Fatalf("unexpected item %q", it.Payload)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("item not delivered after resume")
+	}
+
+	// no shutdown o item é descartado e quem esperava recebe o erro
+	w.pause.Pause("test")
+	got := make(chan PendingResult, 1)
+	requeueAfter(SendItem{Payload: []byte("second"), WorkerID: w.ID, OnResult: func(r PendingResult) { got <- r }}, 0)
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case r := <-got:
+		if r.Err == nil {
+			t.Fatal("OnResult called without an error")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("in-memory item still waiting after shutdown")
+	}
+	if len(w.queue) != 0 {
+		t.Fatal("item routed after shutdown")
+	}
+}
@@ -48,25 +48,29 @@ func main() {
 		}
 	}
 
+	// game protos for decoding RPC payloads in logs (optional)
+	if err := internal.LoadPogoDescriptors(cfg); err != nil {
+		log.Warnf("cannot load pogo descriptors: %v", err)
+	}
+
+	// open the persistent send spool (recovers items from a previous run)
+	spool, err := internal.OpenSendSpool(cfg)
+	if err != nil {
+		log.Fatalf("cannot open send spool %s: %v", cfg.Spool.Dir, err)
+	}
+
 	// start WS control loop (as goroutine)
 	ctx, cancel := context.WithCancel(context.Background())
 	go internal.ControlLoop(ctx, cfg)
 
-	// start data websocket
-	go internal.StartDataWs(ctx, cfg)
+	// Go handlers answer Rotom requests the hooks do not handle (see rotom.dispatch_order)
+	internal.RegisterDefaultHandlers()
 
-	// start scanner
-	go internal.ScannerLoop(ctx, cfg.General.ScanDir)
+	// start data websockets (one per worker, sharing one send pipeline)
+	internal.StartDataWs(ctx, cfg)
 
-	// start sender workers
-	workerCount := cfg.General.Workers
-	if workerCount < 1 {
-		workerCount = 1
-	}
-	for i := 0; i < workerCount; i++ {
-		go internal.SenderWorker(ctx, i+1)
-		time.Sleep(time.Duration(cfg.Tuning.WorkerSpawnDelayMs) * time.Millisecond)
-	}
+	// start scanner
+	go internal.ScannerLoop(ctx, cfg)
 
 	// handle signals
 	sig := make(chan os.Signal, 1)
@@ -76,5 +80,8 @@ func main() {
 	cancel()
 	// give goroutines time to stop gracefully
 	time.Sleep(800 * time.Millisecond)
+	if err := spool.Close(); err != nil {
+		log.Warnf("spool close: %v", err)
+	}
 	log.Info("rotom-worker stopped")
 }
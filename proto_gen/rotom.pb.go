@@ -655,6 +655,53 @@ func (x *WelcomeMessage) GetDeviceId() string {
 	return ""
 }
 
+// MitmBatch agrupa vários itens do worker num único frame do /data (modo
+// batching, opt-in). Cada entrada mantém o id do MitmRequest original, então
+// respostas e acks continuam sendo por item.
+type MitmBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Entries       []*MitmBatch_Entry     `protobuf:"bytes,1,rep,name=entries,proto3" json:"entries,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MitmBatch) Reset() {
+	*x = MitmBatch{}
+	mi := &file_proto_src_rotom_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MitmBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MitmBatch) ProtoMessage() {}
+
+func (x *MitmBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_src_rotom_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MitmBatch.ProtoReflect.Descriptor instead.
+func (*MitmBatch) Descriptor() ([]byte, []int) {
+	return file_proto_src_rotom_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *MitmBatch) GetEntries() []*MitmBatch_Entry {
+	if x != nil {
+		return x.Entries
+	}
+	return nil
+}
+
 type MitmRequest_LoginRequest struct {
 	state             protoimpl.MessageState               `protogen:"open.v1"`
 	Username          string                               `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
@@ -668,7 +715,7 @@ type MitmRequest_LoginRequest struct {
 
 func (x *MitmRequest_LoginRequest) Reset() {
 	*x = MitmRequest_LoginRequest{}
-	mi := &file_proto_src_rotom_proto_msgTypes[3]
+	mi := &file_proto_src_rotom_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -680,7 +727,7 @@ func (x *MitmRequest_LoginRequest) String() string {
 func (*MitmRequest_LoginRequest) ProtoMessage() {}
 
 func (x *MitmRequest_LoginRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_src_rotom_proto_msgTypes[3]
+	mi := &file_proto_src_rotom_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -742,7 +789,7 @@ type MitmRequest_RpcRequest struct {
 
 func (x *MitmRequest_RpcRequest) Reset() {
 	*x = MitmRequest_RpcRequest{}
-	mi := &file_proto_src_rotom_proto_msgTypes[4]
+	mi := &file_proto_src_rotom_proto_msgTypes[5]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -754,7 +801,7 @@ func (x *MitmRequest_RpcRequest) String() string {
 func (*MitmRequest_RpcRequest) ProtoMessage() {}
 
 func (x *MitmRequest_RpcRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_src_rotom_proto_msgTypes[4]
+	mi := &file_proto_src_rotom_proto_msgTypes[5]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -802,7 +849,7 @@ type MitmRequest_RpcRequest_SingleRpcRequest struct {
 
 func (x *MitmRequest_RpcRequest_SingleRpcRequest) Reset() {
 	*x = MitmRequest_RpcRequest_SingleRpcRequest{}
-	mi := &file_proto_src_rotom_proto_msgTypes[5]
+	mi := &file_proto_src_rotom_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -814,7 +861,7 @@ func (x *MitmRequest_RpcRequest_SingleRpcRequest) String() string {
 func (*MitmRequest_RpcRequest_SingleRpcRequest) ProtoMessage() {}
 
 func (x *MitmRequest_RpcRequest_SingleRpcRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_src_rotom_proto_msgTypes[5]
+	mi := &file_proto_src_rotom_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -863,7 +910,7 @@ type MitmResponse_LoginResponse struct {
 
 func (x *MitmResponse_LoginResponse) Reset() {
 	*x = MitmResponse_LoginResponse{}
-	mi := &file_proto_src_rotom_proto_msgTypes[6]
+	mi := &file_proto_src_rotom_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -875,7 +922,7 @@ func (x *MitmResponse_LoginResponse) String() string {
 func (*MitmResponse_LoginResponse) ProtoMessage() {}
 
 func (x *MitmResponse_LoginResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_src_rotom_proto_msgTypes[6]
+	mi := &file_proto_src_rotom_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -929,7 +976,7 @@ type MitmResponse_RpcResponse struct {
 
 func (x *MitmResponse_RpcResponse) Reset() {
 	*x = MitmResponse_RpcResponse{}
-	mi := &file_proto_src_rotom_proto_msgTypes[7]
+	mi := &file_proto_src_rotom_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -941,7 +988,7 @@ func (x *MitmResponse_RpcResponse) String() string {
 func (*MitmResponse_RpcResponse) ProtoMessage() {}
 
 func (x *MitmResponse_RpcResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_src_rotom_proto_msgTypes[7]
+	mi := &file_proto_src_rotom_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -982,7 +1029,7 @@ type MitmResponse_RpcResponse_SingleRpcResponse struct {
 
 func (x *MitmResponse_RpcResponse_SingleRpcResponse) Reset() {
 	*x = MitmResponse_RpcResponse_SingleRpcResponse{}
-	mi := &file_proto_src_rotom_proto_msgTypes[8]
+	mi := &file_proto_src_rotom_proto_msgTypes[9]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -994,7 +1041,7 @@ func (x *MitmResponse_RpcResponse_SingleRpcResponse) String() string {
 func (*MitmResponse_RpcResponse_SingleRpcResponse) ProtoMessage() {}
 
 func (x *MitmResponse_RpcResponse_SingleRpcResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_src_rotom_proto_msgTypes[8]
+	mi := &file_proto_src_rotom_proto_msgTypes[9]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1031,6 +1078,66 @@ func (x *MitmResponse_RpcResponse_SingleRpcResponse) GetIsCompressed() bool {
 	return false
 }
 
+type MitmBatch_Entry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint32                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Payload       []byte                 `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	IsCompressed  bool                   `protobuf:"varint,3,opt,name=is_compressed,json=isCompressed,proto3" json:"is_compressed,omitempty"` // payload já comprimido pelo worker
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MitmBatch_Entry) Reset() {
+	*x = MitmBatch_Entry{}
+	mi := &file_proto_src_rotom_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MitmBatch_Entry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MitmBatch_Entry) ProtoMessage() {}
+
+func (x *MitmBatch_Entry) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_src_rotom_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MitmBatch_Entry.ProtoReflect.Descriptor instead.
+func (*MitmBatch_Entry) Descriptor() ([]byte, []int) {
+	return file_proto_src_rotom_proto_rawDescGZIP(), []int{3, 0}
+}
+
+func (x *MitmBatch_Entry) GetId() uint32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *MitmBatch_Entry) GetPayload() []byte {
+	if x != nil {
+		return x.Payload
+	}
+	return nil
+}
+
+func (x *MitmBatch_Entry) GetIsCompressed() bool {
+	if x != nil {
+		return x.IsCompressed
+	}
+	return false
+}
+
 var File_proto_src_rotom_proto protoreflect.FileDescriptor
 
 const file_proto_src_rotom_proto_rawDesc = "" +
@@ -1106,7 +1213,13 @@ const file_proto_src_rotom_proto_rawDesc = "" +
 	"\fversion_code\x18\x03 \x01(\x05R\vversionCode\x12!\n" +
 	"\fversion_name\x18\x04 \x01(\tR\vversionName\x12\x1c\n" +
 	"\tuseragent\x18\x05 \x01(\tR\tuseragent\x12\x1b\n" +
-	"\tdevice_id\x18\x06 \x01(\tR\bdeviceId*\xa2\x03\n" +
+	"\tdevice_id\x18\x06 \x01(\tR\bdeviceId\"\x9b\x01\n" +
+	"\tMitmBatch\x126\n" +
+	"\aentries\x18\x01 \x03(\v2\x1c.RotomProtos.MitmBatch.EntryR\aentries\x1aV\n" +
+	"\x05Entry\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\rR\x02id\x12\x18\n" +
+	"\apayload\x18\x02 \x01(\fR\apayload\x12#\n" +
+	"\ris_compressed\x18\x03 \x01(\bR\fisCompressed*\xa2\x03\n" +
 	"\n" +
 	"AuthStatus\x12\x15\n" +
 	"\x11AUTH_STATUS_UNSET\x10\x00\x12)\n" +
@@ -1159,40 +1272,43 @@ func file_proto_src_rotom_proto_rawDescGZIP() []byte {
 }
 
 var file_proto_src_rotom_proto_enumTypes = make([]protoimpl.EnumInfo, 5)
-var file_proto_src_rotom_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_proto_src_rotom_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
 var file_proto_src_rotom_proto_goTypes = []any{
-	(AuthStatus)(0),                                    // 0: RotomProtos.AuthStatus
-	(RpcStatus)(0),                                     // 1: RotomProtos.RpcStatus
-	(MitmRequest_Method)(0),                            // 2: RotomProtos.MitmRequest.Method
+	(AuthStatus)(0),         // 0: RotomProtos.AuthStatus
+	(RpcStatus)(0),          // 1: RotomProtos.RpcStatus
+	(MitmRequest_Method)(0), // 2: RotomProtos.MitmRequest.Method
 	(MitmRequest_LoginRequest_LoginSource)(0),          // 3: RotomProtos.MitmRequest.LoginRequest.LoginSource
 	(MitmResponse_Status)(0),                           // 4: RotomProtos.MitmResponse.Status
 	(*MitmRequest)(nil),                                // 5: RotomProtos.MitmRequest
 	(*MitmResponse)(nil),                               // 6: RotomProtos.MitmResponse
 	(*WelcomeMessage)(nil),                             // 7: RotomProtos.WelcomeMessage
-	(*MitmRequest_LoginRequest)(nil),                   // 8: RotomProtos.MitmRequest.LoginRequest
-	(*MitmRequest_RpcRequest)(nil),                     // 9: RotomProtos.MitmRequest.RpcRequest
-	(*MitmRequest_RpcRequest_SingleRpcRequest)(nil),    // 10: RotomProtos.MitmRequest.RpcRequest.SingleRpcRequest
-	(*MitmResponse_LoginResponse)(nil),                 // 11: RotomProtos.MitmResponse.LoginResponse
-	(*MitmResponse_RpcResponse)(nil),                   // 12: RotomProtos.MitmResponse.RpcResponse
-	(*MitmResponse_RpcResponse_SingleRpcResponse)(nil), // 13: RotomProtos.MitmResponse.RpcResponse.SingleRpcResponse
+	(*MitmBatch)(nil),                                  // 8: RotomProtos.MitmBatch
+	(*MitmRequest_LoginRequest)(nil),                   // 9: RotomProtos.MitmRequest.LoginRequest
+	(*MitmRequest_RpcRequest)(nil),                     // 10: RotomProtos.MitmRequest.RpcRequest
+	(*MitmRequest_RpcRequest_SingleRpcRequest)(nil),    // 11: RotomProtos.MitmRequest.RpcRequest.SingleRpcRequest
+	(*MitmResponse_LoginResponse)(nil),                 // 12: RotomProtos.MitmResponse.LoginResponse
+	(*MitmResponse_RpcResponse)(nil),                   // 13: RotomProtos.MitmResponse.RpcResponse
+	(*MitmResponse_RpcResponse_SingleRpcResponse)(nil), // 14: RotomProtos.MitmResponse.RpcResponse.SingleRpcResponse
+	(*MitmBatch_Entry)(nil),                            // 15: RotomProtos.MitmBatch.Entry
 }
 var file_proto_src_rotom_proto_depIdxs = []int32{
 	2,  // 0: RotomProtos.MitmRequest.method:type_name -> RotomProtos.MitmRequest.Method
-	8,  // 1: RotomProtos.MitmRequest.login_request:type_name -> RotomProtos.MitmRequest.LoginRequest
-	9,  // 2: RotomProtos.MitmRequest.rpc_request:type_name -> RotomProtos.MitmRequest.RpcRequest
+	9,  // 1: RotomProtos.MitmRequest.login_request:type_name -> RotomProtos.MitmRequest.LoginRequest
+	10, // 2: RotomProtos.MitmRequest.rpc_request:type_name -> RotomProtos.MitmRequest.RpcRequest
 	4,  // 3: RotomProtos.MitmResponse.status:type_name -> RotomProtos.MitmResponse.Status
-	11, // 4: RotomProtos.MitmResponse.login_response:type_name -> RotomProtos.MitmResponse.LoginResponse
-	12, // 5: RotomProtos.MitmResponse.rpc_response:type_name -> RotomProtos.MitmResponse.RpcResponse
-	3,  // 6: RotomProtos.MitmRequest.LoginRequest.source:type_name -> RotomProtos.MitmRequest.LoginRequest.LoginSource
-	10, // 7: RotomProtos.MitmRequest.RpcRequest.request:type_name -> RotomProtos.MitmRequest.RpcRequest.SingleRpcRequest
-	0,  // 8: RotomProtos.MitmResponse.LoginResponse.status:type_name -> RotomProtos.AuthStatus
-	1,  // 9: RotomProtos.MitmResponse.RpcResponse.rpc_status:type_name -> RotomProtos.RpcStatus
-	13, // 10: RotomProtos.MitmResponse.RpcResponse.response:type_name -> RotomProtos.MitmResponse.RpcResponse.SingleRpcResponse
-	11, // [11:11] is the sub-list for method output_type
-	11, // [11:11] is the sub-list for method input_type
-	11, // [11:11] is the sub-list for extension type_name
-	11, // [11:11] is the sub-list for extension extendee
-	0,  // [0:11] is the sub-list for field type_name
+	12, // 4: RotomProtos.MitmResponse.login_response:type_name -> RotomProtos.MitmResponse.LoginResponse
+	13, // 5: RotomProtos.MitmResponse.rpc_response:type_name -> RotomProtos.MitmResponse.RpcResponse
+	15, // 6: RotomProtos.MitmBatch.entries:type_name -> RotomProtos.MitmBatch.Entry
+	3,  // 7: RotomProtos.MitmRequest.LoginRequest.source:type_name -> RotomProtos.MitmRequest.LoginRequest.LoginSource
+	11, // 8: RotomProtos.MitmRequest.RpcRequest.request:type_name -> RotomProtos.MitmRequest.RpcRequest.SingleRpcRequest
+	0,  // 9: RotomProtos.MitmResponse.LoginResponse.status:type_name -> RotomProtos.AuthStatus
+	1,  // 10: RotomProtos.MitmResponse.RpcResponse.rpc_status:type_name -> RotomProtos.RpcStatus
+	14, // 11: RotomProtos.MitmResponse.RpcResponse.response:type_name -> RotomProtos.MitmResponse.RpcResponse.SingleRpcResponse
+	12, // [12:12] is the sub-list for method output_type
+	12, // [12:12] is the sub-list for method input_type
+	12, // [12:12] is the sub-list for extension type_name
+	12, // [12:12] is the sub-list for extension extendee
+	0,  // [0:12] is the sub-list for field type_name
 }
 
 func init() { file_proto_src_rotom_proto_init() }
@@ -1214,7 +1330,7 @@ func file_proto_src_rotom_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_src_rotom_proto_rawDesc), len(file_proto_src_rotom_proto_rawDesc)),
 			NumEnums:      5,
-			NumMessages:   9,
+			NumMessages:   11,
 			NumExtensions: 0,
 			NumServices:   0,
 		},